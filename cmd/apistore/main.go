@@ -9,9 +9,19 @@ import (
 	"time"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/components"
+	"github.com/RavisMsk/xmcompanies/internal/api/importer"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importCompanies(os.Args[2:])
+		return
+	}
+
 	configPath := flag.String("config", "", "yaml config path")
 	flag.Parse()
 
@@ -25,7 +35,7 @@ func main() {
 	}
 
 	assembly.Run()
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 	<-sig
 
@@ -42,3 +52,55 @@ func main() {
 		assembly.Log.Fatal("gracefull shutdown timeout")
 	}
 }
+
+// migrate handles "apistore migrate -config <path> up|down|status".
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := flags.String("config", "", "yaml config path")
+	flags.Parse(args)
+
+	if len(*configPath) < 1 {
+		log.Fatalf("provide config path with -config")
+	}
+	if flags.NArg() != 1 {
+		log.Fatalf("usage: apistore migrate -config <path> up|down|status")
+	}
+
+	migrations, err := components.InitializeMigrations(*configPath)
+	if err != nil {
+		log.Fatalf("error initializing migrations: %s", err)
+	}
+	if err = migrations.Run(flags.Arg(0), os.Stdout); err != nil {
+		log.Fatalf("error running migrations: %s", err)
+	}
+}
+
+// importCompanies handles "apistore import -config <path> [flags] <file>".
+func importCompanies(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := flags.String("config", "", "yaml config path")
+	format := flags.String("format", "", "file format, csv or ndjson, guessed from extension by default")
+	upsert := flags.Bool("upsert", false, "update companies with the same code in country")
+	dryRun := flags.Bool("dry-run", false, "validate rows without writing them")
+	flags.Parse(args)
+
+	if len(*configPath) < 1 {
+		log.Fatalf("provide config path with -config")
+	}
+	if flags.NArg() != 1 {
+		log.Fatalf("usage: apistore import -config <path> [-format csv|ndjson] [-upsert] [-dry-run] <file>")
+	}
+
+	imp, err := components.InitializeImport(*configPath)
+	if err != nil {
+		log.Fatalf("error initializing import: %s", err)
+	}
+	opts := importer.Options{
+		Format: *format,
+		Upsert: *upsert,
+		DryRun: *dryRun,
+	}
+	if err = imp.Run(flags.Arg(0), opts, os.Stdout); err != nil {
+		log.Fatalf("error importing companies: %s", err)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
 	"github.com/RavisMsk/xmcompanies/internal/api/ipchecker"
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
 	"github.com/RavisMsk/xmcompanies/internal/pkg/structs"
 )
 
@@ -22,14 +24,22 @@ type Config interface {
 	GetListenAddr() string
 	GetTimeoutDuration() time.Duration
 	GetAllowedCountries() []string
+	GetMaxBatchSize() int
+	// GetConfirmSecret may be empty in debug only, confirmation
+	// tokens are then valid within the process that issued them.
+	GetConfirmSecret() string
 }
 
 type API struct {
 	cfg       Config
 	companies companies.Companies
+	webhooks  webhooks.Webhooks
 	ipChecker ipchecker.Checker
 	log       *zap.Logger
 
+	// confirmKey signs bulk operation confirmation tokens.
+	confirmKey []byte
+
 	stopping int32
 	wg       sync.WaitGroup
 }
@@ -37,21 +47,31 @@ type API struct {
 func NewAPI(
 	cfg Config,
 	companies companies.Companies,
+	webhooks webhooks.Webhooks,
 	ipChecker ipchecker.Checker,
 	log *zap.Logger,
 ) *API {
-	return &API{
-		cfg:       cfg,
-		companies: companies,
-		ipChecker: ipChecker,
-		log:       log,
+	a := &API{
+		cfg:        cfg,
+		companies:  companies,
+		webhooks:   webhooks,
+		ipChecker:  ipChecker,
+		log:        log,
+		confirmKey: []byte(cfg.GetConfirmSecret()),
+	}
+	if len(a.confirmKey) < 1 && cfg.GetDebug() {
+		a.confirmKey = newConfirmKey()
 	}
+	return a
 }
 
 func (a *API) Run() error {
 	if !a.cfg.GetDebug() {
 		gin.SetMode(gin.ReleaseMode)
 	}
+	if len(a.confirmKey) < 1 {
+		return errors.New("confirm_secret is required unless debug is on")
+	}
 
 	r := a.createEngine()
 	go func() {
@@ -86,19 +106,69 @@ func (a *API) createEngine() *gin.Engine {
 	allowedCountries.Add(a.cfg.GetAllowedCountries()...)
 
 	v1 := r.Group("/v1")
+	v1.GET("/cache/stats", a.wrapHandler(a.handleCacheStats))
 	v1.GET("/companies", a.wrapHandler(a.handleListCompanies))
+	v1.HEAD("/companies", a.wrapHandler(a.handleCountCompanies))
+	v1.GET("/companies/trash", a.wrapHandler(a.handleListTrash))
+	v1.GET("/companies/export", a.wrapHandler(a.handleExportCompanies))
 	v1.GET("/companies/:companyID", a.wrapHandler(a.handleGetCompany))
-	v1.PUT("/companies/:companyID", a.wrapHandler(a.handleUpdateCompany))
+	v1.GET("/companies/:companyID/history", a.wrapHandler(a.handleCompanyHistory))
+	v1.PUT(
+		"/companies/:companyID",
+		ClientCountryMiddleware(a.ipChecker),
+		a.wrapHandler(a.handleUpdateCompany),
+	)
+	v1.PATCH(
+		"/companies/:companyID",
+		ClientCountryMiddleware(a.ipChecker),
+		a.wrapHandler(a.handlePatchCompany),
+	)
 	v1.POST(
 		"/companies",
 		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
 		a.wrapHandler(a.handleCreateCompany),
 	)
+	// Custom methods like :batch share a single route,
+	// the router has no static segments after a wildcard.
+	v1.POST(
+		"/companies:method",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleCompaniesMethod),
+	)
 	v1.DELETE(
 		"/companies/:companyID",
 		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
 		a.wrapHandler(a.handleDeleteCompany),
 	)
+	v1.POST(
+		"/companies/:companyID/restore",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleRestoreCompany),
+	)
+
+	v1.GET("/webhooks", a.wrapHandler(a.handleListWebhooks))
+	v1.GET("/webhooks/:subscriptionID", a.wrapHandler(a.handleGetWebhook))
+	v1.GET("/webhooks/:subscriptionID/deliveries", a.wrapHandler(a.handleWebhookDeliveries))
+	v1.POST(
+		"/webhooks",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleCreateWebhook),
+	)
+	v1.PUT(
+		"/webhooks/:subscriptionID",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleUpdateWebhook),
+	)
+	v1.DELETE(
+		"/webhooks/:subscriptionID",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleDeleteWebhook),
+	)
+	v1.POST(
+		"/webhooks/:subscriptionID/deliveries/:deliveryID/redeliver",
+		IPCheckingMiddleware(a.ipChecker, *allowedCountries),
+		a.wrapHandler(a.handleRedeliverWebhook),
+	)
 
 	return r
 }
@@ -112,6 +182,10 @@ func (a *API) reqSetupMiddleware(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.GetTimeoutDuration())
 	defer cancel()
+	ctx = companies.WithActor(ctx, companies.Actor{
+		RequestID: reqID,
+		ClientIP:  c.ClientIP(),
+	})
 
 	setReqID(c, reqID)
 	setLogger(c, reqLogger)
@@ -7,11 +7,14 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
 	"github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/structs"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -26,6 +29,8 @@ func (c *testConfig) GetDebug() bool                    { return true }
 func (c *testConfig) GetListenAddr() string             { return "" }
 func (c *testConfig) GetTimeoutDuration() time.Duration { return 10 * time.Second }
 func (c *testConfig) GetAllowedCountries() []string     { return []string{allowedTestCountry} }
+func (c *testConfig) GetMaxBatchSize() int              { return 3 }
+func (c *testConfig) GetConfirmSecret() string          { return "test confirm secret" }
 
 func createTestAPI(
 	companies *companiesLayerMock,
@@ -33,7 +38,16 @@ func createTestAPI(
 ) *API {
 	log, _ := zap.NewDevelopment()
 	var cfg testConfig
-	return NewAPI(&cfg, companies, ipChecker, log)
+	return NewAPI(&cfg, companies, &webhooksMock{}, ipChecker, log)
+}
+
+func createTestWebhooksAPI(
+	webhooks *webhooksMock,
+	ipChecker *ipCheckerMock,
+) *API {
+	log, _ := zap.NewDevelopment()
+	var cfg testConfig
+	return NewAPI(&cfg, &companiesLayerMock{}, webhooks, ipChecker, log)
 }
 
 type companiesLayerMock struct {
@@ -43,11 +57,47 @@ type companiesLayerMock struct {
 func (m *companiesLayerMock) Search(
 	ctx context.Context,
 	query companies.SearchFilters,
-	skip,
+	cursor string,
 	limit uint64,
-) ([]*models.Company, error) {
-	args := m.Called(query, skip, limit)
-	return args.Get(0).([]*models.Company), args.Error(1)
+) ([]*models.Company, string, error) {
+	args := m.Called(query, cursor, limit)
+	return args.Get(0).([]*models.Company), args.String(1), args.Error(2)
+}
+func (m *companiesLayerMock) TextSearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	args := m.Called(query, cursor, limit)
+	return args.Get(0).([]*models.ScoredCompany), args.String(1), args.Error(2)
+}
+func (m *companiesLayerMock) FuzzySearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	name companies.FuzzyName,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	args := m.Called(query, name, cursor, limit)
+	return args.Get(0).([]*models.ScoredCompany), args.String(1), args.Error(2)
+}
+func (m *companiesLayerMock) Export(
+	ctx context.Context,
+	query companies.SearchFilters,
+	fn func(*models.Company) error,
+) error {
+	args := m.Called(query)
+	for _, company := range args.Get(0).([]*models.Company) {
+		if err := fn(company); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+func (m *companiesLayerMock) Count(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	args := m.Called(query)
+	return args.Get(0).(uint64), args.Error(1)
 }
 func (m *companiesLayerMock) Get(ctx context.Context, id string) (*models.Company, error) {
 	args := m.Called(id)
@@ -57,18 +107,116 @@ func (m *companiesLayerMock) Get(ctx context.Context, id string) (*models.Compan
 	}
 	return company, args.Error(1)
 }
+func (m *companiesLayerMock) GetAsOf(ctx context.Context, id string, asOf time.Time) (*models.Company, error) {
+	args := m.Called(id, asOf)
+	var company *models.Company
+	if args.Get(0) != nil {
+		company = args.Get(0).(*models.Company)
+	}
+	return company, args.Error(1)
+}
 func (m *companiesLayerMock) Create(ctx context.Context, fields companies.CompanyFields) (string, error) {
 	args := m.Called(fields)
 	return args.String(0), args.Error(1)
 }
-func (m *companiesLayerMock) Update(ctx context.Context, id string, update companies.UpdateFields) error {
-	args := m.Called(id, update)
+func (m *companiesLayerMock) CreateBatch(
+	ctx context.Context,
+	items []companies.CompanyFields,
+	atomic bool,
+) ([]companies.BatchResult, error) {
+	args := m.Called(items, atomic)
+	results, _ := args.Get(0).([]companies.BatchResult)
+	return results, args.Error(1)
+}
+func (m *companiesLayerMock) BulkUpdate(
+	ctx context.Context,
+	query companies.SearchFilters,
+	update companies.UpdateFields,
+) (uint64, error) {
+	args := m.Called(query, update)
+	return args.Get(0).(uint64), args.Error(1)
+}
+func (m *companiesLayerMock) BulkDelete(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	args := m.Called(query)
+	return args.Get(0).(uint64), args.Error(1)
+}
+func (m *companiesLayerMock) Update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	update companies.UpdateFields,
+) error {
+	args := m.Called(id, version, update)
+	return args.Error(0)
+}
+func (m *companiesLayerMock) Delete(ctx context.Context, id string, version uint64) error {
+	args := m.Called(id, version)
+	return args.Error(0)
+}
+func (m *companiesLayerMock) Trash(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	args := m.Called(skip, limit)
+	return args.Get(0).([]*models.Company), args.Error(1)
+}
+func (m *companiesLayerMock) Restore(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+func (m *companiesLayerMock) History(
+	ctx context.Context,
+	id string,
+	skip,
+	limit uint64,
+) ([]*models.HistoryEntry, error) {
+	args := m.Called(id, skip, limit)
+	return args.Get(0).([]*models.HistoryEntry), args.Error(1)
+}
+func (m *companiesLayerMock) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	args := m.Called(deletedBefore)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+type webhooksMock struct {
+	mock.Mock
+}
+
+func (m *webhooksMock) Create(
+	ctx context.Context,
+	fields webhooks.SubscriptionFields,
+) (*models.Subscription, error) {
+	args := m.Called(fields)
+	subscription, _ := args.Get(0).(*models.Subscription)
+	return subscription, args.Error(1)
+}
+func (m *webhooksMock) Get(ctx context.Context, id string) (*models.Subscription, error) {
+	args := m.Called(id)
+	subscription, _ := args.Get(0).(*models.Subscription)
+	return subscription, args.Error(1)
+}
+func (m *webhooksMock) List(ctx context.Context) ([]*models.Subscription, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Subscription), args.Error(1)
+}
+func (m *webhooksMock) Update(ctx context.Context, id string, fields webhooks.SubscriptionFields) error {
+	args := m.Called(id, fields)
 	return args.Error(0)
 }
-func (m *companiesLayerMock) Delete(ctx context.Context, id string) error {
+func (m *webhooksMock) Delete(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
+func (m *webhooksMock) Deliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*models.Delivery, error) {
+	args := m.Called(subscriptionID, skip, limit)
+	deliveries, _ := args.Get(0).([]*models.Delivery)
+	return deliveries, args.Error(1)
+}
+func (m *webhooksMock) Redeliver(ctx context.Context, subscriptionID, deliveryID string) error {
+	args := m.Called(subscriptionID, deliveryID)
+	return args.Error(0)
+}
 
 type ipCheckerMock struct {
 	mock.Mock
@@ -79,6 +227,13 @@ func (m *ipCheckerMock) GetIPCountry(ip string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+// allowedIPChecker places every client in an allowed country.
+func allowedIPChecker() *ipCheckerMock {
+	checker := &ipCheckerMock{}
+	checker.On("GetIPCountry", mock.Anything).Return(allowedTestCountry, nil)
+	return checker
+}
+
 func TestModifyCompanyFromForbiddenAddress(t *testing.T) {
 	t.Run("create", func(t *testing.T) {
 		comps := &companiesLayerMock{}
@@ -119,6 +274,22 @@ var validCompany = models.Company{
 	Country: "Cyprus",
 	Website: "http://company.valid/",
 	Phone:   "79991234567",
+	Version: 3,
+}
+
+// validCompanyBody is the full representation of validCompany PUT
+// needs, validReplaceFields is the update it makes.
+const validCompanyBody = `{"name":"Valid Name","code":"VN","country":"Cyprus","website":"http://company.valid/","phone":"79991234567"}`
+
+func validReplaceFields() companies.UpdateFields {
+	company := validCompany
+	return companies.UpdateFields{
+		Name:    &company.Name,
+		Code:    &company.Code,
+		Country: &company.Country,
+		Website: &company.Website,
+		Phone:   &company.Phone,
+	}
 }
 
 func TestCreateCompany(t *testing.T) {
@@ -266,6 +437,9 @@ func TestCreateCompany(t *testing.T) {
 				err := json.Unmarshal(w.Body.Bytes(), &response)
 				assert.NoError(t, err)
 				assert.Equal(t, cs.errorsCnt, len(response["errors"].([]interface{})))
+				for _, message := range response["errors"].([]interface{}) {
+					assert.NotEmpty(t, message.(string))
+				}
 			}
 
 			checker.AssertExpectations(t)
@@ -293,10 +467,12 @@ func TestCreateCompany(t *testing.T) {
 	})
 }
 
-func TestGetCompany(t *testing.T) {
-	t.Run("existing company", func(t *testing.T) {
+func TestListCompanies(t *testing.T) {
+	t.Run("first page", func(t *testing.T) {
+		country := "Cyprus"
 		comps := &companiesLayerMock{}
-		comps.On("Get", "1234").Return(&validCompany, nil)
+		comps.On("Search", companies.SearchFilters{Country: companies.StringFilter{Eq: &country}}, "", uint64(2)).
+			Return([]*models.Company{&validCompany, &validCompany}, "next", nil)
 
 		checker := &ipCheckerMock{}
 
@@ -304,27 +480,51 @@ func TestGetCompany(t *testing.T) {
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
-		req.RemoteAddr = "44.44.44.44:54321"
+		req, _ := http.NewRequest("GET", "/v1/companies?country=Cyprus&limit=2", nil)
 		engine.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		var response gin.H
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.Equal(t, validCompany.ID, response["id"])
-		assert.Equal(t, validCompany.Name, response["name"])
-		assert.Equal(t, validCompany.Code, response["code"])
-		assert.Equal(t, validCompany.Country, response["country"])
-		assert.Equal(t, validCompany.Website, response["website"])
-		assert.Equal(t, validCompany.Phone, response["phone"])
+		assert.Equal(t, 2, len(response["results"].([]interface{})))
+		assert.Equal(t, "next", response["next_cursor"])
+		meta := response["meta"].(map[string]interface{})
+		assert.Equal(t, float64(2), meta["limit"])
+		assert.Equal(t, true, meta["has_more"])
+		assert.NotContains(t, meta, "total")
+		comps.AssertExpectations(t)
+	})
 
-		checker.AssertExpectations(t)
+	t.Run("last page", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Search", companies.SearchFilters{}, "next", uint64(20)).
+			Return([]*models.Company{&validCompany}, "", nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?cursor=next", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(response["results"].([]interface{})))
+		assert.NotContains(t, response, "next_cursor")
+		assert.Equal(t, false, response["meta"].(map[string]interface{})["has_more"])
+		comps.AssertExpectations(t)
 	})
 
-	t.Run("non-existent company", func(t *testing.T) {
+	t.Run("include total", func(t *testing.T) {
 		comps := &companiesLayerMock{}
-		comps.On("Get", "1234").Return(nil, companies.ErrNotFound)
+		comps.On("Search", companies.SearchFilters{}, "", uint64(20)).
+			Return([]*models.Company{&validCompany}, "", nil)
+		comps.On("Count", companies.SearchFilters{}).Return(uint64(1), nil)
 
 		checker := &ipCheckerMock{}
 
@@ -332,16 +532,26 @@ func TestGetCompany(t *testing.T) {
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
-		req.RemoteAddr = "44.44.44.44:54321"
+		req, _ := http.NewRequest("GET", "/v1/companies?include_total=true", nil)
 		engine.ServeHTTP(w, req)
-		assert.Equal(t, http.StatusNotFound, w.Code)
-		checker.AssertExpectations(t)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(1), response["meta"].(map[string]interface{})["total"])
+		comps.AssertExpectations(t)
 	})
 
-	t.Run("unexpected get error", func(t *testing.T) {
+	t.Run("sorted", func(t *testing.T) {
+		query := companies.SearchFilters{
+			Sort: []companies.SortField{
+				{Field: "created_at", Desc: true},
+				{Field: "name"},
+			},
+		}
 		comps := &companiesLayerMock{}
-		comps.On("Get", "1234").Return(nil, errors.New("unexpected error"))
+		comps.On("Search", query, "", uint64(20)).Return([]*models.Company{&validCompany}, "", nil)
 
 		checker := &ipCheckerMock{}
 
@@ -349,69 +559,1576 @@ func TestGetCompany(t *testing.T) {
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
-		req.RemoteAddr = "44.44.44.44:54321"
+		req, _ := http.NewRequest("GET", "/v1/companies?sort=-created_at,name", nil)
 		engine.ServeHTTP(w, req)
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
-		checker.AssertExpectations(t)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
 	})
-}
 
-func TestDeleteCompany(t *testing.T) {
-	t.Run("successful delete", func(t *testing.T) {
+	for _, sort := range []string{"id", "-version", "name,-name", "name,"} {
+		t.Run("invalid sort "+sort, func(t *testing.T) {
+			comps := &companiesLayerMock{}
+			checker := &ipCheckerMock{}
+
+			api := createTestAPI(comps, checker)
+			engine := api.createEngine()
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/v1/companies?sort="+sort, nil)
+			engine.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			comps.AssertExpectations(t)
+		})
+	}
+
+	t.Run("filter operators", func(t *testing.T) {
+		namePrefix, websiteContains := "Fir", "example"
+		createdAfter := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+		query := companies.SearchFilters{
+			Name:      companies.StringFilter{Prefix: &namePrefix},
+			Website:   companies.StringFilter{Contains: &websiteContains},
+			Country:   companies.StringFilter{In: []string{"Cyprus", "Greece"}},
+			CreatedAt: companies.TimeRange{After: &createdAfter},
+		}
 		comps := &companiesLayerMock{}
-		comps.On("Delete", "1234").Return(nil)
+		comps.On("Search", mock.MatchedBy(func(actual companies.SearchFilters) bool {
+			return assert.ObjectsAreEqualValues(query.Name, actual.Name) &&
+				assert.ObjectsAreEqualValues(query.Website, actual.Website) &&
+				assert.ObjectsAreEqualValues(query.Country, actual.Country) &&
+				actual.CreatedAt.After.Equal(createdAfter) &&
+				actual.CreatedAt.Before == nil
+		}), "", uint64(20)).Return([]*models.Company{&validCompany}, "", nil)
 
 		checker := &ipCheckerMock{}
-		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
 
 		api := createTestAPI(comps, checker)
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
-		req.RemoteAddr = "44.44.44.44:54321"
+		req, _ := http.NewRequest(
+			"GET",
+			"/v1/companies?name_prefix=Fir&website_contains=example&country=Cyprus,Greece&created_after=2022-01-02T03:04:05Z",
+			nil,
+		)
 		engine.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		checker.AssertExpectations(t)
+		comps.AssertExpectations(t)
 	})
 
-	t.Run("not found", func(t *testing.T) {
+	t.Run("invalid time filter", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?updated_before=yesterday", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("text search", func(t *testing.T) {
+		text := "acme trading"
 		comps := &companiesLayerMock{}
-		comps.On("Delete", "1234").Return(companies.ErrNotFound)
+		comps.On("TextSearch", companies.SearchFilters{Text: &text}, "", uint64(20)).
+			Return([]*models.ScoredCompany{{Company: validCompany, Score: 1.5}}, "", nil)
 
 		checker := &ipCheckerMock{}
-		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
 
 		api := createTestAPI(comps, checker)
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
-		req.RemoteAddr = "44.44.44.44:54321"
+		req, _ := http.NewRequest("GET", "/v1/companies?q=acme+trading", nil)
 		engine.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusNotFound, w.Code)
-		checker.AssertExpectations(t)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		result := response["results"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, validCompany.ID, result["id"])
+		assert.Equal(t, 1.5, result["score"])
+		comps.AssertExpectations(t)
 	})
 
-	t.Run("unexpected error", func(t *testing.T) {
+	t.Run("sorted text search", func(t *testing.T) {
+		text := "acme"
+		query := companies.SearchFilters{
+			Text: &text,
+			Sort: []companies.SortField{{Field: "name"}},
+		}
 		comps := &companiesLayerMock{}
-		comps.On("Delete", "1234").Return(errors.New("unexpected error"))
+		comps.On("Search", query, "", uint64(20)).Return([]*models.Company{&validCompany}, "", nil)
 
 		checker := &ipCheckerMock{}
-		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
 
 		api := createTestAPI(comps, checker)
 		engine := api.createEngine()
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req, _ := http.NewRequest("GET", "/v1/companies?q=acme&sort=name", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("fuzzy name search", func(t *testing.T) {
+		country := "Cyprus"
+		comps := &companiesLayerMock{}
+		comps.On(
+			"FuzzySearch",
+			companies.SearchFilters{Country: companies.StringFilter{Eq: &country}},
+			companies.FuzzyName{Name: "Acme Tradng", Similarity: 0.5},
+			"",
+			uint64(20),
+		).Return([]*models.ScoredCompany{{Company: validCompany, Score: 0.67}}, "", nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?name_fuzzy=Acme+Tradng&similarity=0.5&country=Cyprus", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		result := response["results"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, validCompany.ID, result["id"])
+		assert.Equal(t, 0.67, result["score"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("fuzzy name default similarity", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On(
+			"FuzzySearch",
+			companies.SearchFilters{},
+			companies.FuzzyName{Name: "acme", Similarity: 0.3},
+			"",
+			uint64(20),
+		).Return([]*models.ScoredCompany{}, "", nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?name_fuzzy=acme", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid fuzzy name search", func(t *testing.T) {
+		for _, params := range []string{
+			"name_fuzzy=",
+			"name_fuzzy=acme&similarity=0",
+			"name_fuzzy=acme&similarity=1.5",
+			"name_fuzzy=acme&similarity=high",
+			"similarity=0.5",
+			"name_fuzzy=acme&q=acme",
+			"name_fuzzy=acme&sort=name",
+			"name_fuzzy=acme&include_total=true",
+		} {
+			comps := &companiesLayerMock{}
+			checker := &ipCheckerMock{}
+
+			api := createTestAPI(comps, checker)
+			engine := api.createEngine()
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/v1/companies?"+params, nil)
+			engine.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, params)
+			comps.AssertExpectations(t)
+		}
+	})
+
+	t.Run("invalid include total", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?include_total=maybe", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("head total count", func(t *testing.T) {
+		country := "Cyprus"
+		comps := &companiesLayerMock{}
+		comps.On("Count", companies.SearchFilters{Country: companies.StringFilter{Eq: &country}}).Return(uint64(42), nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("HEAD", "/v1/companies?country=Cyprus", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "42", w.Header().Get("X-Total-Count"))
+		assert.Empty(t, w.Body.Bytes())
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Search", companies.SearchFilters{}, "bogus", uint64(20)).
+			Return([]*models.Company(nil), "", companies.ErrInvalidCursor)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies?cursor=bogus", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestGetCompany(t *testing.T) {
+	t.Run("existing company", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
 		req.RemoteAddr = "44.44.44.44:54321"
 		engine.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, validCompany.ID, response["id"])
+		assert.Equal(t, validCompany.Name, response["name"])
+		assert.Equal(t, validCompany.Code, response["code"])
+		assert.Equal(t, validCompany.Country, response["country"])
+		assert.Equal(t, validCompany.Website, response["website"])
+		assert.Equal(t, validCompany.Phone, response["phone"])
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+		checker.AssertExpectations(t)
+	})
+
+	t.Run("non-existent company", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(nil, companies.ErrNotFound)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		checker.AssertExpectations(t)
+	})
+
+	t.Run("as of past time", func(t *testing.T) {
+		asOf, _ := time.Parse(time.RFC3339, "2022-06-01T10:00:00Z")
+		comps := &companiesLayerMock{}
+		comps.On("GetAsOf", "1234", asOf).Return(&validCompany, nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234?as_of=2022-06-01T10:00:00Z", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, validCompany.Name, response["name"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("as of before creation", func(t *testing.T) {
+		asOf, _ := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+		comps := &companiesLayerMock{}
+		comps.On("GetAsOf", "1234", asOf).Return(nil, companies.ErrNotFound)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234?as_of=2000-01-01T00:00:00Z", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid as of", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234?as_of=yesterday", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unexpected get error", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(nil, errors.New("unexpected error"))
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		checker.AssertExpectations(t)
+	})
+}
+
+func TestDeleteCompany(t *testing.T) {
+	t.Run("successful delete", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Delete", "1234", companies.AnyVersion).Return(nil)
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		checker.AssertExpectations(t)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Delete", "1234", companies.AnyVersion).Return(companies.ErrNotFound)
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		checker.AssertExpectations(t)
+	})
+
+	t.Run("unexpected error", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Delete", "1234", companies.AnyVersion).Return(errors.New("unexpected error"))
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		checker.AssertExpectations(t)
+	})
+}
+
+func TestConditionalModifyCompany(t *testing.T) {
+	t.Run("update with matching version", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Update", "1234", uint64(3), validReplaceFields()).Return(nil)
+
+		checker := allowedIPChecker()
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/companies/1234", strings.NewReader(validCompanyBody))
+		req.Header.Set("If-Match", `"3"`)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("update with stale version", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Update", "1234", uint64(2), validReplaceFields()).Return(companies.ErrConflict)
+
+		checker := allowedIPChecker()
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/companies/1234", strings.NewReader(validCompanyBody))
+		req.Header.Set("If-Match", `"2"`)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("delete with stale version", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Delete", "1234", uint64(2)).Return(companies.ErrConflict)
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		req.Header.Set("If-Match", `"2"`)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("malformed if-match", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/companies/1234", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		req.Header.Set("If-Match", `W/"2"`)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestTrash(t *testing.T) {
+	t.Run("list trash", func(t *testing.T) {
+		deletedAt := time.Now()
+		deleted := validCompany
+		deleted.DeletedAt = &deletedAt
+
+		comps := &companiesLayerMock{}
+		comps.On("Trash", uint64(0), uint64(20)).Return([]*models.Company{&deleted}, nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/trash", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		results := response["results"].([]interface{})
+		assert.Equal(t, 1, len(results))
+		assert.Equal(t, validCompany.ID, results[0].(map[string]interface{})["id"])
+		assert.NotNil(t, results[0].(map[string]interface{})["deleted_at"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Restore", "1234").Return(nil)
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/companies/1234/restore", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
 		checker.AssertExpectations(t)
 	})
+
+	t.Run("restore not in trash", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Restore", "1234").Return(companies.ErrNotFound)
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/companies/1234/restore", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestCompanyHistory(t *testing.T) {
+	t.Run("list history", func(t *testing.T) {
+		entries := []*models.HistoryEntry{
+			{
+				CompanyID: "1234",
+				Action:    "create",
+				After:     &models.CompanySnapshot{Name: "Valid Name", Version: 1},
+				RequestID: "req",
+				ClientIP:  "44.44.44.44",
+				CreatedAt: time.Now(),
+			},
+		}
+		comps := &companiesLayerMock{}
+		comps.On("History", "1234", uint64(2), uint64(10)).Return(entries, nil)
+
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/1234/history?offset=2&limit=10", nil)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		results := response["results"].([]interface{})
+		assert.Equal(t, 1, len(results))
+		assert.Equal(t, "create", results[0].(map[string]interface{})["action"])
+		assert.Nil(t, results[0].(map[string]interface{})["before"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("actor set from request", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		allowedCountries := structs.NewStringSet()
+		allowedCountries.Add(allowedTestCountry)
+		var actor companies.Actor
+		engine.POST("/test/actor", IPCheckingMiddleware(checker, *allowedCountries), func(c *gin.Context) {
+			actor = companies.ActorFromContext(getCtx(c))
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/test/actor", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, actor.RequestID)
+		assert.Equal(t, "44.44.44.44", actor.ClientIP)
+		assert.Equal(t, allowedTestCountry, actor.ClientCountry)
+	})
+	t.Run("country set on updates from any country", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return("Unwhitelisted", nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		var actor companies.Actor
+		engine.PUT("/test/actor", ClientCountryMiddleware(checker), func(c *gin.Context) {
+			actor = companies.ActorFromContext(getCtx(c))
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/test/actor", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Unwhitelisted", actor.ClientCountry)
+	})
+}
+
+func TestCreateCompanyUnrecorded(t *testing.T) {
+	comps := &companiesLayerMock{}
+	comps.On("Create", companies.CompanyFields{
+		Name:    validCompany.Name,
+		Code:    validCompany.Code,
+		Country: validCompany.Country,
+		Website: validCompany.Website,
+		Phone:   validCompany.Phone,
+	}).Return("1234", errors.New("history unavailable"))
+
+	checker := &ipCheckerMock{}
+	checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+	engine := createTestAPI(comps, checker).createEngine()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/companies", strings.NewReader(validCompanyBody))
+	req.RemoteAddr = "44.44.44.44:54321"
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var response gin.H
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", response["id"])
+	comps.AssertExpectations(t)
+}
+
+func TestDuplicateCompany(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Create", companies.CompanyFields{
+			Name:    validCompany.Name,
+			Code:    validCompany.Code,
+			Country: validCompany.Country,
+			Website: validCompany.Website,
+			Phone:   validCompany.Phone,
+		}).Return("", &companies.DuplicateError{ExistingID: "4321"})
+
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		bodyBytes, _ := json.Marshal(gin.H{
+			"name":    validCompany.Name,
+			"code":    validCompany.Code,
+			"country": validCompany.Country,
+			"website": validCompany.Website,
+			"phone":   validCompany.Phone,
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/companies", bytes.NewReader(bodyBytes))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "4321", response["existing_id"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Update", "1234", companies.AnyVersion, validReplaceFields()).
+			Return(&companies.DuplicateError{ExistingID: "4321"})
+
+		checker := allowedIPChecker()
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/companies/1234", strings.NewReader(validCompanyBody))
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var response gin.H
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "4321", response["existing_id"])
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestCreateCompanies(t *testing.T) {
+	validFields := companies.CompanyFields{
+		Name:    validCompany.Name,
+		Code:    validCompany.Code,
+		Country: validCompany.Country,
+		Website: validCompany.Website,
+		Phone:   validCompany.Phone,
+	}
+	validItem := gin.H{
+		"name":    validCompany.Name,
+		"code":    validCompany.Code,
+		"country": validCompany.Country,
+		"website": validCompany.Website,
+		"phone":   validCompany.Phone,
+	}
+	invalidItem := gin.H{
+		"name":    "X",
+		"code":    "lower",
+		"country": validCompany.Country,
+		"website": validCompany.Website,
+	}
+
+	createBatch := func(comps *companiesLayerMock, body gin.H) (*httptest.ResponseRecorder, *ipCheckerMock) {
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		bodyBytes, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/companies:batch", bytes.NewReader(bodyBytes))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		return w, checker
+	}
+
+	statuses := func(t *testing.T, w *httptest.ResponseRecorder) []batchItemResult {
+		var response struct {
+			Results []batchItemResult `json:"results"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		return response.Results
+	}
+
+	t.Run("per item results", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("CreateBatch", []companies.CompanyFields{validFields, validFields}, false).
+			Return([]companies.BatchResult{
+				{ID: "1234"},
+				{Err: &companies.DuplicateError{ExistingID: "1234"}},
+			}, nil)
+
+		w, checker := createBatch(comps, gin.H{
+			"items": []gin.H{validItem, invalidItem, validItem},
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statuses(t, w)
+		assert.Equal(t, 3, len(results))
+		assert.Equal(t, batchItemResult{Status: http.StatusCreated, ID: "1234"}, results[0])
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+		assert.Equal(t, 2, len(results[1].Errors))
+		assert.Equal(t, batchItemResult{Status: http.StatusConflict, ExistingID: "1234"}, results[2])
+		comps.AssertExpectations(t)
+		checker.AssertNumberOfCalls(t, "GetIPCountry", 1)
+	})
+
+	t.Run("unrecorded item", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("CreateBatch", []companies.CompanyFields{validFields}, false).
+			Return([]companies.BatchResult{
+				{ID: "1234", Err: errors.New("history unavailable")},
+			}, nil)
+
+		w, _ := createBatch(comps, gin.H{
+			"items": []gin.H{validItem},
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statuses(t, w)
+		assert.Equal(t, []batchItemResult{{Status: http.StatusCreated, ID: "1234"}}, results)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("atomic with invalid item", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+
+		w, _ := createBatch(comps, gin.H{
+			"items":  []gin.H{validItem, invalidItem},
+			"atomic": true,
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statuses(t, w)
+		assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("atomic aborted", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("CreateBatch", []companies.CompanyFields{validFields, validFields}, true).
+			Return([]companies.BatchResult{
+				{Err: companies.ErrBatchAborted},
+				{Err: &companies.DuplicateError{ExistingID: "1234"}},
+			}, nil)
+
+		w, _ := createBatch(comps, gin.H{
+			"items":  []gin.H{validItem, validItem},
+			"atomic": true,
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statuses(t, w)
+		assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+		assert.Equal(t, http.StatusConflict, results[1].Status)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("atomic unsupported", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("CreateBatch", []companies.CompanyFields{validFields}, true).
+			Return(nil, companies.ErrTransactionsUnsupported)
+
+		w, _ := createBatch(comps, gin.H{
+			"items":  []gin.H{validItem},
+			"atomic": true,
+		})
+
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("batch size", func(t *testing.T) {
+		for _, items := range [][]gin.H{
+			{},
+			{validItem, validItem, validItem, validItem},
+		} {
+			comps := &companiesLayerMock{}
+
+			w, _ := createBatch(comps, gin.H{"items": items})
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			comps.AssertExpectations(t)
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/companies:merge", bytes.NewReader([]byte(`{}`)))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestBulkCompanies(t *testing.T) {
+	website := "acme.com"
+	query := companies.SearchFilters{Website: companies.StringFilter{Contains: &website}}
+	country := "Greece"
+	update := companies.UpdateFields{Country: &country}
+
+	// Confirm tokens are signed with the configured
+	// secret, any instance accepts them.
+	createBulkAPI := func(comps *companiesLayerMock) *API {
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+		return createTestAPI(comps, checker)
+	}
+	bulk := func(api *API, path string, body string) (int, gin.H) {
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewReader([]byte(body)))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		var response gin.H
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	t.Run("update after dry run", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil)
+		comps.On("BulkUpdate", query, update).Return(uint64(2), nil)
+
+		api := createBulkAPI(comps)
+
+		code, response := bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", `{"update":{"country":"Greece"}}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, true, response["dry_run"])
+		assert.Equal(t, 2.0, response["matched"])
+		comps.AssertNotCalled(t, "BulkUpdate", query, update)
+
+		body, _ := json.Marshal(gin.H{
+			"update":  gin.H{"country": "Greece"},
+			"confirm": response["confirm_token"],
+		})
+		code, response = bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", string(body))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2.0, response["updated"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("confirmed by another instance", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil)
+		comps.On("BulkDelete", query).Return(uint64(2), nil)
+
+		_, response := bulk(createBulkAPI(comps), "/v1/companies:bulkDelete?website_contains=acme.com", "")
+		code, response := bulk(
+			createBulkAPI(comps),
+			"/v1/companies:bulkDelete?website_contains=acme.com",
+			`{"confirm":"`+response["confirm_token"].(string)+`"}`,
+		)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2.0, response["deleted"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("token of another operation", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil)
+
+		api := createBulkAPI(comps)
+
+		_, response := bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", `{"update":{"country":"Greece"}}`)
+		body, _ := json.Marshal(gin.H{
+			"update":  gin.H{"country": "Cyprus"},
+			"confirm": response["confirm_token"],
+		})
+		code, _ := bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", string(body))
+		assert.Equal(t, http.StatusConflict, code)
+
+		code, _ = bulk(api, "/v1/companies:bulkDelete?website_contains=acme.com", `{"confirm":"abc.def"}`)
+		assert.Equal(t, http.StatusConflict, code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("matched companies changed", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil).Once()
+		comps.On("Count", query).Return(uint64(3), nil).Once()
+
+		api := createBulkAPI(comps)
+
+		_, response := bulk(api, "/v1/companies:bulkDelete?website_contains=acme.com", "")
+		code, response := bulk(
+			api,
+			"/v1/companies:bulkDelete?website_contains=acme.com",
+			`{"confirm":"`+response["confirm_token"].(string)+`"}`,
+		)
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, 3.0, response["matched"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("delete after dry run", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil)
+		comps.On("BulkDelete", query).Return(uint64(2), nil)
+
+		api := createBulkAPI(comps)
+
+		_, response := bulk(api, "/v1/companies:bulkDelete?website_contains=acme.com", "")
+		code, response := bulk(
+			api,
+			"/v1/companies:bulkDelete?website_contains=acme.com",
+			`{"confirm":"`+response["confirm_token"].(string)+`"}`,
+		)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2.0, response["deleted"])
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("duplicate codes", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Count", query).Return(uint64(2), nil)
+		comps.On("BulkUpdate", query, update).Return(uint64(0), companies.ErrDuplicate)
+
+		api := createBulkAPI(comps)
+
+		_, response := bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", `{"update":{"country":"Greece"}}`)
+		body, _ := json.Marshal(gin.H{
+			"update":  gin.H{"country": "Greece"},
+			"confirm": response["confirm_token"],
+		})
+		code, _ := bulk(api, "/v1/companies:bulkUpdate?website_contains=acme.com", string(body))
+		assert.Equal(t, http.StatusConflict, code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, cs := range []struct {
+			path string
+			body string
+		}{
+			{"/v1/companies:bulkUpdate", `{"update":{"country":"Greece"}}`},
+			{"/v1/companies:bulkUpdate?website_contains=acme.com", `{"update":{}}`},
+			{"/v1/companies:bulkUpdate?website_contains=acme.com", `{"update":{"code":"lower"}}`},
+			{"/v1/companies:bulkDelete", ""},
+			{"/v1/companies:bulkDelete?created_after=yesterday", ""},
+		} {
+			comps := &companiesLayerMock{}
+			api := createBulkAPI(comps)
+
+			code, _ := bulk(api, cs.path, cs.body)
+			assert.Equal(t, http.StatusBadRequest, code, cs.path+" "+cs.body)
+			comps.AssertExpectations(t)
+		}
+	})
+}
+
+func TestExportCompanies(t *testing.T) {
+	country := "Cyprus"
+	query := companies.SearchFilters{Country: companies.StringFilter{Eq: &country}}
+	second := validCompany
+	second.ID = "5678"
+	second.Name = "Second, Ltd"
+
+	export := func(comps *companiesLayerMock, params string) *httptest.ResponseRecorder {
+		checker := &ipCheckerMock{}
+
+		api := createTestAPI(comps, checker)
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/companies/export?"+params, nil)
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Export", query).Return([]*models.Company{&validCompany, &second}, nil)
+
+		w := export(comps, "country=Cyprus")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Equal(t, 3, len(lines))
+		assert.Equal(t, "id,name,code,country,website,phone,version", lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], validCompany.ID+","+validCompany.Name+","))
+		assert.True(t, strings.HasPrefix(lines[2], `5678,"Second, Ltd",`))
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Export", query).Return([]*models.Company{&validCompany, &second}, nil)
+
+		w := export(comps, "country=Cyprus&format=ndjson")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		decoder := json.NewDecoder(w.Body)
+		var ids []string
+		for decoder.More() {
+			var company models.Company
+			assert.NoError(t, decoder.Decode(&company))
+			ids = append(ids, company.ID)
+		}
+		assert.Equal(t, []string{validCompany.ID, "5678"}, ids)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("empty csv", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Export", query).Return([]*models.Company{}, nil)
+
+		w := export(comps, "country=Cyprus")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "id,name,code,country,website,phone,version\n", w.Body.String())
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Export", query).Return([]*models.Company{}, errors.New("unexpected error"))
+
+		w := export(comps, "country=Cyprus")
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+
+		w := export(comps, "format=xlsx")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestPatchCompany(t *testing.T) {
+	patchAPI := func(comps *companiesLayerMock, contentType, body string) *httptest.ResponseRecorder {
+		api := createTestAPI(comps, allowedIPChecker())
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/v1/companies/1234", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("merge patch", func(t *testing.T) {
+		name, website := "Patched Name", "http://patched.valid/"
+		patched := validCompany
+		patched.Name, patched.Website, patched.Version = name, website, 4
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil).Once()
+		comps.On("Update", "1234", uint64(3), companies.UpdateFields{Name: &name, Website: &website}).Return(nil)
+		comps.On("Get", "1234").Return(&patched, nil).Once()
+
+		w := patchAPI(comps, "application/merge-patch+json", `{"name":"Patched Name","website":"http://patched.valid/"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+		var company models.Company
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &company))
+		assert.Equal(t, patched, company)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("merge patch clearing a field", func(t *testing.T) {
+		website := ""
+		patched := validCompany
+		patched.Website, patched.Version = website, 4
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil).Once()
+		comps.On("Update", "1234", uint64(3), companies.UpdateFields{Website: &website}).Return(nil)
+		comps.On("Get", "1234").Return(&patched, nil).Once()
+
+		w := patchAPI(comps, "application/merge-patch+json", `{"website":null}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("json patch", func(t *testing.T) {
+		code := "PN"
+		patched := validCompany
+		patched.Code, patched.Version = code, 4
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil).Once()
+		comps.On("Update", "1234", uint64(3), companies.UpdateFields{Code: &code}).Return(nil)
+		comps.On("Get", "1234").Return(&patched, nil).Once()
+
+		w := patchAPI(comps, "application/json-patch+json", `[
+			{"op":"test","path":"/code","value":"VN"},
+			{"op":"replace","path":"/code","value":"PN"}
+		]`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("failed test operation", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil)
+
+		w := patchAPI(comps, "application/json-patch+json", `[
+			{"op":"test","path":"/code","value":"XX"},
+			{"op":"replace","path":"/code","value":"PN"}
+		]`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("invalid patched result", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil)
+
+		w := patchAPI(comps, "application/json-patch+json", `[{"op":"remove","path":"/name"}]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response struct {
+			Errors []string `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []string{"company name must be at least 4 characters"}, response.Errors)
+
+		w = patchAPI(comps, "application/json-patch+json", `[{"op":"add","path":"/id","value":"1"}]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = patchAPI(comps, "application/json-patch+json", `[{"op":"remove","path":"/missing"}]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = patchAPI(comps, "application/merge-patch+json", `{"code":"lower"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil)
+
+		w := patchAPI(comps, "application/merge-patch+json", `{"name":"Valid Name"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+		comps.On("Get", "1234").Return(&validCompany, nil)
+
+		api := createTestAPI(comps, allowedIPChecker())
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/v1/companies/1234", strings.NewReader(`{"name":"Patched Name"}`))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", `"2"`)
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+
+		w := patchAPI(comps, "application/json", `{"name":"Patched Name"}`)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestReplaceCompany(t *testing.T) {
+	t.Run("clears missing optional fields", func(t *testing.T) {
+		company := validCompany
+		update := companies.UpdateFields{
+			Name:    &company.Name,
+			Code:    &company.Code,
+			Country: &company.Country,
+			Website: new(string),
+			Phone:   new(string),
+		}
+		comps := &companiesLayerMock{}
+		comps.On("Update", "1234", companies.AnyVersion, update).Return(nil)
+
+		api := createTestAPI(comps, allowedIPChecker())
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/companies/1234", strings.NewReader(
+			`{"name":"Valid Name","code":"VN","country":"Cyprus","website":null}`,
+		))
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comps.AssertExpectations(t)
+	})
+
+	t.Run("requires full representation", func(t *testing.T) {
+		comps := &companiesLayerMock{}
+
+		api := createTestAPI(comps, allowedIPChecker())
+		engine := api.createEngine()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/companies/1234", strings.NewReader(`{"name":"Valid Name","code":null}`))
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response struct {
+			Errors []string `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []string{"code is required", "country is required"}, response.Errors)
+		comps.AssertExpectations(t)
+	})
+}
+
+func TestCacheStatsUncached(t *testing.T) {
+	api := createTestAPI(&companiesLayerMock{}, &ipCheckerMock{})
+	engine := api.createEngine()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/cache/stats", nil)
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCompaniesUnavailable(t *testing.T) {
+	comps := &companiesLayerMock{}
+	comps.On("Get", "1234").Return(nil, &companies.UnavailableError{RetryAfter: 2500 * time.Millisecond})
+
+	api := createTestAPI(comps, &ipCheckerMock{})
+	engine := api.createEngine()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/companies/1234", nil)
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Retry-After"))
+	comps.AssertExpectations(t)
+}
+
+func TestWebhooks(t *testing.T) {
+	subscription := &models.Subscription{
+		ID:         "hook",
+		URL:        "https://crm.example/hooks",
+		EventTypes: []string{"company.created"},
+		CreatedAt:  time.Now(),
+	}
+
+	t.Run("create", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		created := *subscription
+		created.Secret = "generated"
+		hooks.On("Create", webhooks.SubscriptionFields{
+			URL:        "https://crm.example/hooks",
+			EventTypes: []string{"company.created"},
+		}).Return(&created, nil)
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(
+			"POST",
+			"/v1/webhooks",
+			strings.NewReader(`{"url":"https://crm.example/hooks","event_types":["company.created"]}`),
+		)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response models.Subscription
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "hook", response.ID)
+		assert.Equal(t, "generated", response.Secret)
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(
+			"POST",
+			"/v1/webhooks",
+			strings.NewReader(`{"url":"ftp://crm.example","event_types":["company.renamed"],"secret":"short"}`),
+		)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response struct {
+			Errors []string `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 3, len(response.Errors))
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("create with internal target", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		hooks.On("Create", webhooks.SubscriptionFields{URL: "https://intranet.example/hooks"}).
+			Return(nil, webhooks.ErrForbiddenTarget)
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+
+		for _, body := range []string{
+			`{"url":"http://127.0.0.1:8080/hooks"}`,
+			`{"url":"http://[::1]/hooks"}`,
+			`{"url":"http://169.254.169.254/latest"}`,
+			`{"url":"http://localhost/hooks"}`,
+			`{"url":"https://intranet.example/hooks"}`,
+		} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/v1/webhooks", strings.NewReader(body))
+			req.RemoteAddr = "44.44.44.44:54321"
+			engine.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("create from forbidden address", func(t *testing.T) {
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return("Unwhitelisted", nil)
+
+		engine := createTestWebhooksAPI(&webhooksMock{}, checker).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/webhooks", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		hooks.On("Get", "hook").Return(subscription, nil)
+		hooks.On("Get", "missing").Return(nil, webhooks.ErrNotFound)
+
+		engine := createTestWebhooksAPI(hooks, &ipCheckerMock{}).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/webhooks/hook", nil)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v1/webhooks/missing", nil)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		fields := webhooks.SubscriptionFields{URL: "http://crm.example/v2", Secret: "a new long secret"}
+		hooks.On("Update", "hook", fields).Return(nil)
+		hooks.On("Get", "hook").Return(subscription, nil)
+		hooks.On("Update", "missing", fields).Return(webhooks.ErrNotFound)
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+		body := `{"url":"http://crm.example/v2","secret":"a new long secret"}`
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/v1/webhooks/hook", strings.NewReader(body))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/v1/webhooks/missing", strings.NewReader(body))
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		hooks.On("Delete", "hook").Return(nil)
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/webhooks/hook", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		deliveries := []*models.Delivery{
+			{ID: "delivery", SubscriptionID: "hook", EventType: "company.created", Status: "dead", Attempts: 8},
+		}
+		hooks.On("Deliveries", "hook", uint64(0), uint64(20)).Return(deliveries, nil)
+		hooks.On("Deliveries", "missing", uint64(0), uint64(20)).Return(nil, webhooks.ErrNotFound)
+
+		engine := createTestWebhooksAPI(hooks, &ipCheckerMock{}).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/webhooks/hook/deliveries", nil)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Results []models.Delivery `json:"results"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 1, len(response.Results))
+		assert.Equal(t, "dead", response.Results[0].Status)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v1/webhooks/missing/deliveries", nil)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		hooks.AssertExpectations(t)
+	})
+
+	t.Run("redeliver", func(t *testing.T) {
+		hooks := &webhooksMock{}
+		hooks.On("Redeliver", "hook", "dead").Return(nil)
+		hooks.On("Redeliver", "hook", "pending").Return(webhooks.ErrNotDead)
+		checker := &ipCheckerMock{}
+		checker.On("GetIPCountry", "44.44.44.44").Return(allowedTestCountry, nil)
+
+		engine := createTestWebhooksAPI(hooks, checker).createEngine()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/webhooks/hook/deliveries/dead/redeliver", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/v1/webhooks/hook/deliveries/pending/redeliver", nil)
+		req.RemoteAddr = "44.44.44.44:54321"
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		hooks.AssertExpectations(t)
+	})
 }
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/cached"
+)
+
+// cacheStatsProvider is implemented by caching companies layers.
+type cacheStatsProvider interface {
+	Stats() cached.Stats
+}
+
+func (a *API) handleCacheStats(c *gin.Context, log *zap.Logger) {
+	provider, ok := a.companies.(cacheStatsProvider)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		log.Warn("companies are not cached")
+		return
+	}
+	c.JSON(http.StatusOK, provider.Stats())
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// confirmTTL is how long a bulk operation dry run stays confirmable.
+const confirmTTL = 5 * time.Minute
+
+func newConfirmKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// confirmToken signs the operation together with the number of companies
+// its dry run matched, so the token is refused once the request or the
+// matched companies change. The key comes from the confirm secret so
+// tokens hold across instances and restarts.
+func (a *API) confirmToken(operation string, matched uint64, expires time.Time) string {
+	mac := hmac.New(sha256.New, a.confirmKey)
+	fmt.Fprintf(mac, "%s\n%d\n%d", operation, matched, expires.Unix())
+	return strconv.FormatInt(expires.Unix(), 36) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *API) validConfirmToken(token, operation string, matched uint64) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expiresUnix, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil {
+		return false
+	}
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(a.confirmToken(operation, matched, expires)))
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+)
+
+// serverError responds to unexpected companies errors, 503 with
+// Retry-After while the companies are unavailable and 500 otherwise.
+func serverError(c *gin.Context, err error) {
+	var unavailable *companies.UnavailableError
+	if !errors.As(err, &unavailable) {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	seconds := math.Ceil(unavailable.RetryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(int(seconds)))
+	c.Status(http.StatusServiceUnavailable)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+func companyETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// ifMatchVersion returns the company version a request is conditional
+// on. Missing If-Match or "*" mean any version will do, while an
+// unparsable tag can never match and fails the precondition.
+func ifMatchVersion(c *gin.Context) (uint64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if len(header) < 1 || header == "*" {
+		return companies.AnyVersion, nil
+	}
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, errPreconditionFailed
+	}
+	version, err := strconv.ParseUint(header[1:len(header)-1], 10, 64)
+	if err != nil || version == companies.AnyVersion {
+		return 0, errPreconditionFailed
+	}
+	return version, nil
+}
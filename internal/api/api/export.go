@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/models"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+)
+
+// exportFlushEvery is how many companies are sent to
+// the client at once while exporting.
+const exportFlushEvery = 100
+
+type exportWriter interface {
+	Write(company *models.Company) error
+	// Flush completes the output so far, it is called
+	// at least once even when nothing was written.
+	Flush() error
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, string, bool) {
+	switch format {
+	case exportFormatCSV:
+		return &csvExportWriter{csv: csv.NewWriter(w)}, "text/csv; charset=utf-8", true
+	case exportFormatNDJSON:
+		return &ndjsonExportWriter{json.NewEncoder(w)}, "application/x-ndjson", true
+	}
+	return nil, "", false
+}
+
+var csvExportHeader = []string{"id", "name", "code", "country", "website", "phone", "version"}
+
+type csvExportWriter struct {
+	csv           *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvExportWriter) Write(company *models.Company) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.csv.Write([]string{
+		company.ID,
+		company.Name,
+		company.Code,
+		company.Country,
+		company.Website,
+		company.Phone,
+		strconv.FormatUint(company.Version, 10),
+	})
+}
+
+func (w *csvExportWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func (w *csvExportWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	return w.csv.Write(csvExportHeader)
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) Write(company *models.Company) error {
+	return w.encoder.Encode(company)
+}
+
+func (w *ndjsonExportWriter) Flush() error {
+	return nil
+}
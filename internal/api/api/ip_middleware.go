@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
 	"github.com/RavisMsk/xmcompanies/internal/api/ipchecker"
 	"github.com/RavisMsk/xmcompanies/internal/pkg/structs"
 )
@@ -14,14 +15,8 @@ func IPCheckingMiddleware(checker ipchecker.Checker, allowedCountries structs.St
 	return func(c *gin.Context) {
 		log := getLogger(c)
 		clientIP := c.ClientIP()
-		clientCountry, err := checker.GetIPCountry(clientIP)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			log.Error(
-				"error fetching client ip country",
-				zap.String("ip", clientIP),
-				zap.Error(err),
-			)
+		clientCountry, ok := resolveClientCountry(c, checker)
+		if !ok {
 			return
 		}
 		if !allowedCountries.Has(clientCountry) {
@@ -38,6 +33,39 @@ func IPCheckingMiddleware(checker ipchecker.Checker, allowedCountries structs.St
 			zap.String("ip", clientIP),
 			zap.String("country", clientCountry),
 		)
+
 		c.Next()
 	}
 }
+
+// ClientCountryMiddleware records the client country in the actor
+// like IPCheckingMiddleware, without limiting the allowed countries.
+func ClientCountryMiddleware(checker ipchecker.Checker) func(*gin.Context) {
+	return func(c *gin.Context) {
+		if _, ok := resolveClientCountry(c, checker); ok {
+			c.Next()
+		}
+	}
+}
+
+// resolveClientCountry looks up the client country and sets it on the
+// actor, the request is aborted when it can't be found.
+func resolveClientCountry(c *gin.Context, checker ipchecker.Checker) (string, bool) {
+	clientIP := c.ClientIP()
+	clientCountry, err := checker.GetIPCountry(clientIP)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		getLogger(c).Error(
+			"error fetching client ip country",
+			zap.String("ip", clientIP),
+			zap.Error(err),
+		)
+		return "", false
+	}
+
+	ctx := getCtx(c)
+	actor := companies.ActorFromContext(ctx)
+	actor.ClientCountry = clientCountry
+	setCtx(c, companies.WithActor(ctx, actor))
+	return clientCountry, true
+}
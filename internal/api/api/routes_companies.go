@@ -1,73 +1,311 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
-	"github.com/RavisMsk/xmcompanies/internal/pkg/countries"
+	"github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/api/validate"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/jsonpatch"
 )
 
 func (a *API) handleListCompanies(c *gin.Context, log *zap.Logger) {
-	nameFilter := c.Query("name")
-	codeFilter := c.Query("code")
-	countryFilter := c.Query("country")
-	websiteFilter := c.Query("website")
-	phoneFilter := c.Query("phone")
+	cursor := c.Query("cursor")
+	query, ok := parseSearchFilters(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
 
-	var (
-		page  uint64
-		limit uint64 = 20
-		err   error
-	)
-	pageString := c.Query("cursor")
-	if len(pageString) > 0 {
-		page, err = strconv.ParseUint(pageString, 10, 64)
+	limit, ok := parseLimit(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	query.Sort, ok = parseSort(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	includeTotal := false
+	if includeTotalString := c.Query("include_total"); len(includeTotalString) > 0 {
+		var err error
+		includeTotal, err = strconv.ParseBool(includeTotalString)
 		if err != nil {
 			c.Status(http.StatusBadRequest)
 			return
 		}
 	}
-	limitString := c.Query("limit")
-	if len(limitString) > 0 {
-		limit, err = strconv.ParseUint(limitString, 10, 64)
+
+	fuzzyName, ok := parseFuzzyName(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// Fuzzy results have their own ranking and count, it
+	// doesn't combine with text ranking, sorting or totals.
+	if fuzzyName != nil && (query.Text != nil || len(query.Sort) > 0 || includeTotal) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		results interface{}
+		next    string
+		err     error
+	)
+	if fuzzyName != nil {
+		results, next, err = a.companies.FuzzySearch(getCtx(c), query, *fuzzyName, cursor, limit)
+	} else if query.Text != nil && len(query.Sort) < 1 {
+		// Text queries are ranked by relevance unless
+		// an explicit order is requested.
+		results, next, err = a.companies.TextSearch(getCtx(c), query, cursor, limit)
+	} else {
+		results, next, err = a.companies.Search(getCtx(c), query, cursor, limit)
+	}
+	if err == companies.ErrInvalidCursor {
+		c.Status(http.StatusBadRequest)
+		log.Error("invalid companies cursor", zap.String("cursor", cursor))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("companies search error", zap.Error(err))
+		return
+	}
+
+	meta := gin.H{
+		"limit":    limit,
+		"has_more": len(next) > 0,
+	}
+	if includeTotal {
+		total, err := a.companies.Count(getCtx(c), query)
 		if err != nil {
-			c.Status(http.StatusBadRequest)
+			serverError(c, err)
+			log.Error("companies count error", zap.Error(err))
 			return
 		}
-		if limit < 2 {
-			c.Status(http.StatusBadRequest)
-			return
+		meta["total"] = total
+	}
+
+	response := gin.H{
+		"results": results,
+		"meta":    meta,
+	}
+	if len(next) > 0 {
+		response["next_cursor"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (a *API) handleCountCompanies(c *gin.Context, log *zap.Logger) {
+	query, ok := parseSearchFilters(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	total, err := a.companies.Count(getCtx(c), query)
+	if err != nil {
+		serverError(c, err)
+		log.Error("companies count error", zap.Error(err))
+		return
+	}
+	c.Header("X-Total-Count", strconv.FormatUint(total, 10))
+	c.Status(http.StatusOK)
+}
+
+func (a *API) handleExportCompanies(c *gin.Context, log *zap.Logger) {
+	query, ok := parseSearchFilters(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	query.Sort, ok = parseSort(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	format := c.DefaultQuery("format", exportFormatCSV)
+	writer, contentType, ok := newExportWriter(format, c.Writer)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		exported uint64
+		started  bool
+	)
+	start := func() {
+		started = true
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", `attachment; filename="companies.`+format+`"`)
+		c.Status(http.StatusOK)
+	}
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	// Exports run for as long as the client keeps reading, they
+	// end with the connection instead of the request timeout.
+	ctx := c.Request.Context()
+	err := a.companies.Export(ctx, query, func(company *models.Company) error {
+		if !started {
+			start()
+		}
+		if err := writer.Write(company); err != nil {
+			return err
+		}
+		exported++
+		if exported%exportFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		if !started {
+			start()
+		}
+		err = flush()
+	}
+
+	switch {
+	case err == nil:
+		log.Info("companies exported", zap.Uint64("count", exported), zap.String("format", format))
+	case ctx.Err() != nil:
+		log.Warn("companies export cancelled by client", zap.Uint64("count", exported))
+	case !started:
+		serverError(c, err)
+		log.Error("companies export error", zap.Error(err))
+	default:
+		// Headers are sent already, closing the connection
+		// tells the client the export is incomplete.
+		log.Error("companies export failed midway", zap.Uint64("count", exported), zap.Error(err))
+		if conn, _, err := c.Writer.Hijack(); err == nil {
+			conn.Close()
 		}
 	}
+}
 
-	query := companies.SearchFilters{}
-	if len(nameFilter) > 0 {
-		query.Name = &nameFilter
+func parseSearchFilters(c *gin.Context) (companies.SearchFilters, bool) {
+	query := companies.SearchFilters{
+		Name:    parseStringFilter(c, "name"),
+		Code:    parseStringFilter(c, "code"),
+		Website: parseStringFilter(c, "website"),
+		Phone:   parseStringFilter(c, "phone"),
 	}
-	if len(codeFilter) > 0 {
-		query.Code = &codeFilter
+	if text := c.Query("q"); len(strings.TrimSpace(text)) > 0 {
+		query.Text = &text
+	}
+	// Country takes a comma separated list of allowed values.
+	if countryFilter := c.Query("country"); len(countryFilter) > 0 {
+		countries := strings.Split(countryFilter, ",")
+		if len(countries) == 1 {
+			query.Country.Eq = &countryFilter
+		} else {
+			query.Country.In = countries
+		}
+	}
+
+	var ok bool
+	if query.CreatedAt, ok = parseTimeRange(c, "created"); !ok {
+		return query, false
 	}
-	if len(countryFilter) > 0 {
-		query.Country = &countryFilter
+	if query.UpdatedAt, ok = parseTimeRange(c, "updated"); !ok {
+		return query, false
 	}
-	if len(websiteFilter) > 0 {
-		query.Website = &websiteFilter
+	return query, true
+}
+
+// parseStringFilter reads "<field>" exact match, plus
+// "<field>_prefix" and "<field>_contains" operators.
+func parseStringFilter(c *gin.Context, field string) companies.StringFilter {
+	var filter companies.StringFilter
+	if value := c.Query(field); len(value) > 0 {
+		filter.Eq = &value
+	}
+	if value := c.Query(field + "_prefix"); len(value) > 0 {
+		filter.Prefix = &value
+	}
+	if value := c.Query(field + "_contains"); len(value) > 0 {
+		filter.Contains = &value
+	}
+	return filter
+}
+
+const defaultSimilarity = 0.3
+
+func parseFuzzyName(c *gin.Context) (*companies.FuzzyName, bool) {
+	name, ok := c.GetQuery("name_fuzzy")
+	if !ok {
+		if _, ok = c.GetQuery("similarity"); ok {
+			return nil, false
+		}
+		return nil, true
+	}
+	if len(strings.TrimSpace(name)) < 1 {
+		return nil, false
+	}
+
+	fuzzyName := companies.FuzzyName{Name: name, Similarity: defaultSimilarity}
+	if similarityString := c.Query("similarity"); len(similarityString) > 0 {
+		similarity, err := strconv.ParseFloat(similarityString, 64)
+		if err != nil || !(similarity > 0 && similarity <= 1) {
+			return nil, false
+		}
+		fuzzyName.Similarity = similarity
+	}
+	return &fuzzyName, true
+}
+
+// parseTimeRange reads RFC3339 "<prefix>_after" and "<prefix>_before".
+func parseTimeRange(c *gin.Context, prefix string) (companies.TimeRange, bool) {
+	var timeRange companies.TimeRange
+	for _, bound := range []struct {
+		param string
+		dest  **time.Time
+	}{
+		{prefix + "_after", &timeRange.After},
+		{prefix + "_before", &timeRange.Before},
+	} {
+		value := c.Query(bound.param)
+		if len(value) < 1 {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return timeRange, false
+		}
+		*bound.dest = &parsed
 	}
-	if len(phoneFilter) > 0 {
-		query.Phone = &phoneFilter
+	return timeRange, true
+}
+
+func (a *API) handleListTrash(c *gin.Context, log *zap.Logger) {
+	offset, limit, ok := parsePagination(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
 	}
-	companies, err := a.companies.Search(getCtx(c), query, page, limit)
+
+	companies, err := a.companies.Trash(getCtx(c), offset, limit)
 	if err != nil {
-		c.Status(http.StatusInternalServerError)
-		log.Error("companies search error", zap.Error(err))
+		serverError(c, err)
+		log.Error("companies trash listing error", zap.Error(err))
 		return
 	}
 
@@ -76,8 +314,79 @@ func (a *API) handleListCompanies(c *gin.Context, log *zap.Logger) {
 	})
 }
 
+// parsePagination reads the offset and limit of listings paged by
+// position, unlike the opaque cursor of the companies listing.
+func parsePagination(c *gin.Context) (offset uint64, limit uint64, ok bool) {
+	var err error
+	offsetString := c.Query("offset")
+	if len(offsetString) > 0 {
+		offset, err = strconv.ParseUint(offsetString, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	limit, ok = parseLimit(c)
+	return offset, limit, ok
+}
+
+var sortableFields = map[string]bool{
+	"name":       true,
+	"code":       true,
+	"country":    true,
+	"website":    true,
+	"phone":      true,
+	"created_at": true,
+}
+
+// parseSort reads "sort=-created_at,name" style order, a leading
+// minus makes the field descending.
+func parseSort(c *gin.Context) ([]companies.SortField, bool) {
+	sortString := c.Query("sort")
+	if len(sortString) < 1 {
+		return nil, true
+	}
+
+	var (
+		sort = []companies.SortField{}
+		seen = map[string]bool{}
+	)
+	for _, part := range strings.Split(sortString, ",") {
+		field := companies.SortField{Field: strings.TrimSpace(part)}
+		if strings.HasPrefix(field.Field, "-") {
+			field.Field, field.Desc = field.Field[1:], true
+		}
+		if !sortableFields[field.Field] || seen[field.Field] {
+			return nil, false
+		}
+		seen[field.Field] = true
+		sort = append(sort, field)
+	}
+	return sort, true
+}
+
+func parseLimit(c *gin.Context) (limit uint64, ok bool) {
+	limit = 20
+	limitString := c.Query("limit")
+	if len(limitString) > 0 {
+		var err error
+		limit, err = strconv.ParseUint(limitString, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		if limit < 2 {
+			return 0, false
+		}
+	}
+	return limit, true
+}
+
 func (a *API) handleGetCompany(c *gin.Context, log *zap.Logger) {
 	companyID := c.Param("companyID")
+	if asOfString := c.Query("as_of"); len(asOfString) > 0 {
+		a.handleGetCompanyAsOf(c, log, companyID, asOfString)
+		return
+	}
+
 	log.Info("fetching company", zap.String("id", companyID))
 	company, err := a.companies.Get(getCtx(c), companyID)
 	if err == companies.ErrNotFound {
@@ -85,10 +394,38 @@ func (a *API) handleGetCompany(c *gin.Context, log *zap.Logger) {
 		log.Error("company not found", zap.String("id", companyID))
 		return
 	} else if err != nil {
-		c.Status(http.StatusInternalServerError)
+		serverError(c, err)
 		log.Error("unexpected error fetching company", zap.String("id", companyID), zap.Error(err))
 		return
 	}
+	c.Header("ETag", companyETag(company.Version))
+	c.JSON(http.StatusOK, company)
+}
+
+func (a *API) handleGetCompanyAsOf(
+	c *gin.Context,
+	log *zap.Logger,
+	companyID string,
+	asOfString string,
+) {
+	asOf, err := time.Parse(time.RFC3339, asOfString)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("invalid as_of time", zap.String("as_of", asOfString), zap.Error(err))
+		return
+	}
+
+	log.Info("fetching company as of", zap.String("id", companyID), zap.Time("as_of", asOf))
+	company, err := a.companies.GetAsOf(getCtx(c), companyID, asOf)
+	if err == companies.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("company not found as of", zap.String("id", companyID), zap.Time("as_of", asOf))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("unexpected error fetching company as of", zap.String("id", companyID), zap.Error(err))
+		return
+	}
 	c.JSON(http.StatusOK, company)
 }
 
@@ -117,60 +454,307 @@ func (a *API) handleCreateCompany(c *gin.Context, log *zap.Logger) {
 		zap.String("phone", request.Phone),
 	)
 
-	var (
-		errs   []error
-		fields companies.CompanyFields
-	)
+	fields, errs := validatedCompanyFields(request)
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages(errs),
+		})
+		return
+	}
 
-	if processedName, err := validatedName(request.Name); err != nil {
-		errs = append(errs, err)
-	} else {
-		fields.Name = processedName
+	companyID, err := a.companies.Create(getCtx(c), fields)
+	var duplicate *companies.DuplicateError
+	if errors.As(err, &duplicate) {
+		c.JSON(http.StatusConflict, gin.H{
+			"existing_id": duplicate.ExistingID,
+		})
+		log.Error("company code already exists in country", zap.String("existingID", duplicate.ExistingID))
+		return
+	} else if err != nil && companyID == "" {
+		serverError(c, err)
+		log.Error("error creating company", zap.Error(err))
+		return
+	} else if err != nil {
+		log.Error("company created, error recording its history", zap.String("id", companyID), zap.Error(err))
 	}
 
-	if processedCode, err := validatedCode(request.Code); err != nil {
-		errs = append(errs, err)
-	} else {
-		fields.Code = processedCode
+	c.JSON(http.StatusCreated, gin.H{
+		"id": companyID,
+	})
+}
+
+func validatedCompanyFields(request createCompanyRequest) (companies.CompanyFields, []error) {
+	return validate.CompanyFields(companies.CompanyFields(request))
+}
+
+func (a *API) handleCompaniesMethod(c *gin.Context, log *zap.Logger) {
+	switch c.Param("method") {
+	case ":batch":
+		a.handleCreateCompanies(c, log)
+	case ":bulkUpdate":
+		a.handleBulkUpdateCompanies(c, log)
+	case ":bulkDelete":
+		a.handleBulkDeleteCompanies(c, log)
+	default:
+		c.Status(http.StatusNotFound)
 	}
+}
 
-	if !validCountry(request.Country) {
-		errs = append(errs, errors.New("invalid country"))
-	} else {
-		fields.Country = request.Country
+type createCompaniesRequest struct {
+	Items []createCompanyRequest `json:"items"`
+	// Atomic creates either all items or none.
+	Atomic bool `json:"atomic"`
+}
+
+type batchItemResult struct {
+	Status     int      `json:"status"`
+	ID         string   `json:"id,omitempty"`
+	ExistingID string   `json:"existing_id,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+func (a *API) handleCreateCompanies(c *gin.Context, log *zap.Logger) {
+	var request createCompaniesRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt unmarshal create companies request", zap.Error(err))
+		return
+	}
+	maxItems := a.cfg.GetMaxBatchSize()
+	if len(request.Items) < 1 || len(request.Items) > maxItems {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": []string{fmt.Sprintf("batch must have from 1 to %d items", maxItems)},
+		})
+		return
 	}
 
-	if processedWebsite, err := validatedWebsite(request.Website); err != nil {
-		errs = append(errs, err)
-	} else {
-		fields.Website = processedWebsite
+	log.Info(
+		"create companies request",
+		zap.Int("items", len(request.Items)),
+		zap.Bool("atomic", request.Atomic),
+	)
+
+	var (
+		results = make([]batchItemResult, len(request.Items))
+		items   []companies.CompanyFields
+		indexes []int
+	)
+	for idx, item := range request.Items {
+		fields, errs := validatedCompanyFields(item)
+		if len(errs) > 0 {
+			results[idx] = batchItemResult{
+				Status: http.StatusBadRequest,
+				Errors: errorMessages(errs),
+			}
+			continue
+		}
+		items = append(items, fields)
+		indexes = append(indexes, idx)
 	}
 
-	if normalizedPhone, err := normalizePhone(request.Phone); err != nil {
-		errs = append(errs, err)
-	} else {
-		fields.Phone = normalizedPhone
+	if request.Atomic && len(items) < len(request.Items) {
+		for _, idx := range indexes {
+			results[idx].Status = http.StatusFailedDependency
+		}
+		items = nil
+	}
+	if len(items) > 0 {
+		created, err := a.companies.CreateBatch(getCtx(c), items, request.Atomic)
+		if err == companies.ErrTransactionsUnsupported {
+			c.Status(http.StatusNotImplemented)
+			log.Error("atomic batch on store without transactions")
+			return
+		} else if err != nil {
+			serverError(c, err)
+			log.Error("error creating companies", zap.Error(err))
+			return
+		}
+		for idx, result := range created {
+			results[indexes[idx]] = batchResult(result, log)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+func batchResult(result companies.BatchResult, log *zap.Logger) batchItemResult {
+	var duplicate *companies.DuplicateError
+	switch {
+	case result.Err == nil:
+		return batchItemResult{Status: http.StatusCreated, ID: result.ID}
+	case result.ID != "":
+		log.Error("batch company created, error recording its history", zap.String("id", result.ID), zap.Error(result.Err))
+		return batchItemResult{Status: http.StatusCreated, ID: result.ID}
+	case result.Err == companies.ErrBatchAborted:
+		return batchItemResult{Status: http.StatusFailedDependency}
+	case errors.As(result.Err, &duplicate):
+		return batchItemResult{Status: http.StatusConflict, ExistingID: duplicate.ExistingID}
+	}
+	log.Error("error creating batch company", zap.Error(result.Err))
+	return batchItemResult{Status: http.StatusInternalServerError}
+}
+
+type bulkUpdateRequest struct {
+	Update companyUpdateRequest `json:"update"`
+	// Confirm is the token of the dry run, without
+	// it the update is only counted.
+	Confirm string `json:"confirm"`
+}
+
+type bulkDeleteRequest struct {
+	Confirm string `json:"confirm"`
+}
+
+func (a *API) handleBulkUpdateCompanies(c *gin.Context, log *zap.Logger) {
+	var request bulkUpdateRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt unmarshal bulk update request", zap.Error(err))
+		return
+	}
+	query, ok := parseBulkFilters(c)
+	if !ok {
+		return
 	}
 
+	update, errs := validatedUpdateFields(request.Update)
 	if len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"errors": errs,
+			"errors": errorMessages(errs),
+		})
+		return
+	}
+	if update == (companies.UpdateFields{}) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": []string{"update has no fields"},
 		})
 		return
 	}
 
-	companyID, err := a.companies.Create(getCtx(c), fields)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
-		log.Error("error creating company", zap.Error(err))
+	updateBytes, _ := json.Marshal(update)
+	operation := "update\n" + c.Request.URL.Query().Encode() + "\n" + string(updateBytes)
+	matched, ok := a.confirmBulk(c, log, query, operation, request.Confirm)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id": companyID,
+	log.Info("bulk update companies", zap.Uint64("matched", matched), zap.ByteString("update", updateBytes))
+	updated, err := a.companies.BulkUpdate(getCtx(c), query, update)
+	if err == companies.ErrDuplicate {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": []string{"update would give companies the same code in a country"},
+		})
+		log.Error("bulk update duplicates company codes")
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error bulk updating companies", zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"matched": matched,
+		"updated": updated,
 	})
 }
 
+func (a *API) handleBulkDeleteCompanies(c *gin.Context, log *zap.Logger) {
+	var request bulkDeleteRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&request); err != nil {
+			c.Status(http.StatusBadRequest)
+			log.Error("couldnt unmarshal bulk delete request", zap.Error(err))
+			return
+		}
+	}
+	query, ok := parseBulkFilters(c)
+	if !ok {
+		return
+	}
+
+	operation := "delete\n" + c.Request.URL.Query().Encode()
+	matched, ok := a.confirmBulk(c, log, query, operation, request.Confirm)
+	if !ok {
+		return
+	}
+
+	log.Info("bulk delete companies", zap.Uint64("matched", matched))
+	deleted, err := a.companies.BulkDelete(getCtx(c), query)
+	if err != nil {
+		serverError(c, err)
+		log.Error("error bulk deleting companies", zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"matched": matched,
+		"deleted": deleted,
+	})
+}
+
+// parseBulkFilters reads the list filters of a bulk operation, at
+// least one is required so that a missing one can't hit everything.
+func parseBulkFilters(c *gin.Context) (companies.SearchFilters, bool) {
+	query, ok := parseSearchFilters(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return query, false
+	}
+	if query.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": []string{"bulk operations require a filter"},
+		})
+		return query, false
+	}
+	return query, true
+}
+
+// confirmBulk counts the companies a bulk operation matches. Without a
+// token it responds with a dry run report and the token to confirm the
+// operation with, a token only passes for the same operation and count.
+func (a *API) confirmBulk(
+	c *gin.Context,
+	log *zap.Logger,
+	query companies.SearchFilters,
+	operation string,
+	token string,
+) (uint64, bool) {
+	matched, err := a.companies.Count(getCtx(c), query)
+	if err != nil {
+		serverError(c, err)
+		log.Error("companies count error", zap.Error(err))
+		return 0, false
+	}
+
+	if len(token) < 1 {
+		expires := time.Now().Add(confirmTTL)
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":       true,
+			"matched":       matched,
+			"confirm_token": a.confirmToken(operation, matched, expires),
+			"expires_at":    expires.UTC().Format(time.RFC3339),
+		})
+		return 0, false
+	}
+	if !a.validConfirmToken(token, operation, matched) {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors":  []string{"confirm token is expired or the matched companies changed, dry run again"},
+			"matched": matched,
+		})
+		log.Error("bulk operation confirm token refused", zap.Uint64("matched", matched))
+		return 0, false
+	}
+	return matched, true
+}
+
+func errorMessages(errs []error) []string {
+	messages := make([]string, len(errs))
+	for idx, err := range errs {
+		messages[idx] = err.Error()
+	}
+	return messages
+}
+
 type companyUpdateRequest struct {
 	Name    *string `json:"name"`
 	Code    *string `json:"code"`
@@ -179,20 +763,12 @@ type companyUpdateRequest struct {
 	Phone   *string `json:"phone"`
 }
 
-func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
-	var request companyUpdateRequest
-	if err := c.BindJSON(&request); err != nil {
-		c.Status(http.StatusBadRequest)
-		log.Error("couldnt unmarshal company request", zap.Error(err))
-		return
-	}
-
-	companyID := c.Param("companyID")
+func validatedUpdateFields(request companyUpdateRequest) (companies.UpdateFields, []error) {
 	var errs []error
 	update := companies.UpdateFields{}
 
 	if request.Name != nil {
-		if processedName, err := validatedName(*request.Name); err != nil {
+		if processedName, err := validate.Name(*request.Name); err != nil {
 			errs = append(errs, err)
 		} else {
 			update.Name = &processedName
@@ -200,7 +776,7 @@ func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
 	}
 
 	if request.Code != nil {
-		if processedCode, err := validatedCode(*request.Code); err != nil {
+		if processedCode, err := validate.Code(*request.Code); err != nil {
 			errs = append(errs, err)
 		} else {
 			update.Code = &processedCode
@@ -208,7 +784,7 @@ func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
 	}
 
 	if request.Country != nil {
-		if validCountry(*request.Country) {
+		if validate.Country(*request.Country) {
 			update.Country = request.Country
 		} else {
 			errs = append(errs, errors.New("invalid country"))
@@ -216,7 +792,7 @@ func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
 	}
 
 	if request.Website != nil {
-		if processedWebsite, err := validatedWebsite(*request.Website); err != nil {
+		if processedWebsite, err := validate.Website(*request.Website); err != nil {
 			errs = append(errs, err)
 		} else {
 			update.Website = &processedWebsite
@@ -224,81 +800,317 @@ func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
 	}
 
 	if request.Phone != nil {
-		if normalizedPhone, err := normalizePhone(*request.Phone); err != nil {
+		if normalizedPhone, err := validate.Phone(*request.Phone); err != nil {
 			errs = append(errs, err)
 		} else {
 			update.Phone = &normalizedPhone
 		}
 	}
 
+	return update, errs
+}
+
+// replacedCompanyFields validates a full company representation, the
+// required fields must be present while missing or null optional
+// ones are unset.
+func replacedCompanyFields(request companyUpdateRequest) (companies.UpdateFields, []error) {
+	var errs []error
+	for _, required := range []struct {
+		name  string
+		value *string
+	}{
+		{"name", request.Name},
+		{"code", request.Code},
+		{"country", request.Country},
+	} {
+		if required.value == nil {
+			errs = append(errs, errors.New(required.name+" is required"))
+		}
+	}
+	if len(errs) > 0 {
+		return companies.UpdateFields{}, errs
+	}
+
+	replacement := createCompanyRequest{
+		Name:    *request.Name,
+		Code:    *request.Code,
+		Country: *request.Country,
+	}
+	if request.Website != nil {
+		replacement.Website = *request.Website
+	}
+	if request.Phone != nil {
+		replacement.Phone = *request.Phone
+	}
+	fields, errs := validatedCompanyFields(replacement)
+	if len(errs) > 0 {
+		return companies.UpdateFields{}, errs
+	}
+	return companies.UpdateFields{
+		Name:    &fields.Name,
+		Code:    &fields.Code,
+		Country: &fields.Country,
+		Website: &fields.Website,
+		Phone:   &fields.Phone,
+	}, nil
+}
+
+// handleUpdateCompany replaces the company with the full
+// representation in the request.
+func (a *API) handleUpdateCompany(c *gin.Context, log *zap.Logger) {
+	var request companyUpdateRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt unmarshal company request", zap.Error(err))
+		return
+	}
+
+	companyID := c.Param("companyID")
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("invalid if-match header", zap.String("id", companyID))
+		return
+	}
+
+	update, errs := replacedCompanyFields(request)
 	if len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"errors": errs,
+			"errors": errorMessages(errs),
 		})
 		return
 	}
 
-	if err := a.companies.Update(getCtx(c), companyID, update); err != nil {
-		c.Status(http.StatusInternalServerError)
+	err = a.companies.Update(getCtx(c), companyID, version, update)
+	var duplicate *companies.DuplicateError
+	if errors.As(err, &duplicate) {
+		c.JSON(http.StatusConflict, gin.H{
+			"existing_id": duplicate.ExistingID,
+		})
+		log.Error("company code already exists in country", zap.String("existingID", duplicate.ExistingID))
+		return
+	} else if err == companies.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("company to update not found", zap.String("id", companyID))
+		return
+	} else if err == companies.ErrConflict {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("company to update version mismatch", zap.String("id", companyID))
+		return
+	} else if err != nil {
+		serverError(c, err)
 		log.Error("error updating company", zap.Error(err))
 		return
 	}
 	c.Status(http.StatusOK)
 }
 
-func (a *API) handleDeleteCompany(c *gin.Context, log *zap.Logger) {
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// handlePatchCompany applies a merge patch or a JSON patch to the
+// editable company fields and validates the patched result as a whole.
+func (a *API) handlePatchCompany(c *gin.Context, log *zap.Logger) {
 	companyID := c.Param("companyID")
-	err := a.companies.Delete(getCtx(c), companyID)
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("invalid if-match header", zap.String("id", companyID))
+		return
+	}
+
+	var apply func(doc, patch []byte) ([]byte, error)
+	switch c.ContentType() {
+	case mergePatchContentType:
+		apply = jsonpatch.MergePatch
+	case jsonPatchContentType:
+		apply = jsonpatch.Apply
+	default:
+		c.Status(http.StatusUnsupportedMediaType)
+		log.Error("unsupported patch content type", zap.String("contentType", c.ContentType()))
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt read patch request", zap.Error(err))
+		return
+	}
+
+	company, err := a.companies.Get(getCtx(c), companyID)
 	if err == companies.ErrNotFound {
 		c.Status(http.StatusNotFound)
-		log.Error("company to delete not found", zap.String("id", companyID))
+		log.Error("company to patch not found", zap.String("id", companyID))
 		return
 	} else if err != nil {
-		c.Status(http.StatusInternalServerError)
-		log.Error("error deleting company", zap.String("id", companyID), zap.Error(err))
+		serverError(c, err)
+		log.Error("unexpected error fetching company", zap.String("id", companyID), zap.Error(err))
+		return
+	}
+	if version != companies.AnyVersion && version != company.Version {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("company to patch version mismatch", zap.String("id", companyID))
 		return
 	}
-	c.Status(http.StatusOK)
-}
 
-var nameMatcher = regexp.MustCompile("^[A-Za-z ]+$").MatchString
+	doc, _ := json.Marshal(createCompanyRequest{
+		Name:    company.Name,
+		Code:    company.Code,
+		Country: company.Country,
+		Website: company.Website,
+		Phone:   company.Phone,
+	})
+	patched, err := apply(doc, patch)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": []string{err.Error()},
+		})
+		log.Error("company patch test failed", zap.String("id", companyID), zap.Error(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": []string{err.Error()},
+		})
+		log.Error("couldnt apply company patch", zap.String("id", companyID), zap.Error(err))
+		return
+	}
 
-func validatedName(name string) (string, error) {
-	name = strings.Trim(name, " \n")
-	if len(name) < 4 {
-		return "", errors.New("company name must be at least 4 characters")
+	var request createCompanyRequest
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": []string{"patched company is invalid: " + err.Error()},
+		})
+		log.Error("couldnt unmarshal patched company", zap.String("id", companyID), zap.Error(err))
+		return
 	}
-	if !nameMatcher(name) {
-		return "", errors.New("company name can contain only letters and spaces")
+
+	fields, errs := validatedCompanyFields(request)
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages(errs),
+		})
+		return
 	}
-	return name, nil
-}
 
-var codeMatcher = regexp.MustCompile("^[A-Z]+$").MatchString
+	update := companies.UpdateFields{}
+	var changed bool
+	for _, field := range []struct {
+		current, patched string
+		update           **string
+	}{
+		{company.Name, fields.Name, &update.Name},
+		{company.Code, fields.Code, &update.Code},
+		{company.Country, fields.Country, &update.Country},
+		{company.Website, fields.Website, &update.Website},
+		{company.Phone, fields.Phone, &update.Phone},
+	} {
+		if field.patched != field.current {
+			patchedValue := field.patched
+			*field.update = &patchedValue
+			changed = true
+		}
+	}
+	if !changed {
+		c.Header("ETag", companyETag(company.Version))
+		c.JSON(http.StatusOK, company)
+		return
+	}
 
-func validatedCode(code string) (string, error) {
-	code = strings.Trim(code, " \n")
-	if len(code) < 2 {
-		return "", errors.New("")
+	// The fetched version guards the update so a concurrent
+	// change can't be overwritten with a stale patched result.
+	err = a.companies.Update(getCtx(c), companyID, company.Version, update)
+	var duplicate *companies.DuplicateError
+	if errors.As(err, &duplicate) {
+		c.JSON(http.StatusConflict, gin.H{
+			"existing_id": duplicate.ExistingID,
+		})
+		log.Error("company code already exists in country", zap.String("existingID", duplicate.ExistingID))
+		return
+	} else if err == companies.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("company to patch not found", zap.String("id", companyID))
+		return
+	} else if err == companies.ErrConflict {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("company to patch version mismatch", zap.String("id", companyID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error patching company", zap.String("id", companyID), zap.Error(err))
+		return
 	}
-	if !codeMatcher(code) {
-		return "", errors.New("company code can contain only uppercase letters")
+
+	if company, err = a.companies.Get(getCtx(c), companyID); err != nil {
+		serverError(c, err)
+		log.Error("unexpected error fetching patched company", zap.String("id", companyID), zap.Error(err))
+		return
 	}
-	return code, nil
+	c.Header("ETag", companyETag(company.Version))
+	c.JSON(http.StatusOK, company)
 }
 
-func validCountry(country string) bool {
-	return countries.IsValidCountry(country)
+func (a *API) handleDeleteCompany(c *gin.Context, log *zap.Logger) {
+	companyID := c.Param("companyID")
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("invalid if-match header", zap.String("id", companyID))
+		return
+	}
+
+	err = a.companies.Delete(getCtx(c), companyID, version)
+	if err == companies.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("company to delete not found", zap.String("id", companyID))
+		return
+	} else if err == companies.ErrConflict {
+		c.Status(http.StatusPreconditionFailed)
+		log.Error("company to delete version mismatch", zap.String("id", companyID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error deleting company", zap.String("id", companyID), zap.Error(err))
+		return
+	}
+	c.Status(http.StatusOK)
 }
 
-func validatedWebsite(website string) (string, error) {
-	_, err := url.ParseRequestURI(website)
+func (a *API) handleCompanyHistory(c *gin.Context, log *zap.Logger) {
+	companyID := c.Param("companyID")
+	offset, limit, ok := parsePagination(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	entries, err := a.companies.History(getCtx(c), companyID, offset, limit)
 	if err != nil {
-		return "", errors.New("website is not valid url")
+		serverError(c, err)
+		log.Error("company history error", zap.String("id", companyID), zap.Error(err))
+		return
 	}
-	return website, nil
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": entries,
+	})
 }
 
-func normalizePhone(phone string) (string, error) {
-	return phone, nil
+func (a *API) handleRestoreCompany(c *gin.Context, log *zap.Logger) {
+	companyID := c.Param("companyID")
+	err := a.companies.Restore(getCtx(c), companyID)
+	if err == companies.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("company to restore not found in trash", zap.String("id", companyID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error restoring company", zap.String("id", companyID), zap.Error(err))
+		return
+	}
+	c.Status(http.StatusOK)
 }
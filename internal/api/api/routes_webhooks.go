@@ -0,0 +1,221 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
+)
+
+type subscriptionRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+}
+
+const minSecretLength = 16
+
+func validatedSubscriptionFields(request subscriptionRequest) (webhooks.SubscriptionFields, []error) {
+	var errs []error
+	target, err := url.Parse(request.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) < 1 {
+		errs = append(errs, errors.New("url must be an absolute http or https url"))
+	} else if !publicHost(target.Hostname()) {
+		errs = append(errs, errForbiddenTarget)
+	}
+	for _, eventType := range request.EventTypes {
+		if !knownEventType(eventType) {
+			errs = append(errs, errors.New("unknown event type "+eventType))
+		}
+	}
+	if len(request.Secret) > 0 && len(request.Secret) < minSecretLength {
+		errs = append(errs, errors.New("secret must be at least 16 characters"))
+	}
+	return webhooks.SubscriptionFields(request), errs
+}
+
+var errForbiddenTarget = errors.New("url must point to public addresses")
+
+// publicHost catches local targets spelled out in the url, host names
+// are resolved and checked by webhooks.Webhooks.
+func publicHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return webhooks.PublicIP(ip)
+	}
+	return !strings.EqualFold(host, "localhost") && !strings.HasSuffix(strings.ToLower(host), ".localhost")
+}
+
+func knownEventType(eventType string) bool {
+	for _, known := range webhooks.EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *API) handleListWebhooks(c *gin.Context, log *zap.Logger) {
+	subscriptions, err := a.webhooks.List(getCtx(c))
+	if err != nil {
+		serverError(c, err)
+		log.Error("webhooks listing error", zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": subscriptions,
+	})
+}
+
+func (a *API) handleCreateWebhook(c *gin.Context, log *zap.Logger) {
+	var request subscriptionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt unmarshal create webhook request", zap.Error(err))
+		return
+	}
+
+	fields, errs := validatedSubscriptionFields(request)
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages(errs),
+		})
+		return
+	}
+
+	log.Info("create webhook request", zap.String("url", fields.URL), zap.Strings("eventTypes", fields.EventTypes))
+	subscription, err := a.webhooks.Create(getCtx(c), fields)
+	if err == webhooks.ErrForbiddenTarget {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages([]error{errForbiddenTarget}),
+		})
+		log.Error("webhook target not allowed", zap.String("url", fields.URL))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error creating webhook", zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusCreated, subscription)
+}
+
+func (a *API) handleGetWebhook(c *gin.Context, log *zap.Logger) {
+	subscriptionID := c.Param("subscriptionID")
+	subscription, err := a.webhooks.Get(getCtx(c), subscriptionID)
+	if err == webhooks.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("webhook not found", zap.String("id", subscriptionID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("unexpected error fetching webhook", zap.String("id", subscriptionID), zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (a *API) handleUpdateWebhook(c *gin.Context, log *zap.Logger) {
+	subscriptionID := c.Param("subscriptionID")
+	var request subscriptionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Error("couldnt unmarshal update webhook request", zap.Error(err))
+		return
+	}
+
+	fields, errs := validatedSubscriptionFields(request)
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages(errs),
+		})
+		return
+	}
+
+	err := a.webhooks.Update(getCtx(c), subscriptionID, fields)
+	if err == webhooks.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("webhook to update not found", zap.String("id", subscriptionID))
+		return
+	} else if err == webhooks.ErrForbiddenTarget {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": errorMessages([]error{errForbiddenTarget}),
+		})
+		log.Error("webhook target not allowed", zap.String("url", fields.URL))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error updating webhook", zap.String("id", subscriptionID), zap.Error(err))
+		return
+	}
+
+	subscription, err := a.webhooks.Get(getCtx(c), subscriptionID)
+	if err != nil {
+		serverError(c, err)
+		log.Error("unexpected error fetching updated webhook", zap.String("id", subscriptionID), zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (a *API) handleDeleteWebhook(c *gin.Context, log *zap.Logger) {
+	subscriptionID := c.Param("subscriptionID")
+	err := a.webhooks.Delete(getCtx(c), subscriptionID)
+	if err == webhooks.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("webhook to delete not found", zap.String("id", subscriptionID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error deleting webhook", zap.String("id", subscriptionID), zap.Error(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (a *API) handleWebhookDeliveries(c *gin.Context, log *zap.Logger) {
+	subscriptionID := c.Param("subscriptionID")
+	offset, limit, ok := parsePagination(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := a.webhooks.Deliveries(getCtx(c), subscriptionID, offset, limit)
+	if err == webhooks.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("webhook not found", zap.String("id", subscriptionID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("webhook deliveries error", zap.String("id", subscriptionID), zap.Error(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": deliveries,
+	})
+}
+
+func (a *API) handleRedeliverWebhook(c *gin.Context, log *zap.Logger) {
+	subscriptionID := c.Param("subscriptionID")
+	deliveryID := c.Param("deliveryID")
+	err := a.webhooks.Redeliver(getCtx(c), subscriptionID, deliveryID)
+	if err == webhooks.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		log.Error("webhook delivery not found", zap.String("id", deliveryID))
+		return
+	} else if err == webhooks.ErrNotDead {
+		c.Status(http.StatusConflict)
+		log.Error("webhook delivery not dead", zap.String("id", deliveryID))
+		return
+	} else if err != nil {
+		serverError(c, err)
+		log.Error("error redelivering webhook", zap.String("id", deliveryID), zap.Error(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
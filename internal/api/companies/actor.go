@@ -0,0 +1,22 @@
+package companies
+
+import "context"
+
+// Actor describes who initiated a change, it is recorded
+// in company history.
+type Actor struct {
+	RequestID     string
+	ClientIP      string
+	ClientCountry string
+}
+
+type actorCtxKey struct{}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorCtxKey{}).(Actor)
+	return actor
+}
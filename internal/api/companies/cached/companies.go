@@ -0,0 +1,248 @@
+// Package cached is a read-through caching layer over another
+// companies.Companies. Cached values are shared between callers,
+// they must not be modified.
+package cached
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/models"
+)
+
+type Options struct {
+	// Size bounds the number of companies and,
+	// separately, search pages kept.
+	Size int
+	TTL  time.Duration
+	// SearchTTL enables caching of search pages when positive, any
+	// write drops all of them as it may change any page.
+	SearchTTL time.Duration
+}
+
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+type Stats struct {
+	Get    CacheStats  `json:"get"`
+	Search *CacheStats `json:"search,omitempty"`
+}
+
+type counters struct {
+	hits   uint64
+	misses uint64
+}
+
+func (c *counters) stats(cache *lru) CacheStats {
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: cache.len(),
+	}
+}
+
+type Companies struct {
+	next companies.Companies
+
+	companies *lru
+	loads     singleflight.Group
+	getStats  counters
+
+	pages       *lru
+	searchStats counters
+}
+
+func NewCachedCompanies(next companies.Companies, opts Options) *Companies {
+	c := &Companies{
+		next:      next,
+		companies: newLRU(opts.Size, opts.TTL),
+	}
+	if opts.SearchTTL > 0 {
+		c.pages = newLRU(opts.Size, opts.SearchTTL)
+	}
+	return c
+}
+
+func (c *Companies) Stats() Stats {
+	stats := Stats{Get: c.getStats.stats(c.companies)}
+	if c.pages != nil {
+		searchStats := c.searchStats.stats(c.pages)
+		stats.Search = &searchStats
+	}
+	return stats
+}
+
+// Get loads missing companies once for all concurrent callers, with
+// the context of the caller that came first.
+func (c *Companies) Get(ctx context.Context, id string) (*models.Company, error) {
+	if company, ok := c.companies.get(id); ok {
+		atomic.AddUint64(&c.getStats.hits, 1)
+		return company.(*models.Company), nil
+	}
+	atomic.AddUint64(&c.getStats.misses, 1)
+
+	company, err, _ := c.loads.Do(id, func() (interface{}, error) {
+		generation := c.companies.currentGeneration()
+		company, err := c.next.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		c.companies.put(id, company, generation)
+		return company, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return company.(*models.Company), nil
+}
+
+type searchPage struct {
+	results []*models.Company
+	next    string
+}
+
+func (c *Companies) Search(
+	ctx context.Context,
+	query companies.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	if c.pages == nil {
+		return c.next.Search(ctx, query, cursor, limit)
+	}
+	key, err := json.Marshal(struct {
+		Query  companies.SearchFilters
+		Cursor string
+		Limit  uint64
+	}{query, cursor, limit})
+	if err != nil {
+		return nil, "", err
+	}
+	if page, ok := c.pages.get(string(key)); ok {
+		atomic.AddUint64(&c.searchStats.hits, 1)
+		return page.(*searchPage).results, page.(*searchPage).next, nil
+	}
+	atomic.AddUint64(&c.searchStats.misses, 1)
+
+	generation := c.pages.currentGeneration()
+	results, next, err := c.next.Search(ctx, query, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	c.pages.put(string(key), &searchPage{results, next}, generation)
+	return results, next, nil
+}
+
+// invalidate drops the cached company and any search pages, it
+// runs after writes whatever their outcome as even failed ones
+// may have changed something.
+func (c *Companies) invalidate(id string) {
+	c.companies.remove(id)
+	c.loads.Forget(id)
+	c.invalidatePages()
+}
+
+func (c *Companies) invalidateAll() {
+	c.companies.clear()
+	c.invalidatePages()
+}
+
+func (c *Companies) invalidatePages() {
+	if c.pages != nil {
+		c.pages.clear()
+	}
+}
+
+func (c *Companies) Count(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	return c.next.Count(ctx, query)
+}
+
+func (c *Companies) Export(ctx context.Context, query companies.SearchFilters, fn func(*models.Company) error) error {
+	return c.next.Export(ctx, query, fn)
+}
+
+func (c *Companies) TextSearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	return c.next.TextSearch(ctx, query, cursor, limit)
+}
+
+func (c *Companies) FuzzySearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	name companies.FuzzyName,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	return c.next.FuzzySearch(ctx, query, name, cursor, limit)
+}
+
+func (c *Companies) GetAsOf(ctx context.Context, id string, asOf time.Time) (*models.Company, error) {
+	return c.next.GetAsOf(ctx, id, asOf)
+}
+
+func (c *Companies) Create(ctx context.Context, fields companies.CompanyFields) (string, error) {
+	defer c.invalidatePages()
+	return c.next.Create(ctx, fields)
+}
+
+func (c *Companies) CreateBatch(
+	ctx context.Context,
+	items []companies.CompanyFields,
+	atomic bool,
+) ([]companies.BatchResult, error) {
+	defer c.invalidatePages()
+	return c.next.CreateBatch(ctx, items, atomic)
+}
+
+func (c *Companies) Update(ctx context.Context, id string, version uint64, update companies.UpdateFields) error {
+	defer c.invalidate(id)
+	return c.next.Update(ctx, id, version, update)
+}
+
+func (c *Companies) BulkUpdate(
+	ctx context.Context,
+	query companies.SearchFilters,
+	update companies.UpdateFields,
+) (uint64, error) {
+	defer c.invalidateAll()
+	return c.next.BulkUpdate(ctx, query, update)
+}
+
+func (c *Companies) Delete(ctx context.Context, id string, version uint64) error {
+	defer c.invalidate(id)
+	return c.next.Delete(ctx, id, version)
+}
+
+func (c *Companies) BulkDelete(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	defer c.invalidateAll()
+	return c.next.BulkDelete(ctx, query)
+}
+
+func (c *Companies) Trash(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	return c.next.Trash(ctx, skip, limit)
+}
+
+func (c *Companies) Restore(ctx context.Context, id string) error {
+	defer c.invalidate(id)
+	return c.next.Restore(ctx, id)
+}
+
+func (c *Companies) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	return c.next.Purge(ctx, deletedBefore)
+}
+
+func (c *Companies) History(ctx context.Context, id string, skip, limit uint64) ([]*models.HistoryEntry, error) {
+	return c.next.History(ctx, id, skip, limit)
+}
@@ -0,0 +1,178 @@
+package cached
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
+	"github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
+)
+
+// countingCompanies counts the calls reaching the wrapped layer,
+// Get waits for release when it is set.
+type countingCompanies struct {
+	companies.Companies
+	gets     int32
+	searches int32
+	release  chan struct{}
+}
+
+func (c *countingCompanies) Get(ctx context.Context, id string) (*models.Company, error) {
+	atomic.AddInt32(&c.gets, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	return c.Companies.Get(ctx, id)
+}
+
+func (c *countingCompanies) Search(
+	ctx context.Context,
+	query companies.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	atomic.AddInt32(&c.searches, 1)
+	return c.Companies.Search(ctx, query, cursor, limit)
+}
+
+func createTestCompanies(t *testing.T, opts Options) (*Companies, *countingCompanies, string) {
+	next := &countingCompanies{
+		Companies: directstore.NewDirectStoreCompanies(memory.NewStore(), memory.NewHistoryStore(), nil),
+	}
+	id, err := next.Create(context.Background(), companies.CompanyFields{
+		Name:    "Acme Trading",
+		Code:    "AC",
+		Country: "Cyprus",
+	})
+	assert.NoError(t, err)
+	return NewCachedCompanies(next, opts), next, id
+}
+
+func TestGet(t *testing.T) {
+	c, next, id := createTestCompanies(t, Options{Size: 10, TTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		company, err := c.Get(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Acme Trading", company.Name)
+	}
+	assert.Equal(t, int32(1), next.gets)
+	assert.Equal(t, Stats{Get: CacheStats{Hits: 2, Misses: 1, Entries: 1}}, c.Stats())
+
+	_, err := c.Get(ctx, "missing")
+	assert.Equal(t, companies.ErrNotFound, err)
+	_, err = c.Get(ctx, "missing")
+	assert.Equal(t, companies.ErrNotFound, err)
+	assert.Equal(t, int32(3), next.gets)
+
+	name := "Acme Holdings"
+	assert.NoError(t, c.Update(ctx, id, companies.AnyVersion, companies.UpdateFields{Name: &name}))
+	company, err := c.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Acme Holdings", company.Name)
+
+	assert.NoError(t, c.Delete(ctx, id, companies.AnyVersion))
+	_, err = c.Get(ctx, id)
+	assert.Equal(t, companies.ErrNotFound, err)
+	assert.Equal(t, 0, c.Stats().Get.Entries)
+}
+
+func TestGetExpires(t *testing.T) {
+	c, next, id := createTestCompanies(t, Options{Size: 10, TTL: time.Minute})
+	now := time.Now()
+	c.companies.now = func() time.Time { return now }
+
+	_, err := c.Get(context.Background(), id)
+	assert.NoError(t, err)
+	now = now.Add(59 * time.Second)
+	_, err = c.Get(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), next.gets)
+
+	now = now.Add(time.Second)
+	_, err = c.Get(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), next.gets)
+}
+
+func TestGetCoalesced(t *testing.T) {
+	c, next, id := createTestCompanies(t, Options{Size: 10, TTL: time.Minute})
+	next.release = make(chan struct{})
+
+	const callers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			company, err := c.Get(context.Background(), id)
+			assert.NoError(t, err)
+			assert.Equal(t, id, company.ID)
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		return c.Stats().Get.Misses == callers
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), next.gets)
+}
+
+func TestSearchPages(t *testing.T) {
+	ctx := context.Background()
+
+	c, next, _ := createTestCompanies(t, Options{Size: 10, TTL: time.Minute})
+	for i := 0; i < 2; i++ {
+		_, _, err := c.Search(ctx, companies.SearchFilters{}, "", 10)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), next.searches)
+	assert.Nil(t, c.Stats().Search)
+
+	c, next, _ = createTestCompanies(t, Options{Size: 10, TTL: time.Minute, SearchTTL: time.Minute})
+	for i := 0; i < 2; i++ {
+		results, _, err := c.Search(ctx, companies.SearchFilters{}, "", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+	}
+	assert.Equal(t, int32(1), next.searches)
+	assert.Equal(t, &CacheStats{Hits: 1, Misses: 1, Entries: 1}, c.Stats().Search)
+
+	_, err := c.Create(ctx, companies.CompanyFields{Name: "Beta Trading", Code: "BE", Country: "Cyprus"})
+	assert.NoError(t, err)
+	results, _, err := c.Search(ctx, companies.SearchFilters{}, "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, int32(2), next.searches)
+}
+
+func TestLRUEvicts(t *testing.T) {
+	cache := newLRU(2, time.Minute)
+	cache.put("a", 1, 0)
+	cache.put("b", 2, 0)
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.put("c", 3, 0)
+
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, cache.len())
+
+	generation := cache.currentGeneration()
+	cache.remove("a")
+	cache.put("a", 4, generation)
+	_, ok = cache.get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package cached
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a size bounded cache dropping the least recently used entries,
+// entries older than ttl are dropped on access.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List
+	// generation changes on every removal, values loaded before
+	// it are stale and put ignores them.
+	generation uint64
+	now        func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		items: map[string]*list.Element{},
+		order: list.New(),
+		now:   time.Now,
+	}
+}
+
+func (l *lru) get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	element, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !l.now().Before(entry.expires) {
+		l.order.Remove(element)
+		delete(l.items, key)
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (l *lru) currentGeneration() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.generation
+}
+
+// put stores the value loaded at the generation unless
+// something was removed since.
+func (l *lru) put(key string, value interface{}, generation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if generation != l.generation {
+		return
+	}
+	entry := &lruEntry{key, value, l.now().Add(l.ttl)}
+	if element, ok := l.items[key]; ok {
+		element.Value = entry
+		l.order.MoveToFront(element)
+		return
+	}
+	l.items[key] = l.order.PushFront(entry)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lru) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	if element, ok := l.items[key]; ok {
+		l.order.Remove(element)
+		delete(l.items, key)
+	}
+}
+
+func (l *lru) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	l.items = map[string]*list.Element{}
+	l.order.Init()
+}
+
+func (l *lru) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
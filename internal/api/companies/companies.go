@@ -3,6 +3,7 @@ package companies
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/models"
 )
@@ -23,23 +24,182 @@ type CompanyOptFields struct {
 	Phone   *string
 }
 
-type SearchFilters CompanyOptFields
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// StringFilter matches a string field, every set operator must match.
+type StringFilter struct {
+	Eq     *string
+	In     []string
+	Prefix *string
+	// Contains matches a substring ignoring case.
+	Contains *string
+}
+
+func (f StringFilter) IsZero() bool {
+	return f.Eq == nil && f.In == nil && f.Prefix == nil && f.Contains == nil
+}
+
+// TimeRange matches times strictly between After and Before.
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
+}
+
+func (r TimeRange) IsZero() bool {
+	return r.After == nil && r.Before == nil
+}
+
+type SearchFilters struct {
+	Name      StringFilter
+	Code      StringFilter
+	Country   StringFilter
+	Website   StringFilter
+	Phone     StringFilter
+	CreatedAt TimeRange
+	UpdatedAt TimeRange
+	// Text matches companies having any of the text words
+	// in their name, code or website.
+	Text *string
+	// Sort orders results by the fields in turn,
+	// creation order is used when empty.
+	Sort []SortField
+}
+
+// IsZero tells whether no filter is set, such
+// filters match every company whatever the Sort.
+func (f SearchFilters) IsZero() bool {
+	return f.Name.IsZero() &&
+		f.Code.IsZero() &&
+		f.Country.IsZero() &&
+		f.Website.IsZero() &&
+		f.Phone.IsZero() &&
+		f.CreatedAt.IsZero() &&
+		f.UpdatedAt.IsZero() &&
+		f.Text == nil
+}
+
+// FuzzyName matches names at least Similarity alike to Name, from
+// near 0 for a single shared trigram to 1 for the same words.
+type FuzzyName struct {
+	Name       string
+	Similarity float64
+}
 
+// UpdateFields changes the fields that are not nil, an empty
+// Website or Phone unsets it.
 type UpdateFields CompanyOptFields
 
+// BatchResult is the outcome of creating one item of a batch, ID is
+// set when the company was created, even if Err is set like for Create.
+type BatchResult struct {
+	ID  string
+	Err error
+}
+
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("version conflict")
+	ErrDuplicate = errors.New("duplicate company")
+
+	ErrUnavailable = errors.New("companies unavailable")
+
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	ErrBatchAborted            = errors.New("batch aborted")
+	ErrTransactionsUnsupported = errors.New("transactions not supported")
 )
 
+// DuplicateError is returned when a company with the same code already
+// exists in the country, it matches ErrDuplicate with errors.Is.
+type DuplicateError struct {
+	ExistingID string
+}
+
+func (e *DuplicateError) Error() string {
+	return "company with same code and country exists: " + e.ExistingID
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// UnavailableError is returned while the companies can't be reached,
+// it matches ErrUnavailable with errors.Is.
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return "companies unavailable, retry after " + e.RetryAfter.String()
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// AnyVersion makes Update and Delete unconditional.
+const AnyVersion uint64 = 0
+
 type Companies interface {
+	// Search returns a page of companies and the opaque cursor of the
+	// next page, empty when there are no more results.
 	Search(
 		ctx context.Context,
 		query SearchFilters,
-		skip,
+		cursor string,
+		limit uint64,
+	) ([]*models.Company, string, error)
+	Count(ctx context.Context, query SearchFilters) (uint64, error)
+	// Export calls fn for every company Search would list across all
+	// pages, streaming them from the store. It stops at the first
+	// error fn returns and returns it.
+	Export(ctx context.Context, query SearchFilters, fn func(*models.Company) error) error
+	// TextSearch ranks companies matching query.Text by relevance,
+	// query.Sort is ignored.
+	TextSearch(
+		ctx context.Context,
+		query SearchFilters,
+		cursor string,
+		limit uint64,
+	) ([]*models.ScoredCompany, string, error)
+	// FuzzySearch ranks companies with names alike to name.Name by
+	// similarity, it tolerates typos unlike the other searches.
+	// query.Sort is ignored.
+	FuzzySearch(
+		ctx context.Context,
+		query SearchFilters,
+		name FuzzyName,
+		cursor string,
 		limit uint64,
-	) ([]*models.Company, error)
+	) ([]*models.ScoredCompany, string, error)
 	Get(ctx context.Context, id string) (*models.Company, error)
+	// GetAsOf rebuilds the company from its history as it was at the
+	// given moment, ErrNotFound means it didn't exist or was deleted.
+	// Companies created before history was kept are rebuilt from their
+	// first entry or stored state, also when they are deleted by now.
+	GetAsOf(ctx context.Context, id string, asOf time.Time) (*models.Company, error)
+	// Create returns the id of the new company. An id together with an
+	// error means the company was created but recording the change failed.
 	Create(ctx context.Context, fields CompanyFields) (string, error)
-	Update(ctx context.Context, id string, update UpdateFields) error
-	Delete(ctx context.Context, id string) error
+	// CreateBatch creates the companies in order and reports the outcome
+	// of each. An atomic batch creates all of them or none, when one fails
+	// the rest report ErrBatchAborted. Atomic batches need a store with
+	// transactions or ErrTransactionsUnsupported is returned.
+	CreateBatch(ctx context.Context, items []CompanyFields, atomic bool) ([]BatchResult, error)
+	Update(ctx context.Context, id string, version uint64, update UpdateFields) error
+	// BulkUpdate updates every company Search would list for the query
+	// and returns how many were updated. ErrDuplicate means the update
+	// would give two companies the same code in a country.
+	BulkUpdate(ctx context.Context, query SearchFilters, update UpdateFields) (uint64, error)
+	Delete(ctx context.Context, id string, version uint64) error
+	// BulkDelete moves every company Search would list for the
+	// query to trash and returns how many were moved.
+	BulkDelete(ctx context.Context, query SearchFilters) (uint64, error)
+	Trash(ctx context.Context, skip, limit uint64) ([]*models.Company, error)
+	Restore(ctx context.Context, id string) error
+	Purge(ctx context.Context, deletedBefore time.Time) (uint64, error)
+	History(ctx context.Context, id string, skip, limit uint64) ([]*models.HistoryEntry, error)
 }
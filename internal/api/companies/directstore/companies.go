@@ -2,63 +2,306 @@ package directstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/fuzzy"
 	"github.com/RavisMsk/xmcompanies/internal/api/models"
 	storeModels "github.com/RavisMsk/xmcompanies/internal/companies/models"
 	"github.com/RavisMsk/xmcompanies/internal/companies/store"
 )
 
 type Companies struct {
-	store store.Store
+	store   store.Store
+	history store.HistoryStore
+	outbox  store.OutboxStore
+	names   *fuzzy.Index
+	// transactor is set when writes have to be atomic
+	// with their events and the store allows for it.
+	transactor store.Transactor
+	// bulk is set whenever the store has transactions, a bulk
+	// write failing halfway is rolled back with its history.
+	bulk store.Transactor
 }
 
-func NewDirectStoreCompanies(store store.Store) *Companies {
-	return &Companies{store}
+// NewDirectStoreCompanies records an outbox event for every change
+// unless outbox is nil. Changes and their events are stored in a
+// single transaction when the store has them.
+func NewDirectStoreCompanies(
+	companies store.Store,
+	history store.HistoryStore,
+	outbox store.OutboxStore,
+) *Companies {
+	c := &Companies{store: companies, history: history, outbox: outbox, names: fuzzy.NewIndex()}
+	if transactor, ok := companies.(store.Transactor); ok {
+		c.bulk = transactor
+		if outbox != nil {
+			c.transactor = transactor
+		}
+	}
+	return c
+}
+
+// LoadNames fills the fuzzy name index from the store, it has to
+// run once before serving, writes through Companies keep it current.
+// Running it again rebuilds the index for writes made elsewhere, the
+// ones through Companies while it runs may be missed until the next.
+func (c *Companies) LoadNames(ctx context.Context) error {
+	names := fuzzy.NewIndex()
+	err := c.store.Each(ctx, store.SearchFilters{}, nil, func(company *storeModels.Company) error {
+		names.Put(company.ID, company.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.names.Replace(names)
+	return nil
 }
 
 func (c *Companies) Search(
 	ctx context.Context,
 	query companies.SearchFilters,
-	skip uint64,
+	cursor string,
 	limit uint64,
-) ([]*models.Company, error) {
-	results, err := c.store.Search(ctx, store.CompanyOptFields(query), skip, limit)
+) ([]*models.Company, string, error) {
+	results, next, err := c.store.Search(
+		ctx,
+		toStoreQuery(query),
+		toStoreSort(query.Sort),
+		cursor,
+		limit,
+	)
 	if err != nil {
-		return nil, err
+		return nil, "", translateError(err)
 	}
-	companies := make([]*models.Company, len(results))
-	for idx, result := range results {
-		companies[idx] = &models.Company{
-			ID:      result.ID,
-			Name:    result.Name,
-			Code:    result.Code,
-			Country: result.Country,
-			Website: result.Website,
-			Phone:   result.Phone,
+	return fromStoreModels(results), next, nil
+}
+
+func (c *Companies) TextSearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	results, next, err := c.store.TextSearch(ctx, toStoreQuery(query), cursor, limit)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	return fromStoreScored(results), next, nil
+}
+
+// fuzzyFetchBatch is how many fuzzy name matches are fetched at once.
+const fuzzyFetchBatch = 100
+
+func (c *Companies) FuzzySearch(
+	ctx context.Context,
+	query companies.SearchFilters,
+	name companies.FuzzyName,
+	cursor string,
+	limit uint64,
+) ([]*models.ScoredCompany, string, error) {
+	after, err := store.DecodeTextCursor(cursor)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	filters := toStoreQuery(query)
+
+	var (
+		results []*store.ScoredCompany
+		matches = c.names.Search(name.Name, name.Similarity)
+	)
+	// Matches come best first, one past the limit tells
+	// whether there is a next page.
+	for len(matches) > 0 && (limit == 0 || uint64(len(results)) < store.FetchLimit(limit)) {
+		var (
+			batch []*store.ScoredCompany
+			ids   []string
+		)
+		for len(matches) > 0 && len(batch) < fuzzyFetchBatch {
+			result := &store.ScoredCompany{
+				Company: &storeModels.Company{ID: matches[0].ID},
+				Score:   matches[0].Score,
+			}
+			matches = matches[1:]
+			if after.After(result) {
+				batch = append(batch, result)
+				ids = append(ids, result.Company.ID)
+			}
+		}
+		if len(ids) < 1 {
+			continue
+		}
+
+		found, err := c.store.GetMany(ctx, ids)
+		if err != nil {
+			return nil, "", translateError(err)
+		}
+		companiesByID := make(map[string]*storeModels.Company, len(found))
+		for _, company := range found {
+			companiesByID[company.ID] = company
+		}
+		for _, result := range batch {
+			company, ok := companiesByID[result.Company.ID]
+			if !ok || !filters.Matches(company) {
+				continue
+			}
+			result.Company = company
+			results = append(results, result)
+			if limit > 0 && uint64(len(results)) == store.FetchLimit(limit) {
+				break
+			}
 		}
 	}
-	return companies, nil
+
+	results, next := store.PageText(results, limit)
+	return fromStoreScored(results), next, nil
+}
+
+func (c *Companies) Export(
+	ctx context.Context,
+	query companies.SearchFilters,
+	fn func(*models.Company) error,
+) error {
+	err := c.store.Each(ctx, toStoreQuery(query), toStoreSort(query.Sort), func(company *storeModels.Company) error {
+		return fn(fromStoreModel(company))
+	})
+	return translateError(err)
+}
+
+func (c *Companies) Count(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	count, err := c.store.Count(ctx, toStoreQuery(query))
+	return count, translateError(err)
 }
 
 func (c *Companies) Get(ctx context.Context, id string) (*models.Company, error) {
 	company, err := c.store.Get(ctx, id)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
-	return &models.Company{
-		ID:      company.ID,
-		Name:    company.Name,
-		Code:    company.Code,
-		Country: company.Country,
-		Website: company.Website,
-		Phone:   company.Phone,
-	}, nil
+	return fromStoreModel(company), nil
+}
+
+func (c *Companies) GetAsOf(ctx context.Context, id string, asOf time.Time) (*models.Company, error) {
+	entry, err := c.history.HistoryAt(ctx, id, asOf)
+	if err == store.ErrNotFound {
+		return c.getBeforeHistory(ctx, id, asOf)
+	} else if err != nil {
+		return nil, translateError(err)
+	}
+	return fromSnapshotAt(id, entry.After)
+}
+
+// getBeforeHistory covers companies created before their changes were
+// recorded, they were as the first entry says before it, or as stored
+// now when there are no entries, trashed ones included.
+func (c *Companies) getBeforeHistory(ctx context.Context, id string, asOf time.Time) (*models.Company, error) {
+	entries, err := c.history.SearchHistory(ctx, id, 0, 1)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(entries) > 0 {
+		snapshot := entries[0].Before
+		if snapshot == nil {
+			return nil, companies.ErrNotFound
+		}
+		createdAt := snapshot.CreatedAt
+		if createdAt.IsZero() {
+			// Entries recorded before snapshots kept it.
+			company, err := c.store.GetAny(ctx, id)
+			if err != nil {
+				return nil, translateError(err)
+			}
+			createdAt = company.CreatedAt
+		}
+		if createdAt.After(asOf) {
+			return nil, companies.ErrNotFound
+		}
+		return fromSnapshotAt(id, snapshot)
+	}
+
+	company, err := c.store.GetAny(ctx, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if company.CreatedAt.After(asOf) || (company.DeletedAt != nil && !company.DeletedAt.After(asOf)) {
+		return nil, companies.ErrNotFound
+	}
+	company.DeletedAt = nil
+	return fromStoreModel(company), nil
 }
 
 func (c *Companies) Create(ctx context.Context, company companies.CompanyFields) (string, error) {
+	var storeModel *storeModels.Company
+	err := c.write(ctx, func(ctx context.Context) (err error) {
+		storeModel, err = c.insert(ctx, company)
+		return err
+	})
+	if storeModel == nil || !c.committed(err) {
+		return "", err
+	}
+	c.names.Put(storeModel.ID, storeModel.Name)
+	return storeModel.ID, err
+}
+
+// errBatchRollback aborts the transaction of an atomic batch.
+var errBatchRollback = errors.New("batch rolled back")
+
+func (c *Companies) CreateBatch(
+	ctx context.Context,
+	items []companies.CompanyFields,
+	atomic bool,
+) ([]companies.BatchResult, error) {
+	results := make([]companies.BatchResult, len(items))
+	if !atomic {
+		for idx, item := range items {
+			results[idx].ID, results[idx].Err = c.Create(ctx, item)
+		}
+		return results, nil
+	}
+
+	transactor, ok := c.store.(store.Transactor)
+	if !ok {
+		return nil, companies.ErrTransactionsUnsupported
+	}
+	var created []*storeModels.Company
+	err := transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		created = created[:0]
+		for idx, item := range items {
+			company, err := c.insert(ctx, item)
+			if err != nil {
+				for other := range results {
+					results[other] = companies.BatchResult{Err: companies.ErrBatchAborted}
+				}
+				results[idx].Err = err
+				return errBatchRollback
+			}
+			created = append(created, company)
+		}
+		return nil
+	})
+	if err == errBatchRollback {
+		return results, nil
+	} else if err != nil {
+		return nil, translateError(err)
+	}
+
+	for idx, company := range created {
+		results[idx].ID = company.ID
+		c.names.Put(company.ID, company.Name)
+	}
+	return results, nil
+}
+
+// insert returns the company once stored, even when recording
+// the change fails after that.
+func (c *Companies) insert(
+	ctx context.Context,
+	company companies.CompanyFields,
+) (*storeModels.Company, error) {
 	storeModel := storeModels.Company{
 		ID:      uuid.New().String(),
 		Name:    company.Name,
@@ -67,13 +310,422 @@ func (c *Companies) Create(ctx context.Context, company companies.CompanyFields)
 		Website: company.Website,
 		Phone:   company.Phone,
 	}
-	return storeModel.ID, c.store.Insert(ctx, &storeModel)
+	if err := c.store.Insert(ctx, &storeModel); err != nil {
+		return nil, translateError(err)
+	}
+	err := c.recordChange(ctx, storeModel.ID, storeModels.HistoryActionCreate, nil, &storeModel)
+	return &storeModel, err
+}
+
+// anyVersionAttempts bounds retries of unconditional writes
+// losing to concurrent ones.
+const anyVersionAttempts = 3
+
+// Update and Delete with companies.AnyVersion are conditional on the
+// version read before, so the recorded change is the one written.
+func (c *Companies) Update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	update companies.UpdateFields,
+) error {
+	for attempt := 1; ; attempt++ {
+		err := c.update(ctx, id, version, update)
+		if err != companies.ErrConflict || version != companies.AnyVersion || attempt == anyVersionAttempts {
+			return err
+		}
+	}
+}
+
+func (c *Companies) update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	update companies.UpdateFields,
+) error {
+	var after *storeModels.Company
+	err := c.write(ctx, func(ctx context.Context) error {
+		before, err := c.store.Get(ctx, id)
+		if err != nil {
+			return translateError(err)
+		}
+		condition := version
+		if condition == companies.AnyVersion {
+			condition = before.Version
+		}
+		if err = c.store.Update(ctx, id, condition, store.CompanyOptFields(update)); err != nil {
+			return translateError(err)
+		}
+		if after, err = c.store.Get(ctx, id); err != nil {
+			return translateError(err)
+		}
+		return c.recordChange(ctx, id, storeModels.HistoryActionUpdate, before, after)
+	})
+	if after != nil && c.committed(err) {
+		c.names.Put(id, after.Name)
+	}
+	return err
 }
 
-func (c *Companies) Update(ctx context.Context, id string, update companies.UpdateFields) error {
-	return c.store.Update(ctx, id, store.CompanyOptFields(update))
+func (c *Companies) Delete(ctx context.Context, id string, version uint64) error {
+	for attempt := 1; ; attempt++ {
+		err := c.delete(ctx, id, version)
+		if err != companies.ErrConflict || version != companies.AnyVersion || attempt == anyVersionAttempts {
+			return err
+		}
+	}
 }
 
-func (c *Companies) Delete(ctx context.Context, id string) error {
-	return c.store.Delete(ctx, id)
+func (c *Companies) delete(ctx context.Context, id string, version uint64) error {
+	var deleted bool
+	err := c.write(ctx, func(ctx context.Context) error {
+		deleted = false
+		before, err := c.store.Get(ctx, id)
+		if err != nil {
+			return translateError(err)
+		}
+		condition := version
+		if condition == companies.AnyVersion {
+			condition = before.Version
+		}
+		if err = c.store.Delete(ctx, id, condition); err != nil {
+			return translateError(err)
+		}
+		deleted = true
+		return c.recordChange(ctx, id, storeModels.HistoryActionDelete, before, nil)
+	})
+	if deleted && c.committed(err) {
+		c.names.Remove(id)
+	}
+	return err
+}
+
+func (c *Companies) BulkUpdate(
+	ctx context.Context,
+	query companies.SearchFilters,
+	update companies.UpdateFields,
+) (uint64, error) {
+	var (
+		updated uint64
+		changed []*storeModels.Company
+	)
+	err := c.writeMany(ctx, func(ctx context.Context) error {
+		changed = changed[:0]
+		filters := toStoreQuery(query)
+		before, _, err := c.store.Search(ctx, filters, nil, "", 0)
+		if err != nil {
+			return translateError(err)
+		}
+		if updated, err = c.store.UpdateMany(ctx, filters, store.CompanyOptFields(update)); err != nil {
+			return translateError(err)
+		}
+
+		// Without a transaction companies matched in between are updated
+		// without history, ones deleted or changed again since are skipped.
+		for _, company := range before {
+			after, err := c.store.Get(ctx, company.ID)
+			if err == store.ErrNotFound {
+				continue
+			} else if err != nil {
+				return translateError(err)
+			}
+			if after.Version != company.Version+1 {
+				continue
+			}
+			changed = append(changed, after)
+			err = c.recordChange(ctx, after.ID, storeModels.HistoryActionUpdate, company, after)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && c.bulk != nil {
+		return 0, err
+	}
+	for _, company := range changed {
+		c.names.Put(company.ID, company.Name)
+	}
+	return updated, err
+}
+
+func (c *Companies) BulkDelete(ctx context.Context, query companies.SearchFilters) (uint64, error) {
+	var (
+		deleted uint64
+		removed []*storeModels.Company
+	)
+	err := c.writeMany(ctx, func(ctx context.Context) error {
+		removed = removed[:0]
+		filters := toStoreQuery(query)
+		before, _, err := c.store.Search(ctx, filters, nil, "", 0)
+		if err != nil {
+			return translateError(err)
+		}
+		if deleted, err = c.store.DeleteMany(ctx, filters); err != nil {
+			return translateError(err)
+		}
+
+		for _, company := range before {
+			removed = append(removed, company)
+			err = c.recordChange(ctx, company.ID, storeModels.HistoryActionDelete, company, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && c.bulk != nil {
+		return 0, err
+	}
+	for _, company := range removed {
+		c.names.Remove(company.ID)
+	}
+	return deleted, err
+}
+
+func (c *Companies) Trash(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	results, err := c.store.SearchDeleted(ctx, skip, limit)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return fromStoreModels(results), nil
+}
+
+func (c *Companies) Restore(ctx context.Context, id string) error {
+	var after *storeModels.Company
+	err := c.write(ctx, func(ctx context.Context) (err error) {
+		if err = c.store.Restore(ctx, id); err != nil {
+			return translateError(err)
+		}
+		if after, err = c.store.Get(ctx, id); err != nil {
+			return translateError(err)
+		}
+		return c.recordChange(ctx, id, storeModels.HistoryActionRestore, nil, after)
+	})
+	if after != nil && c.committed(err) {
+		c.names.Put(id, after.Name)
+	}
+	return err
+}
+
+func (c *Companies) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	purged, err := c.store.Purge(ctx, deletedBefore)
+	return purged, translateError(err)
+}
+
+func (c *Companies) History(
+	ctx context.Context,
+	id string,
+	skip,
+	limit uint64,
+) ([]*models.HistoryEntry, error) {
+	results, err := c.history.SearchHistory(ctx, id, skip, limit)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	entries := make([]*models.HistoryEntry, len(results))
+	for idx, result := range results {
+		entries[idx] = &models.HistoryEntry{
+			CompanyID:     result.CompanyID,
+			Action:        result.Action,
+			Before:        fromStoreSnapshot(result.Before),
+			After:         fromStoreSnapshot(result.After),
+			RequestID:     result.RequestID,
+			ClientIP:      result.ClientIP,
+			ClientCountry: result.ClientCountry,
+			CreatedAt:     result.CreatedAt,
+		}
+	}
+	return entries, nil
+}
+
+// write runs fn in a transaction when changes have outbox events,
+// so a change is never stored without its event or the other way.
+func (c *Companies) write(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.transactor == nil {
+		return fn(ctx)
+	}
+	return translateError(c.transactor.WithTransaction(ctx, fn))
+}
+
+// writeMany runs fn in a transaction whenever the store has them.
+func (c *Companies) writeMany(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.bulk == nil {
+		return fn(ctx)
+	}
+	return translateError(c.bulk.WithTransaction(ctx, fn))
+}
+
+// committed tells whether changes written before the write failed
+// with err are kept, they are rolled back in a transaction.
+func (c *Companies) committed(err error) bool {
+	return err == nil || c.transactor == nil
+}
+
+var eventTypes = map[string]string{
+	storeModels.HistoryActionCreate:  storeModels.EventCompanyCreated,
+	storeModels.HistoryActionUpdate:  storeModels.EventCompanyUpdated,
+	storeModels.HistoryActionDelete:  storeModels.EventCompanyDeleted,
+	storeModels.HistoryActionRestore: storeModels.EventCompanyRestored,
+}
+
+// recordChange adds the history entry of the change and its outbox
+// event, the event carries the company as it is after the change or
+// as it was before a delete.
+func (c *Companies) recordChange(
+	ctx context.Context,
+	id string,
+	action string,
+	before, after *storeModels.Company,
+) error {
+	actor := companies.ActorFromContext(ctx)
+	entry := storeModels.HistoryEntry{
+		CompanyID:     id,
+		Action:        action,
+		Before:        toStoreSnapshot(before),
+		After:         toStoreSnapshot(after),
+		RequestID:     actor.RequestID,
+		ClientIP:      actor.ClientIP,
+		ClientCountry: actor.ClientCountry,
+	}
+	if err := c.history.InsertHistory(ctx, &entry); err != nil {
+		return errors.Wrap(err, "error recording company history")
+	}
+	if c.outbox == nil {
+		return nil
+	}
+
+	event := storeModels.Event{
+		ID:        uuid.New().String(),
+		Type:      eventTypes[action],
+		CompanyID: id,
+		Company:   entry.After,
+		RequestID: actor.RequestID,
+	}
+	if event.Company == nil {
+		event.Company = entry.Before
+	}
+	return errors.Wrap(c.outbox.InsertEvent(ctx, &event), "error recording company event")
+}
+
+func toStoreSnapshot(company *storeModels.Company) *storeModels.CompanySnapshot {
+	if company == nil {
+		return nil
+	}
+	return &storeModels.CompanySnapshot{
+		Name:      company.Name,
+		Code:      company.Code,
+		Country:   company.Country,
+		Website:   company.Website,
+		Phone:     company.Phone,
+		Version:   company.Version,
+		CreatedAt: company.CreatedAt,
+	}
+}
+
+func fromSnapshotAt(id string, snapshot *storeModels.CompanySnapshot) (*models.Company, error) {
+	if snapshot == nil {
+		return nil, companies.ErrNotFound
+	}
+	return &models.Company{
+		ID:      id,
+		Name:    snapshot.Name,
+		Code:    snapshot.Code,
+		Country: snapshot.Country,
+		Website: snapshot.Website,
+		Phone:   snapshot.Phone,
+		Version: snapshot.Version,
+	}, nil
+}
+
+func fromStoreSnapshot(snapshot *storeModels.CompanySnapshot) *models.CompanySnapshot {
+	if snapshot == nil {
+		return nil
+	}
+	return &models.CompanySnapshot{
+		Name:    snapshot.Name,
+		Code:    snapshot.Code,
+		Country: snapshot.Country,
+		Website: snapshot.Website,
+		Phone:   snapshot.Phone,
+		Version: snapshot.Version,
+	}
+}
+
+func fromStoreModels(results []*storeModels.Company) []*models.Company {
+	companies := make([]*models.Company, len(results))
+	for idx, result := range results {
+		companies[idx] = fromStoreModel(result)
+	}
+	return companies
+}
+
+func fromStoreScored(results []*store.ScoredCompany) []*models.ScoredCompany {
+	scored := make([]*models.ScoredCompany, len(results))
+	for idx, result := range results {
+		scored[idx] = &models.ScoredCompany{
+			Company: *fromStoreModel(result.Company),
+			Score:   result.Score,
+		}
+	}
+	return scored
+}
+
+func fromStoreModel(company *storeModels.Company) *models.Company {
+	return &models.Company{
+		ID:        company.ID,
+		Name:      company.Name,
+		Code:      company.Code,
+		Country:   company.Country,
+		Website:   company.Website,
+		Phone:     company.Phone,
+		Version:   company.Version,
+		DeletedAt: company.DeletedAt,
+	}
+}
+
+func toStoreQuery(query companies.SearchFilters) store.SearchFilters {
+	return store.SearchFilters{
+		Name:      store.StringFilter(query.Name),
+		Code:      store.StringFilter(query.Code),
+		Country:   store.StringFilter(query.Country),
+		Website:   store.StringFilter(query.Website),
+		Phone:     store.StringFilter(query.Phone),
+		CreatedAt: store.TimeRange(query.CreatedAt),
+		UpdatedAt: store.TimeRange(query.UpdatedAt),
+		Text:      query.Text,
+	}
+}
+
+func toStoreSort(sort []companies.SortField) []store.SortField {
+	if len(sort) < 1 {
+		return nil
+	}
+	result := make([]store.SortField, len(sort))
+	for idx, field := range sort {
+		result[idx] = store.SortField(field)
+	}
+	return result
+}
+
+func translateError(err error) error {
+	switch err {
+	case store.ErrNotFound:
+		return companies.ErrNotFound
+	case store.ErrConflict:
+		return companies.ErrConflict
+	case store.ErrInvalidCursor:
+		return companies.ErrInvalidCursor
+	case store.ErrDuplicate:
+		return companies.ErrDuplicate
+	}
+	var duplicate *store.DuplicateError
+	if errors.As(err, &duplicate) {
+		return &companies.DuplicateError{ExistingID: duplicate.ExistingID}
+	}
+	var unavailable *store.UnavailableError
+	if errors.As(err, &unavailable) {
+		return &companies.UnavailableError{RetryAfter: unavailable.RetryAfter}
+	}
+	return err
 }
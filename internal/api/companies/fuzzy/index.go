@@ -0,0 +1,139 @@
+package fuzzy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type Match struct {
+	ID    string
+	Score float64
+}
+
+// Index finds names by trigram similarity, the same measure as
+// in PostgreSQL pg_trgm, so misspelled names still match.
+type Index struct {
+	mu       sync.RWMutex
+	names    map[string]map[string]struct{}
+	trigrams map[string]map[string]struct{}
+}
+
+func NewIndex() *Index {
+	return &Index{
+		names:    map[string]map[string]struct{}{},
+		trigrams: map[string]map[string]struct{}{},
+	}
+}
+
+func (i *Index) Put(id, name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(id)
+	trigrams := Trigrams(name)
+	i.names[id] = trigrams
+	for trigram := range trigrams {
+		ids, ok := i.trigrams[trigram]
+		if !ok {
+			ids = map[string]struct{}{}
+			i.trigrams[trigram] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// Replace swaps the contents of the index for those of other at once,
+// other must not be used after that.
+func (i *Index) Replace(other *Index) {
+	other.mu.RLock()
+	names, trigrams := other.names, other.trigrams
+	other.mu.RUnlock()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.names, i.trigrams = names, trigrams
+}
+
+func (i *Index) Remove(id string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(id)
+}
+
+func (i *Index) remove(id string) {
+	for trigram := range i.names[id] {
+		delete(i.trigrams[trigram], id)
+		if len(i.trigrams[trigram]) < 1 {
+			delete(i.trigrams, trigram)
+		}
+	}
+	delete(i.names, id)
+}
+
+// Search returns names at least threshold similar to the given
+// one, best match first and ids breaking ties.
+func (i *Index) Search(name string, threshold float64) []Match {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	trigrams := Trigrams(name)
+	shared := map[string]int{}
+	for trigram := range trigrams {
+		for id := range i.trigrams[trigram] {
+			shared[id]++
+		}
+	}
+
+	var matches []Match
+	for id, count := range shared {
+		score := float64(count) / float64(len(trigrams)+len(i.names[id])-count)
+		if score >= threshold {
+			matches = append(matches, Match{id, score})
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].Score != matches[b].Score {
+			return matches[a].Score > matches[b].Score
+		}
+		return matches[a].ID < matches[b].ID
+	})
+	return matches
+}
+
+// Similarity is the share of trigrams two names have in
+// common, from 0 for nothing to 1 for equal names.
+func Similarity(a, b string) float64 {
+	trigramsA, trigramsB := Trigrams(a), Trigrams(b)
+	var shared int
+	for trigram := range trigramsA {
+		if _, ok := trigramsB[trigram]; ok {
+			shared++
+		}
+	}
+	union := len(trigramsA) + len(trigramsB) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// Trigrams splits the lowercased name into words and returns three
+// letter sequences of each word padded with two spaces in front and
+// one after, so "Acme" gives "  a", " ac", "acm", "cme" and "me ".
+func Trigrams(name string) map[string]struct{} {
+	trigrams := map[string]struct{}{}
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for idx := 0; idx+3 <= len(padded); idx++ {
+			trigrams[string(padded[idx:idx+3])] = struct{}{}
+		}
+	}
+	return trigrams
+}
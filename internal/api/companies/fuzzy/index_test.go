@@ -0,0 +1,59 @@
+package fuzzy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrigrams(t *testing.T) {
+	assert.Equal(t, map[string]struct{}{
+		"  a": {},
+		" ac": {},
+		"acm": {},
+		"cme": {},
+		"me ": {},
+	}, Trigrams("ACME!"))
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity("Acme Trading", "acme trading"))
+	assert.Equal(t, 0.0, Similarity("Acme", "Zulu"))
+	assert.Greater(t, Similarity("Acme Trading", "Acme Tradng"), 0.5)
+}
+
+func TestIndexSearch(t *testing.T) {
+	index := NewIndex()
+	index.Put("1", "Acme Trading")
+	index.Put("2", "Acme Holdings")
+	index.Put("3", "Zulu")
+
+	matches := index.Search("Acme Tradng", 0.2)
+	assert.Equal(t, 2, len(matches))
+	assert.Equal(t, "1", matches[0].ID)
+	assert.Equal(t, "2", matches[1].ID)
+	assert.Greater(t, matches[0].Score, matches[1].Score)
+
+	matches = index.Search("Acme Tradng", 0.5)
+	assert.Equal(t, 1, len(matches))
+
+	index.Put("1", "Zulu Trading")
+	index.Remove("2")
+	matches = index.Search("Acme Tradng", 0.2)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "1", matches[0].ID)
+	assert.Less(t, matches[0].Score, 0.5)
+}
+
+func TestIndexReplace(t *testing.T) {
+	index := NewIndex()
+	index.Put("1", "Acme Trading")
+
+	fresh := NewIndex()
+	fresh.Put("2", "Acme Holdings")
+	index.Replace(fresh)
+
+	matches := index.Search("Acme", 0.2)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "2", matches[0].ID)
+}
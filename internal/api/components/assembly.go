@@ -2,13 +2,22 @@ package components
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
+	bbolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
 
 	"github.com/RavisMsk/xmcompanies/internal/api/api"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
+	"github.com/RavisMsk/xmcompanies/internal/api/outbox"
+	"github.com/RavisMsk/xmcompanies/internal/api/trash"
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
+	mongoCompanies "github.com/RavisMsk/xmcompanies/internal/companies/store/mongo"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/mongomigrate"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/poller"
 )
 
 type Assembly struct {
@@ -16,34 +25,123 @@ type Assembly struct {
 
 	config *Config
 	mongo  *mongo.Client
+	bolt   *bbolt.DB
+	sql    *sql.DB
 	api    *api.API
+	purger *trash.Purger
+	events *outbox.Dispatcher
+	hooks  *webhooks.Worker
+
+	migrator *mongomigrate.Migrator
+	// names is the layer under any cache, its fuzzy name
+	// index is filled on start and rebuilt periodically.
+	names        *directstore.Companies
+	namesRefresh *poller.Poller
 }
 
 func NewAssembly(
 	cfg *Config,
 	mongo *mongo.Client,
+	bolt *bbolt.DB,
+	sql *sql.DB,
 	api *api.API,
+	purger *trash.Purger,
+	events *outbox.Dispatcher,
+	hooks *webhooks.Worker,
+	migrator *mongomigrate.Migrator,
+	names *directstore.Companies,
 	log *zap.Logger,
 ) *Assembly {
-	return &Assembly{log, cfg, mongo, api}
+	a := &Assembly{log, cfg, mongo, bolt, sql, api, purger, events, hooks, migrator, names, nil}
+	// The index is filled before serving, the first poll is skipped.
+	loaded := false
+	a.namesRefresh = poller.New(cfg.GetNamesRefresh(), func() bool {
+		if !loaded {
+			loaded = true
+			return false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), loadNamesTimeout)
+		defer cancel()
+		if err := a.names.LoadNames(ctx); err != nil {
+			a.Log.Error("error rebuilding company names index", zap.Error(err))
+		}
+		return false
+	})
+	return a
 }
 
+const loadNamesTimeout = time.Minute
+
 func (a *Assembly) Run() {
 	a.Log.Info("starting up api")
 
-	a.Log.Info("connecting to mongo")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := a.mongo.Connect(ctx); err != nil {
-		a.Log.Fatal("error connecting to mongo", zap.Error(err))
+	if a.config.GetStoreDriver() == StoreDriverMongo {
+		a.Log.Info("connecting to mongo")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := a.mongo.Connect(ctx); err != nil {
+			a.Log.Fatal("error connecting to mongo", zap.Error(err))
+		}
+		if err := a.mongo.Ping(ctx, readpref.Primary()); err != nil {
+			a.Log.Fatal("error pinging mongo primary", zap.Error(err))
+		}
+	}
+
+	if a.migrator != nil && a.config.MigrateOnStart {
+		a.Log.Info("applying mongo migrations")
+		ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		defer cancel()
+		applied, err := a.migrator.Up(ctx)
+		if err != nil {
+			a.Log.Fatal("error applying mongo migrations", zap.Error(err))
+		}
+		a.Log.Info("mongo migrations applied", zap.Ints("versions", applied))
+	} else if a.migrator != nil {
+		// Indexes the stores rely on are created by migrations, so running
+		// without them would silently drop uniqueness and text search.
+		ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		defer cancel()
+		statuses, err := a.migrator.Status(ctx)
+		if err != nil {
+			a.Log.Fatal("error checking mongo migrations", zap.Error(err))
+		}
+		var pending []int
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				pending = append(pending, status.Version)
+			}
+		}
+		if len(pending) > 0 {
+			a.Log.Fatal(
+				"mongo migrations are pending, run \"apistore migrate up\" or set migrate_on_start",
+				zap.Ints("versions", pending),
+			)
+		}
+	}
+
+	if a.config.GetStoreDriver() == StoreDriverMongo && a.config.OutboxEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db := a.mongo.Database(a.config.GetMongoDatabase())
+		if err := mongoCompanies.CheckOutbox(ctx, db); err != nil {
+			a.Log.Fatal("outbox_enabled can't be used with this mongo", zap.Error(err))
+		}
 	}
-	if err := a.mongo.Ping(ctx, readpref.Primary()); err != nil {
-		a.Log.Fatal("error pinging mongo primary", zap.Error(err))
+
+	a.Log.Info("loading company names index")
+	ctx, cancel := context.WithTimeout(context.Background(), loadNamesTimeout)
+	defer cancel()
+	if err := a.names.LoadNames(ctx); err != nil {
+		a.Log.Fatal("error loading company names index", zap.Error(err))
 	}
 
 	if err := a.api.Run(); err != nil {
 		a.Log.Fatal("error starting API", zap.Error(err))
 	}
+	a.purger.Run()
+	a.events.Run()
+	a.hooks.Run()
+	a.namesRefresh.Start()
 
 	a.Log.Info("api started")
 }
@@ -51,6 +149,24 @@ func (a *Assembly) Run() {
 func (a *Assembly) Stop() error {
 	a.Log.Warn("stopping api")
 	a.api.Stop()
+	a.purger.Stop()
+	a.namesRefresh.Stop()
+	// Events of the last requests go out before the stores close.
+	a.events.Stop()
+	a.hooks.Stop()
 	a.Log.Warn("api stopped")
+
+	if a.bolt != nil {
+		if err := a.bolt.Close(); err != nil {
+			a.Log.Error("error closing bolt database", zap.Error(err))
+			return err
+		}
+	}
+	if a.sql != nil {
+		if err := a.sql.Close(); err != nil {
+			a.Log.Error("error closing sql database", zap.Error(err))
+			return err
+		}
+	}
 	return nil
 }
@@ -2,21 +2,81 @@ package components
 
 import (
 	"io/ioutil"
+	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	defaultMongoDatabase = "xm"
+	defaultMaxBatchSize  = 100
+	defaultCacheTTL      = time.Minute
+	defaultNamesRefresh  = 5 * time.Minute
+
+	defaultStoreRetryAttempts    = 3
+	defaultStoreRetryBackoff     = 50 * time.Millisecond
+	defaultStoreRetryMaxBackoff  = time.Second
+	defaultStoreBreakerThreshold = 5
+	defaultStoreBreakerOpenFor   = 30 * time.Second
+
+	defaultWebhookMaxAttempts = 8
+	defaultWebhookBackoff     = 10 * time.Second
+	defaultWebhookMaxBackoff  = time.Hour
+)
+
+const (
+	StoreDriverMongo  = "mongo"
+	StoreDriverMemory = "memory"
+	StoreDriverBolt   = "bolt"
+	StoreDriverSQL    = "sql"
+)
+
 type Config struct {
 	Debug               bool     `yaml:"debug"`
 	LogLevel            string   `yaml:"log_level"`
 	ListenAddr          string   `yaml:"listen_addr"`
 	Timeout             int      `yaml:"timeout"`
+	StoreDriver         string   `yaml:"store_driver"`
 	MongoURL            string   `yaml:"mongo_url"`
+	MongoDatabase       string   `yaml:"mongo_database"`
+	MigrateOnStart      bool     `yaml:"migrate_on_start"`
+	BoltPath            string   `yaml:"bolt_path"`
+	SQLDSN              string   `yaml:"sql_dsn"`
 	IPAPIKey            string   `yaml:"ipapi_key"`
 	ACLAllowedCountries []string `yaml:"acl_allowed_countries"`
+	TrashRetentionHours int      `yaml:"trash_retention_hours"`
+	MaxBatchSize        int      `yaml:"max_batch_size"`
+	// NamesRefreshSeconds is how often the fuzzy name index is rebuilt
+	// to take in changes written by other instances.
+	NamesRefreshSeconds int `yaml:"names_refresh_seconds"`
+	// CacheSize enables caching of companies when positive.
+	CacheSize             int `yaml:"cache_size"`
+	CacheTTLSeconds       int `yaml:"cache_ttl_seconds"`
+	CacheSearchTTLSeconds int `yaml:"cache_search_ttl_seconds"`
+	// Transient mongo store failures handling.
+	StoreRetryAttempts      int `yaml:"store_retry_attempts"`
+	StoreRetryBackoffMS     int `yaml:"store_retry_backoff_ms"`
+	StoreRetryMaxBackoffMS  int `yaml:"store_retry_max_backoff_ms"`
+	StoreBreakerThreshold   int `yaml:"store_breaker_threshold"`
+	StoreBreakerOpenSeconds int `yaml:"store_breaker_open_seconds"`
+	// OutboxEnabled records an event for every company change,
+	// mongo needs a replica set for that.
+	OutboxEnabled bool `yaml:"outbox_enabled"`
+	// Webhook deliveries retries, the deliveries are
+	// dead after the last attempt.
+	WebhookMaxAttempts       int `yaml:"webhook_max_attempts"`
+	WebhookBackoffSeconds    int `yaml:"webhook_backoff_seconds"`
+	WebhookMaxBackoffSeconds int `yaml:"webhook_max_backoff_seconds"`
+	// ConfirmSecret keys bulk operation confirmation tokens, every
+	// instance behind a load balancer needs the same one. It is read
+	// from the CONFIRM_SECRET environment variable when set there.
+	ConfirmSecret string `yaml:"confirm_secret"`
 }
 
+const confirmSecretEnv = "CONFIRM_SECRET"
+
 func ParseYAMLConfig(path string) (*Config, error) {
 	configBytes, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -26,6 +86,9 @@ func ParseYAMLConfig(path string) (*Config, error) {
 	if err = yaml.Unmarshal(configBytes, &config); err != nil {
 		return nil, err
 	}
+	if secret := os.Getenv(confirmSecretEnv); len(secret) > 0 {
+		config.ConfirmSecret = secret
+	}
 	return &config, nil
 }
 
@@ -44,3 +107,112 @@ func (c *Config) GetTimeoutDuration() time.Duration {
 func (c *Config) GetAllowedCountries() []string {
 	return c.ACLAllowedCountries
 }
+
+func (c *Config) GetMaxBatchSize() int {
+	if c.MaxBatchSize < 1 {
+		return defaultMaxBatchSize
+	}
+	return c.MaxBatchSize
+}
+
+func (c *Config) GetNamesRefresh() time.Duration {
+	if c.NamesRefreshSeconds < 1 {
+		return defaultNamesRefresh
+	}
+	return time.Duration(c.NamesRefreshSeconds) * time.Second
+}
+
+func (c *Config) GetConfirmSecret() string {
+	return c.ConfirmSecret
+}
+
+func (c *Config) GetCacheTTL() time.Duration {
+	if c.CacheTTLSeconds < 1 {
+		return defaultCacheTTL
+	}
+	return time.Duration(c.CacheTTLSeconds) * time.Second
+}
+
+// GetCacheSearchTTL is zero unless search pages should be cached.
+func (c *Config) GetCacheSearchTTL() time.Duration {
+	return time.Duration(c.CacheSearchTTLSeconds) * time.Second
+}
+
+func (c *Config) GetStoreRetryAttempts() int {
+	if c.StoreRetryAttempts < 1 {
+		return defaultStoreRetryAttempts
+	}
+	return c.StoreRetryAttempts
+}
+
+func (c *Config) GetStoreRetryBackoff() time.Duration {
+	if c.StoreRetryBackoffMS < 1 {
+		return defaultStoreRetryBackoff
+	}
+	return time.Duration(c.StoreRetryBackoffMS) * time.Millisecond
+}
+
+func (c *Config) GetStoreRetryMaxBackoff() time.Duration {
+	if c.StoreRetryMaxBackoffMS < 1 {
+		return defaultStoreRetryMaxBackoff
+	}
+	return time.Duration(c.StoreRetryMaxBackoffMS) * time.Millisecond
+}
+
+func (c *Config) GetStoreBreakerThreshold() int {
+	if c.StoreBreakerThreshold < 1 {
+		return defaultStoreBreakerThreshold
+	}
+	return c.StoreBreakerThreshold
+}
+
+func (c *Config) GetStoreBreakerOpenFor() time.Duration {
+	if c.StoreBreakerOpenSeconds < 1 {
+		return defaultStoreBreakerOpenFor
+	}
+	return time.Duration(c.StoreBreakerOpenSeconds) * time.Second
+}
+
+func (c *Config) GetWebhookMaxAttempts() int {
+	if c.WebhookMaxAttempts < 1 {
+		return defaultWebhookMaxAttempts
+	}
+	return c.WebhookMaxAttempts
+}
+
+func (c *Config) GetWebhookBackoff() time.Duration {
+	if c.WebhookBackoffSeconds < 1 {
+		return defaultWebhookBackoff
+	}
+	return time.Duration(c.WebhookBackoffSeconds) * time.Second
+}
+
+func (c *Config) GetWebhookMaxBackoff() time.Duration {
+	if c.WebhookMaxBackoffSeconds < 1 {
+		return defaultWebhookMaxBackoff
+	}
+	return time.Duration(c.WebhookMaxBackoffSeconds) * time.Second
+}
+
+func (c *Config) GetStoreDriver() string {
+	if len(c.StoreDriver) < 1 {
+		return StoreDriverMongo
+	}
+	return c.StoreDriver
+}
+
+func (c *Config) GetTrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionHours) * time.Hour
+}
+
+// GetMongoDatabase prefers explicit mongo_database, falling back to
+// the database from mongo_url path.
+func (c *Config) GetMongoDatabase() string {
+	if len(c.MongoDatabase) > 0 {
+		return c.MongoDatabase
+	}
+	if cs, err := connstring.Parse(c.MongoURL); err == nil && len(cs.Database) > 0 {
+		return cs.Database
+	}
+	return defaultMongoDatabase
+}
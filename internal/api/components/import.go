@@ -0,0 +1,125 @@
+package components
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	bbolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/importer"
+)
+
+// Import backs the "import" subcommand, it loads companies from
+// a file into the configured store without starting the API.
+type Import struct {
+	Log *zap.Logger
+
+	config    *Config
+	mongo     *mongo.Client
+	bolt      *bbolt.DB
+	sql       *sql.DB
+	companies companies.Companies
+}
+
+func NewImport(
+	cfg *Config,
+	mongo *mongo.Client,
+	bolt *bbolt.DB,
+	sql *sql.DB,
+	companies companies.Companies,
+	log *zap.Logger,
+) *Import {
+	return &Import{log, cfg, mongo, bolt, sql, companies}
+}
+
+// Run imports the file, guessing the format from its extension when
+// opts.Format is empty, and writes the report of every row to out.
+// It fails when any row wasn't imported.
+func (i *Import) Run(path string, opts importer.Options, out io.Writer) error {
+	if len(opts.Format) < 1 {
+		opts.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if opts.Format == "jsonl" {
+			opts.Format = importer.FormatNDJSON
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if i.mongo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err = i.mongo.Connect(ctx); err != nil {
+			return err
+		}
+		defer i.mongo.Disconnect(context.Background())
+	}
+	if i.bolt != nil {
+		defer i.bolt.Close()
+	}
+	if i.sql != nil {
+		defer i.sql.Close()
+	}
+
+	// Every row of the run shares a request id in company history.
+	ctx := companies.WithActor(context.Background(), companies.Actor{
+		RequestID: "import-" + uuid.New().String(),
+	})
+
+	var (
+		w        = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		total    int
+		statuses = map[string]int{}
+	)
+	fmt.Fprintln(w, "ROW\tSTATUS\tID\tERRORS")
+	err = importer.NewImporter(i.companies, i.config.GetTimeoutDuration()).Import(
+		ctx,
+		file,
+		opts,
+		func(result importer.Result) {
+			total++
+			statuses[result.Status]++
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", result.Row, result.Status, result.ID, strings.Join(result.Errors, "; "))
+		},
+	)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+
+	var summary []string
+	for _, status := range []string{
+		importer.StatusCreated,
+		importer.StatusUpdated,
+		importer.StatusValid,
+		importer.StatusInvalid,
+		importer.StatusDuplicate,
+		importer.StatusFailed,
+	} {
+		if statuses[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", statuses[status], status))
+		}
+	}
+	fmt.Fprintf(out, "%d rows: %s\n", total, strings.Join(summary, ", "))
+
+	skipped := statuses[importer.StatusInvalid] + statuses[importer.StatusDuplicate] + statuses[importer.StatusFailed]
+	if skipped > 0 {
+		return fmt.Errorf("%d of %d rows not imported", skipped, total)
+	}
+	return nil
+}
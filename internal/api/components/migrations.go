@@ -0,0 +1,91 @@
+package components
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/pkg/mongomigrate"
+)
+
+const migrateTimeout = 5 * time.Minute
+
+// Migrations backs the "migrate" subcommand, it manages mongo
+// schema without starting the API.
+type Migrations struct {
+	Log *zap.Logger
+
+	config   *Config
+	mongo    *mongo.Client
+	migrator *mongomigrate.Migrator
+}
+
+func NewMigrations(
+	cfg *Config,
+	mongo *mongo.Client,
+	migrator *mongomigrate.Migrator,
+	log *zap.Logger,
+) *Migrations {
+	return &Migrations{log, cfg, mongo, migrator}
+}
+
+func (m *Migrations) Run(action string, out io.Writer) error {
+	if m.migrator == nil {
+		return fmt.Errorf("migrations apply to %q store driver only", StoreDriverMongo)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+	if err := m.mongo.Connect(ctx); err != nil {
+		return err
+	}
+	defer m.mongo.Disconnect(context.Background())
+
+	switch action {
+	case "up":
+		applied, err := m.migrator.Up(ctx)
+		for _, version := range applied {
+			fmt.Fprintf(out, "applied %d\n", version)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) < 1 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+	case "down":
+		reverted, err := m.migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if reverted == 0 {
+			fmt.Fprintln(out, "no applied migrations")
+			return nil
+		}
+		fmt.Fprintf(out, "reverted %d\n", reverted)
+	case "status":
+		statuses, err := m.migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Description, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New("migrate action must be one of up, down, status")
+	}
+	return nil
+}
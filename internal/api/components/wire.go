@@ -4,9 +4,14 @@
 package components
 
 import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/wire"
+	bbolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -14,11 +19,20 @@ import (
 
 	"github.com/RavisMsk/xmcompanies/internal/api/api"
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/cached"
 	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
 	"github.com/RavisMsk/xmcompanies/internal/api/ipchecker"
+	"github.com/RavisMsk/xmcompanies/internal/api/outbox"
+	"github.com/RavisMsk/xmcompanies/internal/api/trash"
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
 	companiesStore "github.com/RavisMsk/xmcompanies/internal/companies/store"
+	boltCompanies "github.com/RavisMsk/xmcompanies/internal/companies/store/bolt"
+	memoryCompanies "github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
 	mongoCompanies "github.com/RavisMsk/xmcompanies/internal/companies/store/mongo"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/resilient"
+	sqlCompanies "github.com/RavisMsk/xmcompanies/internal/companies/store/sql"
 	"github.com/RavisMsk/xmcompanies/internal/pkg/ipapi"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/mongomigrate"
 )
 
 func InitializeAssembly(cfgPath string) (*Assembly, error) {
@@ -27,15 +41,58 @@ func InitializeAssembly(cfgPath string) (*Assembly, error) {
 		ParseYAMLConfig,
 		createLogger,
 		createMongoClient,
-		createMongoCompanies,
+		createMongoDatabase,
+		createMigrator,
+		createBoltDB,
+		createSQLDB,
+		createCompaniesStore,
+		createHistoryStore,
+		createOutboxStore,
+		createWebhookStore,
 		createDirectMongoLayer,
+		createCompaniesLayer,
+		createWebhooks,
 		createAPI,
 		createIPAPI,
 		createIPChecker,
+		createTrashPurger,
+		createPublisher,
+		createDispatcher,
+		createWebhookWorker,
 	)
 	return &Assembly{}, nil
 }
 
+func InitializeMigrations(cfgPath string) (*Migrations, error) {
+	wire.Build(
+		NewMigrations,
+		ParseYAMLConfig,
+		createLogger,
+		createMongoClient,
+		createMongoDatabase,
+		createMigrator,
+	)
+	return &Migrations{}, nil
+}
+
+func InitializeImport(cfgPath string) (*Import, error) {
+	wire.Build(
+		NewImport,
+		ParseYAMLConfig,
+		createLogger,
+		createMongoClient,
+		createMongoDatabase,
+		createBoltDB,
+		createSQLDB,
+		createCompaniesStore,
+		createHistoryStore,
+		createOutboxStore,
+		createDirectMongoLayer,
+		wire.Bind(new(companies.Companies), new(*directstore.Companies)),
+	)
+	return &Import{}, nil
+}
+
 func createLogger(cfg *Config) *zap.Logger {
 	lvl := zap.InfoLevel
 	switch cfg.LogLevel {
@@ -59,6 +116,9 @@ func createLogger(cfg *Config) *zap.Logger {
 }
 
 func createMongoClient(cfg *Config, logger *zap.Logger) (*mongo.Client, error) {
+	if cfg.GetStoreDriver() != StoreDriverMongo {
+		return nil, nil
+	}
 	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.MongoURL))
 	if err != nil {
 		return nil, err
@@ -66,22 +126,185 @@ func createMongoClient(cfg *Config, logger *zap.Logger) (*mongo.Client, error) {
 	return client, nil
 }
 
-func createMongoCompanies(client *mongo.Client, logger *zap.Logger) companiesStore.Store {
-	db := client.Database("xm")
-	return mongoCompanies.NewStore(db.Collection("companies"))
+func createMongoDatabase(cfg *Config, client *mongo.Client) *mongo.Database {
+	if client == nil {
+		return nil
+	}
+	return client.Database(cfg.GetMongoDatabase())
+}
+
+func createMigrator(db *mongo.Database) *mongomigrate.Migrator {
+	if db == nil {
+		return nil
+	}
+	return mongomigrate.NewMigrator(db, mongoCompanies.Migrations())
+}
+
+func createBoltDB(cfg *Config, logger *zap.Logger) (*bbolt.DB, error) {
+	if cfg.GetStoreDriver() != StoreDriverBolt {
+		return nil, nil
+	}
+	if len(cfg.BoltPath) < 1 {
+		return nil, fmt.Errorf("bolt_path is required for %q store driver", StoreDriverBolt)
+	}
+	logger.Info("opening bolt database", zap.String("path", cfg.BoltPath))
+	return bbolt.Open(cfg.BoltPath, 0600, &bbolt.Options{Timeout: 10 * time.Second})
+}
+
+func createSQLDB(cfg *Config, logger *zap.Logger) (*dbsql.DB, error) {
+	if cfg.GetStoreDriver() != StoreDriverSQL {
+		return nil, nil
+	}
+	if len(cfg.SQLDSN) < 1 {
+		return nil, fmt.Errorf("sql_dsn is required for %q store driver", StoreDriverSQL)
+	}
+	db, err := dbsql.Open(sqlCompanies.DriverName, cfg.SQLDSN)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialize access instead of
+	// failing with SQLITE_BUSY under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	logger.Info("applying sql schema migrations")
+	if err = sqlCompanies.Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func createCompaniesStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *dbsql.DB,
+	logger *zap.Logger,
+) (companiesStore.Store, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return resilient.NewStore(
+			mongoCompanies.NewStore(mongoDB.Collection(mongoCompanies.CompaniesCollection)),
+			resilient.Options{
+				Retryable:        mongoCompanies.IsRetryable,
+				Attempts:         cfg.GetStoreRetryAttempts(),
+				Backoff:          cfg.GetStoreRetryBackoff(),
+				MaxBackoff:       cfg.GetStoreRetryMaxBackoff(),
+				BreakerThreshold: cfg.GetStoreBreakerThreshold(),
+				BreakerOpenFor:   cfg.GetStoreBreakerOpenFor(),
+			},
+		), nil
+	case StoreDriverMemory:
+		logger.Warn("using in-memory companies store, data will be lost on restart")
+		return memoryCompanies.NewStore(), nil
+	case StoreDriverBolt:
+		return boltCompanies.NewStore(db)
+	case StoreDriverSQL:
+		return sqlCompanies.NewStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+func createHistoryStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *dbsql.DB,
+) (companiesStore.HistoryStore, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongoCompanies.NewHistoryStore(mongoDB.Collection(mongoCompanies.HistoryCollection)), nil
+	case StoreDriverMemory:
+		return memoryCompanies.NewHistoryStore(), nil
+	case StoreDriverBolt:
+		return boltCompanies.NewHistoryStore(db)
+	case StoreDriverSQL:
+		return sqlCompanies.NewHistoryStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+// createOutboxStore is nil unless company events are enabled.
+func createOutboxStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *dbsql.DB,
+) (companiesStore.OutboxStore, error) {
+	if !cfg.OutboxEnabled {
+		return nil, nil
+	}
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongoCompanies.NewOutboxStore(mongoDB.Collection(mongoCompanies.OutboxCollection)), nil
+	case StoreDriverMemory:
+		return memoryCompanies.NewOutboxStore(), nil
+	case StoreDriverBolt:
+		return boltCompanies.NewOutboxStore(db)
+	case StoreDriverSQL:
+		return sqlCompanies.NewOutboxStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+func createWebhookStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *dbsql.DB,
+) (companiesStore.WebhookStore, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongoCompanies.NewWebhookStore(mongoDB), nil
+	case StoreDriverMemory:
+		return memoryCompanies.NewWebhookStore(), nil
+	case StoreDriverBolt:
+		return boltCompanies.NewWebhookStore(db)
+	case StoreDriverSQL:
+		return sqlCompanies.NewWebhookStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+func createDirectMongoLayer(
+	store companiesStore.Store,
+	history companiesStore.HistoryStore,
+	outbox companiesStore.OutboxStore,
+) *directstore.Companies {
+	return directstore.NewDirectStoreCompanies(store, history, outbox)
 }
 
-func createDirectMongoLayer(store companiesStore.Store) companies.Companies {
-	return directstore.NewDirectStoreCompanies(store)
+func createCompaniesLayer(cfg *Config, direct *directstore.Companies, logger *zap.Logger) companies.Companies {
+	if cfg.CacheSize < 1 {
+		return direct
+	}
+	logger.Info(
+		"caching companies",
+		zap.Int("size", cfg.CacheSize),
+		zap.Duration("ttl", cfg.GetCacheTTL()),
+		zap.Duration("searchTTL", cfg.GetCacheSearchTTL()),
+	)
+	return cached.NewCachedCompanies(direct, cached.Options{
+		Size:      cfg.CacheSize,
+		TTL:       cfg.GetCacheTTL(),
+		SearchTTL: cfg.GetCacheSearchTTL(),
+	})
+}
+
+func createWebhooks(store companiesStore.WebhookStore) webhooks.Webhooks {
+	return webhooks.NewStoreWebhooks(store)
 }
 
 func createAPI(
 	cfg *Config,
 	companies companies.Companies,
+	webhooks webhooks.Webhooks,
 	ipChecker ipchecker.Checker,
 	logger *zap.Logger,
 ) *api.API {
-	return api.NewAPI(cfg, companies, ipChecker, logger.Named("api"))
+	return api.NewAPI(cfg, companies, webhooks, ipChecker, logger.Named("api"))
 }
 
 func createIPAPI(cfg *Config) *ipapi.Client {
@@ -91,3 +314,36 @@ func createIPAPI(cfg *Config) *ipapi.Client {
 func createIPChecker(client *ipapi.Client) ipchecker.Checker {
 	return ipchecker.NewIPAPIChecker(client)
 }
+
+func createTrashPurger(
+	cfg *Config,
+	companies companies.Companies,
+	logger *zap.Logger,
+) *trash.Purger {
+	return trash.NewPurger(companies, cfg.GetTrashRetention(), logger.Named("trash"))
+}
+
+func createPublisher(store companiesStore.WebhookStore) outbox.Publisher {
+	return webhooks.NewPublisher(store)
+}
+
+func createDispatcher(
+	store companiesStore.OutboxStore,
+	publisher outbox.Publisher,
+	logger *zap.Logger,
+) *outbox.Dispatcher {
+	return outbox.NewDispatcher(store, publisher, logger.Named("outbox"))
+}
+
+func createWebhookWorker(
+	cfg *Config,
+	store companiesStore.WebhookStore,
+	logger *zap.Logger,
+) *webhooks.Worker {
+	return webhooks.NewWorker(store, webhooks.WorkerOptions{
+		MaxAttempts:   cfg.GetWebhookMaxAttempts(),
+		Backoff:       cfg.GetWebhookBackoff(),
+		MaxBackoff:    cfg.GetWebhookMaxBackoff(),
+		EventsEnabled: cfg.OutboxEnabled,
+	}, logger.Named("webhooks"))
+}
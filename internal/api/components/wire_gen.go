@@ -6,18 +6,32 @@
 package components
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/RavisMsk/xmcompanies/internal/api/api"
 	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/cached"
 	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
 	"github.com/RavisMsk/xmcompanies/internal/api/ipchecker"
+	"github.com/RavisMsk/xmcompanies/internal/api/outbox"
+	"github.com/RavisMsk/xmcompanies/internal/api/trash"
+	"github.com/RavisMsk/xmcompanies/internal/api/webhooks"
 	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/bolt"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
 	mongo2 "github.com/RavisMsk/xmcompanies/internal/companies/store/mongo"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/resilient"
+	sql2 "github.com/RavisMsk/xmcompanies/internal/companies/store/sql"
 	"github.com/RavisMsk/xmcompanies/internal/pkg/ipapi"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/mongomigrate"
+	"go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"net/http"
+	"time"
 )
 
 // Injectors from wire.go:
@@ -32,15 +46,98 @@ func InitializeAssembly(cfgPath string) (*Assembly, error) {
 	if err != nil {
 		return nil, err
 	}
-	store := createMongoCompanies(client, logger)
-	companies := createDirectMongoLayer(store)
+	db, err := createBoltDB(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := createSQLDB(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	database := createMongoDatabase(config, client)
+	store, err := createCompaniesStore(config, database, db, sqlDB, logger)
+	if err != nil {
+		return nil, err
+	}
+	historyStore, err := createHistoryStore(config, database, db, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	outboxStore, err := createOutboxStore(config, database, db, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	companies := createDirectMongoLayer(store, historyStore, outboxStore)
+	companiesCompanies := createCompaniesLayer(config, companies, logger)
+	webhookStore, err := createWebhookStore(config, database, db, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := createWebhooks(webhookStore)
 	ipapiClient := createIPAPI(config)
 	checker := createIPChecker(ipapiClient)
-	api := createAPI(config, companies, checker, logger)
-	assembly := NewAssembly(config, client, api, logger)
+	api := createAPI(config, companiesCompanies, webhooks, checker, logger)
+	purger := createTrashPurger(config, companiesCompanies, logger)
+	publisher := createPublisher(webhookStore)
+	dispatcher := createDispatcher(outboxStore, publisher, logger)
+	worker := createWebhookWorker(config, webhookStore, logger)
+	migrator := createMigrator(database)
+	assembly := NewAssembly(config, client, db, sqlDB, api, purger, dispatcher, worker, migrator, companies, logger)
 	return assembly, nil
 }
 
+func InitializeMigrations(cfgPath string) (*Migrations, error) {
+	config, err := ParseYAMLConfig(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	logger := createLogger(config)
+	client, err := createMongoClient(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	database := createMongoDatabase(config, client)
+	migrator := createMigrator(database)
+	migrations := NewMigrations(config, client, migrator, logger)
+	return migrations, nil
+}
+
+func InitializeImport(cfgPath string) (*Import, error) {
+	config, err := ParseYAMLConfig(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	logger := createLogger(config)
+	client, err := createMongoClient(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	db, err := createBoltDB(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := createSQLDB(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	database := createMongoDatabase(config, client)
+	store, err := createCompaniesStore(config, database, db, sqlDB, logger)
+	if err != nil {
+		return nil, err
+	}
+	historyStore, err := createHistoryStore(config, database, db, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	outboxStore, err := createOutboxStore(config, database, db, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	companies := createDirectMongoLayer(store, historyStore, outboxStore)
+	componentsImport := NewImport(config, client, db, sqlDB, companies, logger)
+	return componentsImport, nil
+}
+
 // wire.go:
 
 func createLogger(cfg *Config) *zap.Logger {
@@ -66,6 +163,9 @@ func createLogger(cfg *Config) *zap.Logger {
 }
 
 func createMongoClient(cfg *Config, logger *zap.Logger) (*mongo.Client, error) {
+	if cfg.GetStoreDriver() != StoreDriverMongo {
+		return nil, nil
+	}
 	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.MongoURL))
 	if err != nil {
 		return nil, err
@@ -73,22 +173,178 @@ func createMongoClient(cfg *Config, logger *zap.Logger) (*mongo.Client, error) {
 	return client, nil
 }
 
-func createMongoCompanies(client *mongo.Client, logger *zap.Logger) store.Store {
-	db := client.Database("xm")
-	return mongo2.NewStore(db.Collection("companies"))
+func createMongoDatabase(cfg *Config, client *mongo.Client) *mongo.Database {
+	if client == nil {
+		return nil
+	}
+	return client.Database(cfg.GetMongoDatabase())
+}
+
+func createMigrator(db *mongo.Database) *mongomigrate.Migrator {
+	if db == nil {
+		return nil
+	}
+	return mongomigrate.NewMigrator(db, mongo2.Migrations())
+}
+
+func createBoltDB(cfg *Config, logger *zap.Logger) (*bbolt.DB, error) {
+	if cfg.GetStoreDriver() != StoreDriverBolt {
+		return nil, nil
+	}
+	if len(cfg.BoltPath) < 1 {
+		return nil, fmt.Errorf("bolt_path is required for %q store driver", StoreDriverBolt)
+	}
+	logger.Info("opening bolt database", zap.String("path", cfg.BoltPath))
+	return bbolt.Open(cfg.BoltPath, 0600, &bbolt.Options{Timeout: 10 * time.Second})
+}
+
+func createSQLDB(cfg *Config, logger *zap.Logger) (*sql.DB, error) {
+	if cfg.GetStoreDriver() != StoreDriverSQL {
+		return nil, nil
+	}
+	if len(cfg.SQLDSN) < 1 {
+		return nil, fmt.Errorf("sql_dsn is required for %q store driver", StoreDriverSQL)
+	}
+	db, err := sql.Open(sql2.DriverName, cfg.SQLDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	logger.Info("applying sql schema migrations")
+	if err = sql2.Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func createCompaniesStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *sql.DB,
+	logger *zap.Logger,
+) (store.Store, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return resilient.NewStore(mongo2.NewStore(mongoDB.Collection(mongo2.CompaniesCollection)), resilient.Options{
+			Retryable:        mongo2.IsRetryable,
+			Attempts:         cfg.GetStoreRetryAttempts(),
+			Backoff:          cfg.GetStoreRetryBackoff(),
+			MaxBackoff:       cfg.GetStoreRetryMaxBackoff(),
+			BreakerThreshold: cfg.GetStoreBreakerThreshold(),
+			BreakerOpenFor:   cfg.GetStoreBreakerOpenFor(),
+		},
+		), nil
+	case StoreDriverMemory:
+		logger.Warn("using in-memory companies store, data will be lost on restart")
+		return memory.NewStore(), nil
+	case StoreDriverBolt:
+		return bolt.NewStore(db)
+	case StoreDriverSQL:
+		return sql2.NewStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
 }
 
-func createDirectMongoLayer(store2 store.Store) companies.Companies {
-	return directstore.NewDirectStoreCompanies(store2)
+func createHistoryStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *sql.DB,
+) (store.HistoryStore, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongo2.NewHistoryStore(mongoDB.Collection(mongo2.HistoryCollection)), nil
+	case StoreDriverMemory:
+		return memory.NewHistoryStore(), nil
+	case StoreDriverBolt:
+		return bolt.NewHistoryStore(db)
+	case StoreDriverSQL:
+		return sql2.NewHistoryStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+// createOutboxStore is nil unless company events are enabled.
+func createOutboxStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *sql.DB,
+) (store.OutboxStore, error) {
+	if !cfg.OutboxEnabled {
+		return nil, nil
+	}
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongo2.NewOutboxStore(mongoDB.Collection(mongo2.OutboxCollection)), nil
+	case StoreDriverMemory:
+		return memory.NewOutboxStore(), nil
+	case StoreDriverBolt:
+		return bolt.NewOutboxStore(db)
+	case StoreDriverSQL:
+		return sql2.NewOutboxStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+func createWebhookStore(
+	cfg *Config,
+	mongoDB *mongo.Database,
+	db *bbolt.DB,
+	sqlDB *sql.DB,
+) (store.WebhookStore, error) {
+	switch cfg.GetStoreDriver() {
+	case StoreDriverMongo:
+		return mongo2.NewWebhookStore(mongoDB), nil
+	case StoreDriverMemory:
+		return memory.NewWebhookStore(), nil
+	case StoreDriverBolt:
+		return bolt.NewWebhookStore(db)
+	case StoreDriverSQL:
+		return sql2.NewWebhookStore(sqlDB), nil
+	}
+	return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+}
+
+func createDirectMongoLayer(store2 store.Store,
+
+	history store.HistoryStore,
+	outbox store.OutboxStore,
+) *directstore.Companies {
+	return directstore.NewDirectStoreCompanies(store2, history, outbox)
+}
+
+func createCompaniesLayer(cfg *Config, direct *directstore.Companies, logger *zap.Logger) companies.Companies {
+	if cfg.CacheSize < 1 {
+		return direct
+	}
+	logger.Info(
+		"caching companies", zap.Int("size", cfg.CacheSize), zap.Duration("ttl", cfg.GetCacheTTL()), zap.Duration("searchTTL", cfg.GetCacheSearchTTL()),
+	)
+	return cached.NewCachedCompanies(direct, cached.Options{
+		Size:      cfg.CacheSize,
+		TTL:       cfg.GetCacheTTL(),
+		SearchTTL: cfg.GetCacheSearchTTL(),
+	})
+}
+
+func createWebhooks(store2 store.WebhookStore) webhooks.Webhooks {
+	return webhooks.NewStoreWebhooks(store2)
 }
 
 func createAPI(
-	cfg *Config, companies2 companies.Companies,
+	cfg *Config, companies2 companies.Companies, webhooks2 webhooks.Webhooks,
 
 	ipChecker ipchecker.Checker,
 	logger *zap.Logger,
 ) *api.API {
-	return api.NewAPI(cfg, companies2, ipChecker, logger.Named("api"))
+	return api.NewAPI(cfg, companies2, webhooks2, ipChecker, logger.Named("api"))
 }
 
 func createIPAPI(cfg *Config) *ipapi.Client {
@@ -98,3 +354,36 @@ func createIPAPI(cfg *Config) *ipapi.Client {
 func createIPChecker(client *ipapi.Client) ipchecker.Checker {
 	return ipchecker.NewIPAPIChecker(client)
 }
+
+func createTrashPurger(
+	cfg *Config, companies2 companies.Companies,
+
+	logger *zap.Logger,
+) *trash.Purger {
+	return trash.NewPurger(companies2, cfg.GetTrashRetention(), logger.Named("trash"))
+}
+
+func createPublisher(store2 store.WebhookStore) outbox.Publisher {
+	return webhooks.NewPublisher(store2)
+}
+
+func createDispatcher(store2 store.OutboxStore,
+
+	publisher outbox.Publisher,
+	logger *zap.Logger,
+) *outbox.Dispatcher {
+	return outbox.NewDispatcher(store2, publisher, logger.Named("outbox"))
+}
+
+func createWebhookWorker(
+	cfg *Config, store2 store.WebhookStore,
+
+	logger *zap.Logger,
+) *webhooks.Worker {
+	return webhooks.NewWorker(store2, webhooks.WorkerOptions{
+		MaxAttempts:   cfg.GetWebhookMaxAttempts(),
+		Backoff:       cfg.GetWebhookBackoff(),
+		MaxBackoff:    cfg.GetWebhookMaxBackoff(),
+		EventsEnabled: cfg.OutboxEnabled,
+	}, logger.Named("webhooks"))
+}
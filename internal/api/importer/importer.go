@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/validate"
+)
+
+const (
+	StatusCreated   = "created"
+	StatusUpdated   = "updated"
+	StatusValid     = "valid"
+	StatusInvalid   = "invalid"
+	StatusDuplicate = "duplicate"
+	StatusFailed    = "failed"
+)
+
+type Options struct {
+	Format string
+	// Upsert updates the company having the same code in the
+	// country instead of reporting the row as a duplicate.
+	Upsert bool
+	// DryRun validates rows without writing them.
+	DryRun bool
+}
+
+// Result is the outcome of a row, Row is the file line it starts at.
+type Result struct {
+	Row    int
+	Status string
+	ID     string
+	Errors []string
+}
+
+// Imported tells whether the row was written or would be in a dry run.
+func (r Result) Imported() bool {
+	return r.Status == StatusCreated || r.Status == StatusUpdated || r.Status == StatusValid
+}
+
+// Importer writes companies from files through the companies layer,
+// with the same validation as the HTTP API.
+type Importer struct {
+	companies companies.Companies
+	// timeout limits writing a single row.
+	timeout time.Duration
+}
+
+func NewImporter(companies companies.Companies, timeout time.Duration) *Importer {
+	return &Importer{companies, timeout}
+}
+
+// Import reads the companies and calls report with the outcome of every
+// row in file order. Unreadable rows are reported and skipped, errors
+// reading the file itself stop the import.
+func (i *Importer) Import(ctx context.Context, r io.Reader, opts Options, report func(Result)) error {
+	rows, err := newReader(r, opts.Format)
+	if err != nil {
+		return err
+	}
+	for {
+		row, fields, err := rows.Next()
+		var invalid *rowError
+		if err == io.EOF {
+			return nil
+		} else if errors.As(err, &invalid) {
+			report(Result{Row: row, Status: StatusInvalid, Errors: []string{invalid.Error()}})
+			continue
+		} else if err != nil {
+			return err
+		}
+		report(i.importRow(ctx, row, fields, opts))
+	}
+}
+
+func (i *Importer) importRow(
+	ctx context.Context,
+	row int,
+	fields companies.CompanyFields,
+	opts Options,
+) Result {
+	fields, errs := validate.CompanyFields(fields)
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for idx, err := range errs {
+			messages[idx] = err.Error()
+		}
+		return Result{Row: row, Status: StatusInvalid, Errors: messages}
+	}
+	if opts.DryRun {
+		return Result{Row: row, Status: StatusValid}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.timeout)
+	defer cancel()
+
+	id, err := i.companies.Create(ctx, fields)
+	var duplicate *companies.DuplicateError
+	if errors.As(err, &duplicate) {
+		if !opts.Upsert {
+			return Result{
+				Row:    row,
+				Status: StatusDuplicate,
+				ID:     duplicate.ExistingID,
+				Errors: []string{"company code already exists in country"},
+			}
+		}
+		return i.updateRow(ctx, row, duplicate.ExistingID, fields)
+	} else if err != nil && id == "" {
+		return Result{Row: row, Status: StatusFailed, Errors: []string{err.Error()}}
+	} else if err != nil {
+		return Result{Row: row, Status: StatusCreated, ID: id, Errors: []string{err.Error()}}
+	}
+	return Result{Row: row, Status: StatusCreated, ID: id}
+}
+
+func (i *Importer) updateRow(ctx context.Context, row int, id string, fields companies.CompanyFields) Result {
+	// Code and country are what matched, the rest is replaced.
+	err := i.companies.Update(ctx, id, companies.AnyVersion, companies.UpdateFields{
+		Name:    &fields.Name,
+		Website: &fields.Website,
+		Phone:   &fields.Phone,
+	})
+	if err == companies.ErrNotFound {
+		return Result{
+			Row:    row,
+			Status: StatusDuplicate,
+			ID:     id,
+			Errors: []string{"company with the code in country is in trash"},
+		}
+	} else if err != nil {
+		return Result{Row: row, Status: StatusFailed, ID: id, Errors: []string{err.Error()}}
+	}
+	return Result{Row: row, Status: StatusUpdated, ID: id}
+}
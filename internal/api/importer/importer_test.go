@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
+)
+
+func createTestImporter() (*Importer, companies.Companies) {
+	layer := directstore.NewDirectStoreCompanies(memory.NewStore(), memory.NewHistoryStore(), nil)
+	return NewImporter(layer, time.Second), layer
+}
+
+func importString(t *testing.T, importer *Importer, data string, opts Options) []Result {
+	var results []Result
+	err := importer.Import(context.Background(), strings.NewReader(data), opts, func(result Result) {
+		results = append(results, result)
+	})
+	assert.NoError(t, err)
+	return results
+}
+
+func TestImportCSV(t *testing.T) {
+	importer, layer := createTestImporter()
+
+	results := importString(t, importer, strings.Join([]string{
+		"Code,Name,Country,Website,Phone",
+		"AC,Acme Trading,Cyprus,https://acme.com,",
+		"ZU,X,Cyprus,https://zulu.com,",
+		"AC,Acme Holdings,Cyprus,https://acme.com,",
+		"TOO,Few,Columns",
+		`BE,"Beta",Greece,https://beta.com,+302100000000`,
+	}, "\n"), Options{Format: FormatCSV})
+
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, 2, results[0].Row)
+	assert.Equal(t, StatusCreated, results[0].Status)
+	assert.Equal(t, StatusInvalid, results[1].Status)
+	assert.Equal(t, 1, len(results[1].Errors))
+	assert.Equal(t, StatusDuplicate, results[2].Status)
+	assert.Equal(t, results[0].ID, results[2].ID)
+	assert.Equal(t, 5, results[3].Row)
+	assert.Equal(t, StatusInvalid, results[3].Status)
+	assert.Equal(t, 6, results[4].Row)
+	assert.Equal(t, StatusCreated, results[4].Status)
+
+	company, err := layer.Get(context.Background(), results[4].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Beta", company.Name)
+	assert.Equal(t, "+302100000000", company.Phone)
+}
+
+func TestImportCSVHeader(t *testing.T) {
+	importer, _ := createTestImporter()
+
+	for _, data := range []string{"", "name,code,vat\n", "name,name\n"} {
+		err := importer.Import(context.Background(), strings.NewReader(data), Options{Format: FormatCSV}, func(Result) {})
+		assert.Error(t, err, data)
+	}
+}
+
+func TestImportNDJSONUpsert(t *testing.T) {
+	importer, layer := createTestImporter()
+
+	results := importString(t, importer, strings.Join([]string{
+		`{"name":"Acme Trading","code":"AC","country":"Cyprus","website":"https://acme.com"}`,
+		``,
+		`{"name":"Acme Holdings","code":"AC","country":"Cyprus","website":"https://acme.org"}`,
+		`{"name":"Acme Holdings","code":"AC","country":"Cyprus","vat":"CY1"}`,
+		`not json`,
+	}, "\n"), Options{Format: FormatNDJSON, Upsert: true})
+
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, StatusCreated, results[0].Status)
+	assert.Equal(t, 3, results[1].Row)
+	assert.Equal(t, StatusUpdated, results[1].Status)
+	assert.Equal(t, results[0].ID, results[1].ID)
+	assert.Equal(t, StatusInvalid, results[2].Status)
+	assert.Equal(t, 5, results[3].Row)
+	assert.Equal(t, StatusInvalid, results[3].Status)
+
+	company, err := layer.Get(context.Background(), results[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Acme Holdings", company.Name)
+	assert.Equal(t, "https://acme.org", company.Website)
+	assert.Equal(t, uint64(2), company.Version)
+}
+
+func TestImportDryRun(t *testing.T) {
+	importer, layer := createTestImporter()
+
+	results := importString(t, importer, strings.Join([]string{
+		`{"name":"Acme Trading","code":"AC","country":"Cyprus","website":"https://acme.com"}`,
+		`{"name":"Acme Trading","code":"ac","country":"Cyprus","website":"https://acme.com"}`,
+	}, "\n"), Options{Format: FormatNDJSON, DryRun: true})
+
+	assert.Equal(t, StatusValid, results[0].Status)
+	assert.True(t, results[0].Imported())
+	assert.Equal(t, StatusInvalid, results[1].Status)
+	assert.False(t, results[1].Imported())
+
+	count, err := layer.Count(context.Background(), companies.SearchFilters{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
@@ -0,0 +1,145 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// rowError is a record that couldn't be read, the
+// import goes on with the next one.
+type rowError struct {
+	row int
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// reader returns companies with the file line they start at
+// and io.EOF after the last one.
+type reader interface {
+	Next() (int, companies.CompanyFields, error)
+}
+
+func newReader(r io.Reader, format string) (reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return newNDJSONReader(r), nil
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// csvColumns are the accepted header names,
+// columns may come in any order.
+var csvColumns = map[string]func(*companies.CompanyFields) *string{
+	"name":    func(f *companies.CompanyFields) *string { return &f.Name },
+	"code":    func(f *companies.CompanyFields) *string { return &f.Code },
+	"country": func(f *companies.CompanyFields) *string { return &f.Country },
+	"website": func(f *companies.CompanyFields) *string { return &f.Website },
+	"phone":   func(f *companies.CompanyFields) *string { return &f.Phone },
+}
+
+type csvReader struct {
+	csv    *csv.Reader
+	fields []func(*companies.CompanyFields) *string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := &csvReader{csv: csv.NewReader(r)}
+	reader.csv.TrimLeadingSpace = true
+
+	header, err := reader.csv.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv header is missing")
+	} else if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		field, ok := csvColumns[column]
+		if !ok {
+			return nil, fmt.Errorf("unknown csv column %q", column)
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("duplicate csv column %q", column)
+		}
+		seen[column] = true
+		reader.fields = append(reader.fields, field)
+	}
+	return reader, nil
+}
+
+func (r *csvReader) Next() (int, companies.CompanyFields, error) {
+	var fields companies.CompanyFields
+	record, err := r.csv.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, fields, &rowError{parseErr.StartLine, parseErr.Err}
+	} else if err != nil {
+		return 0, fields, err
+	}
+
+	row, _ := r.csv.FieldPos(0)
+	for idx, value := range record {
+		*r.fields[idx](&fields) = value
+	}
+	return row, fields, nil
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	return &ndjsonReader{scanner: scanner}
+}
+
+type ndjsonCompany struct {
+	Name    string `json:"name"`
+	Code    string `json:"code"`
+	Country string `json:"country"`
+	Website string `json:"website"`
+	Phone   string `json:"phone"`
+}
+
+func (r *ndjsonReader) Next() (int, companies.CompanyFields, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) < 1 {
+			continue
+		}
+
+		var company ndjsonCompany
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&company); err != nil {
+			return r.line, companies.CompanyFields{}, &rowError{r.line, err}
+		}
+		return r.line, companies.CompanyFields(company), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return 0, companies.CompanyFields{}, err
+	}
+	return 0, companies.CompanyFields{}, io.EOF
+}
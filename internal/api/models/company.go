@@ -1,10 +1,21 @@
 package models
 
+import "time"
+
 type Company struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Code    string `json:"code"`
-	Country string `json:"country"`
-	Website string `json:"website"`
-	Phone   string `json:"phone"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Code      string     `json:"code"`
+	Country   string     `json:"country"`
+	Website   string     `json:"website"`
+	Phone     string     `json:"phone"`
+	Version   uint64     `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ScoredCompany is a full text search result, higher
+// score means more relevant.
+type ScoredCompany struct {
+	Company
+	Score float64 `json:"score"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type Event struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	CompanyID string           `json:"company_id"`
+	Company   *CompanySnapshot `json:"company"`
+	RequestID string           `json:"request_id"`
+	CreatedAt time.Time        `json:"created_at"`
+}
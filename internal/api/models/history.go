@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type CompanySnapshot struct {
+	Name    string `json:"name"`
+	Code    string `json:"code"`
+	Country string `json:"country"`
+	Website string `json:"website"`
+	Phone   string `json:"phone"`
+	Version uint64 `json:"version"`
+}
+
+type HistoryEntry struct {
+	CompanyID     string           `json:"company_id"`
+	Action        string           `json:"action"`
+	Before        *CompanySnapshot `json:"before"`
+	After         *CompanySnapshot `json:"after"`
+	RequestID     string           `json:"request_id"`
+	ClientIP      string           `json:"client_ip"`
+	ClientCountry string           `json:"client_country"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
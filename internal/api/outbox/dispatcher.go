@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	apiModels "github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/poller"
+)
+
+const (
+	dispatchInterval = time.Second
+	dispatchBatch    = 100
+	dispatchTimeout  = time.Minute
+)
+
+// Publisher delivers company events to whoever listens to them,
+// an event is retried until Publish returns no error.
+type Publisher interface {
+	Publish(ctx context.Context, event *apiModels.Event) error
+}
+
+// Dispatcher publishes pending outbox events in the order they
+// were written and marks them delivered. Events are delivered at
+// least once, a crash between publishing and marking repeats one.
+type Dispatcher struct {
+	outbox    store.OutboxStore
+	publisher Publisher
+	log       *zap.Logger
+	poller    *poller.Poller
+}
+
+// NewDispatcher does nothing on Run when outbox is nil.
+func NewDispatcher(outbox store.OutboxStore, publisher Publisher, log *zap.Logger) *Dispatcher {
+	d := &Dispatcher{
+		outbox:    outbox,
+		publisher: publisher,
+		log:       log,
+	}
+	d.poller = poller.New(dispatchInterval, func() bool {
+		// A full batch means there is more waiting.
+		return d.dispatch() == dispatchBatch
+	})
+	return d
+}
+
+func (d *Dispatcher) Run() {
+	if d.outbox == nil {
+		d.log.Info("company events disabled")
+		return
+	}
+	d.poller.Start()
+}
+
+func (d *Dispatcher) Stop() {
+	d.poller.Stop()
+}
+
+// dispatch returns the number of events delivered. It stops at the
+// first failed event so later ones never overtake it.
+func (d *Dispatcher) dispatch() int {
+	ctx, cancel := context.WithTimeout(context.Background(), dispatchTimeout)
+	defer cancel()
+
+	events, err := d.outbox.PendingEvents(ctx, dispatchBatch)
+	if err != nil {
+		d.log.Error("error loading pending events", zap.Error(err))
+		return 0
+	}
+	for idx, event := range events {
+		if err = d.publisher.Publish(ctx, toAPIEvent(event)); err != nil {
+			d.log.Error("error publishing event", zap.String("id", event.ID), zap.Error(err))
+			return idx
+		}
+		if err = d.outbox.MarkDelivered(ctx, event.ID); err != nil {
+			d.log.Error("error marking event delivered", zap.String("id", event.ID), zap.Error(err))
+			return idx
+		}
+	}
+	return len(events)
+}
+
+func toAPIEvent(event *models.Event) *apiModels.Event {
+	apiEvent := apiModels.Event{
+		ID:        event.ID,
+		Type:      event.Type,
+		CompanyID: event.CompanyID,
+		RequestID: event.RequestID,
+		CreatedAt: event.CreatedAt,
+	}
+	if event.Company != nil {
+		apiEvent.Company = &apiModels.CompanySnapshot{
+			Name:    event.Company.Name,
+			Code:    event.Company.Code,
+			Country: event.Company.Country,
+			Website: event.Company.Website,
+			Phone:   event.Company.Phone,
+			Version: event.Company.Version,
+		}
+	}
+	return &apiEvent
+}
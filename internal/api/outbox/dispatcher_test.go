@@ -0,0 +1,65 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/api/companies/directstore"
+	apiModels "github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
+)
+
+type recordingPublisher struct {
+	events []*apiModels.Event
+	failOn string
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event *apiModels.Event) error {
+	if event.Type == p.failOn {
+		return errors.New("publish failed")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestDispatchCompanyEvents(t *testing.T) {
+	events := memory.NewOutboxStore()
+	layer := directstore.NewDirectStoreCompanies(memory.NewStore(), memory.NewHistoryStore(), events)
+	ctx := companies.WithActor(context.Background(), companies.Actor{RequestID: "req"})
+
+	id, err := layer.Create(ctx, companies.CompanyFields{Name: "First", Code: "FC", Country: "Cyprus"})
+	assert.NoError(t, err)
+	name := "Renamed"
+	assert.NoError(t, layer.Update(ctx, id, 1, companies.UpdateFields{Name: &name}))
+	assert.NoError(t, layer.Delete(ctx, id, 2))
+
+	publisher := &recordingPublisher{failOn: "company.deleted"}
+	dispatcher := NewDispatcher(events, publisher, zap.NewNop())
+	assert.Equal(t, 2, dispatcher.dispatch())
+	assert.Equal(t, 2, len(publisher.events))
+	assert.Equal(t, "company.created", publisher.events[0].Type)
+	assert.Equal(t, id, publisher.events[0].CompanyID)
+	assert.Equal(t, "First", publisher.events[0].Company.Name)
+	assert.Equal(t, "req", publisher.events[0].RequestID)
+	assert.Equal(t, "company.updated", publisher.events[1].Type)
+	assert.Equal(t, "Renamed", publisher.events[1].Company.Name)
+
+	// The failed event stays pending and goes out once publishing works.
+	publisher.failOn = ""
+	assert.Equal(t, 1, dispatcher.dispatch())
+	assert.Equal(t, 3, len(publisher.events))
+	assert.Equal(t, "company.deleted", publisher.events[2].Type)
+	assert.Equal(t, "Renamed", publisher.events[2].Company.Name)
+	assert.Equal(t, 0, dispatcher.dispatch())
+}
+
+func TestDispatcherDisabled(t *testing.T) {
+	dispatcher := NewDispatcher(nil, &recordingPublisher{}, zap.NewNop())
+	dispatcher.Run()
+	dispatcher.Stop()
+}
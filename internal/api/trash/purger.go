@@ -0,0 +1,71 @@
+package trash
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/poller"
+)
+
+const (
+	purgeInterval = time.Hour
+	purgeTimeout  = time.Minute
+)
+
+// Purger periodically removes companies that stayed in trash
+// longer than the retention period.
+type Purger struct {
+	companies companies.Companies
+	retention time.Duration
+	log       *zap.Logger
+	poller    *poller.Poller
+}
+
+func NewPurger(
+	companies companies.Companies,
+	retention time.Duration,
+	log *zap.Logger,
+) *Purger {
+	p := &Purger{
+		companies: companies,
+		retention: retention,
+		log:       log,
+	}
+	p.poller = poller.New(purgeInterval, func() bool {
+		p.purge()
+		return false
+	})
+	return p
+}
+
+func (p *Purger) Run() {
+	if p.retention <= 0 {
+		p.log.Info("trash purging disabled")
+		return
+	}
+	p.poller.Start()
+}
+
+func (p *Purger) Stop() {
+	p.poller.Stop()
+}
+
+func (p *Purger) purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	deletedBefore := time.Now().Add(-p.retention)
+	purged, err := p.companies.Purge(ctx, deletedBefore)
+	if err != nil {
+		p.log.Error("error purging trash", zap.Error(err))
+		return
+	}
+	p.log.Info(
+		"purged trash",
+		zap.Uint64("purged", purged),
+		zap.Time("deletedBefore", deletedBefore),
+	)
+}
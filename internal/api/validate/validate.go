@@ -0,0 +1,100 @@
+// Package validate holds the company field rules shared by
+// the HTTP handlers and the import command.
+package validate
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/RavisMsk/xmcompanies/internal/api/companies"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/countries"
+)
+
+// CompanyFields validates and normalizes the fields of a whole company,
+// reporting every invalid field.
+func CompanyFields(request companies.CompanyFields) (companies.CompanyFields, []error) {
+	var (
+		errs   []error
+		fields companies.CompanyFields
+	)
+
+	if processedName, err := Name(request.Name); err != nil {
+		errs = append(errs, err)
+	} else {
+		fields.Name = processedName
+	}
+
+	if processedCode, err := Code(request.Code); err != nil {
+		errs = append(errs, err)
+	} else {
+		fields.Code = processedCode
+	}
+
+	if !Country(request.Country) {
+		errs = append(errs, errors.New("invalid country"))
+	} else {
+		fields.Country = request.Country
+	}
+
+	if processedWebsite, err := Website(request.Website); err != nil {
+		errs = append(errs, err)
+	} else {
+		fields.Website = processedWebsite
+	}
+
+	if normalizedPhone, err := Phone(request.Phone); err != nil {
+		errs = append(errs, err)
+	} else {
+		fields.Phone = normalizedPhone
+	}
+
+	return fields, errs
+}
+
+var nameMatcher = regexp.MustCompile("^[A-Za-z ]+$").MatchString
+
+func Name(name string) (string, error) {
+	name = strings.Trim(name, " \n")
+	if len(name) < 4 {
+		return "", errors.New("company name must be at least 4 characters")
+	}
+	if !nameMatcher(name) {
+		return "", errors.New("company name can contain only letters and spaces")
+	}
+	return name, nil
+}
+
+var codeMatcher = regexp.MustCompile("^[A-Z]+$").MatchString
+
+func Code(code string) (string, error) {
+	code = strings.Trim(code, " \n")
+	if len(code) < 2 {
+		return "", errors.New("company code must be at least 2 characters")
+	}
+	if !codeMatcher(code) {
+		return "", errors.New("company code can contain only uppercase letters")
+	}
+	return code, nil
+}
+
+func Country(country string) bool {
+	return countries.IsValidCountry(country)
+}
+
+// Website and Phone are optional, empty ones are valid.
+func Website(website string) (string, error) {
+	if len(website) < 1 {
+		return website, nil
+	}
+	_, err := url.ParseRequestURI(website)
+	if err != nil {
+		return "", errors.New("website is not valid url")
+	}
+	return website, nil
+}
+
+func Phone(phone string) (string, error) {
+	return phone, nil
+}
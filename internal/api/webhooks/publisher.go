@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	apiModels "github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+// Publisher queues a delivery of every published event to each
+// subscription asking for it, the Worker sends them later.
+type Publisher struct {
+	store store.WebhookStore
+}
+
+func NewPublisher(store store.WebhookStore) *Publisher {
+	return &Publisher{store}
+}
+
+func (p *Publisher) Publish(ctx context.Context, event *apiModels.Event) error {
+	subscriptions, err := p.store.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if !subscribed(subscription, event.Type) {
+			continue
+		}
+		delivery := models.Delivery{
+			ID:             deliveryID(event.ID, subscription.ID),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		}
+		// An event published again after a failure
+		// keeps the deliveries queued the first time.
+		err = p.store.InsertDelivery(ctx, &delivery)
+		if err != nil && err != store.ErrDuplicate {
+			return err
+		}
+	}
+	return nil
+}
+
+func subscribed(subscription *models.Subscription, eventType string) bool {
+	if len(subscription.EventTypes) < 1 {
+		return true
+	}
+	for _, subscribedType := range subscription.EventTypes {
+		if subscribedType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryID is the same for an event and subscription every
+// time, so repeated publishing can't queue it twice.
+func deliveryID(eventID, subscriptionID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(eventID+"/"+subscriptionID)).String()
+}
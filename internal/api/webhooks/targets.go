@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenTarget means a subscription URL doesn't resolve to
+// public addresses only, deliveries must not reach internal services.
+var ErrForbiddenTarget = errors.New("webhook target not allowed")
+
+// PublicIP tells whether deliveries may be sent to ip.
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast()
+}
+
+// checkTarget resolves the host of rawURL with lookupIP and fails
+// with ErrForbiddenTarget unless every address is allowed.
+func checkTarget(
+	ctx context.Context,
+	rawURL string,
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error),
+	allowedIP func(net.IP) bool,
+) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrForbiddenTarget
+	}
+	ips, err := lookupIP(ctx, target.Hostname())
+	if err != nil || len(ips) < 1 {
+		return ErrForbiddenTarget
+	}
+	for _, ip := range ips {
+		if !allowedIP(ip) {
+			return ErrForbiddenTarget
+		}
+	}
+	return nil
+}
+
+func lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// dialControl checks the address a delivery connects to, the host may
+// resolve to other addresses than when the subscription was saved.
+func dialControl(allowedIP func(net.IP) bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !allowedIP(ip) {
+			return ErrForbiddenTarget
+		}
+		return nil
+	}
+}
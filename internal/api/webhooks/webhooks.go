@@ -0,0 +1,215 @@
+// Package webhooks delivers company events to subscribed URLs. Events
+// the outbox dispatcher publishes become one delivery per matching
+// subscription, a Worker sends them signed and retries failed ones.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+
+	apiModels "github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+// EventTypes lists the events subscriptions can ask for.
+var EventTypes = []string{
+	models.EventCompanyCreated,
+	models.EventCompanyUpdated,
+	models.EventCompanyDeleted,
+	models.EventCompanyRestored,
+}
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrNotDead  = errors.New("delivery not dead")
+)
+
+// SubscriptionFields are the settings of a subscription, empty
+// EventTypes subscribes to every event.
+type SubscriptionFields struct {
+	URL        string
+	EventTypes []string
+	Secret     string
+}
+
+type Webhooks interface {
+	// Create generates the secret when fields.Secret is empty, the
+	// returned subscription is the only one carrying it.
+	Create(ctx context.Context, fields SubscriptionFields) (*apiModels.Subscription, error)
+	Get(ctx context.Context, id string) (*apiModels.Subscription, error)
+	List(ctx context.Context) ([]*apiModels.Subscription, error)
+	// Update replaces the subscription fields, an empty
+	// fields.Secret keeps the current secret.
+	Update(ctx context.Context, id string, fields SubscriptionFields) error
+	// Delete removes the subscription with its deliveries.
+	Delete(ctx context.Context, id string) error
+	Deliveries(ctx context.Context, subscriptionID string, skip, limit uint64) ([]*apiModels.Delivery, error)
+	// Redeliver queues a dead delivery again with all its attempts,
+	// ErrNotDead means it is still being tried or has succeeded.
+	Redeliver(ctx context.Context, subscriptionID, deliveryID string) error
+}
+
+// StoreWebhooks fails Create and Update with ErrForbiddenTarget
+// for URLs resolving to loopback, private and alike addresses.
+type StoreWebhooks struct {
+	store     store.WebhookStore
+	lookupIP  func(ctx context.Context, host string) ([]net.IP, error)
+	allowedIP func(net.IP) bool
+}
+
+func NewStoreWebhooks(store store.WebhookStore) *StoreWebhooks {
+	return &StoreWebhooks{store, lookupIP, PublicIP}
+}
+
+func (w *StoreWebhooks) Create(ctx context.Context, fields SubscriptionFields) (*apiModels.Subscription, error) {
+	if err := checkTarget(ctx, fields.URL, w.lookupIP, w.allowedIP); err != nil {
+		return nil, err
+	}
+	if len(fields.Secret) < 1 {
+		secret, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		fields.Secret = secret
+	}
+	subscription := models.Subscription{
+		ID:         uuid.New().String(),
+		URL:        fields.URL,
+		EventTypes: fields.EventTypes,
+		Secret:     fields.Secret,
+	}
+	if err := w.store.InsertSubscription(ctx, &subscription); err != nil {
+		return nil, err
+	}
+	result := toAPISubscription(&subscription)
+	result.Secret = subscription.Secret
+	return result, nil
+}
+
+func (w *StoreWebhooks) Get(ctx context.Context, id string) (*apiModels.Subscription, error) {
+	subscription, err := w.store.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return toAPISubscription(subscription), nil
+}
+
+func (w *StoreWebhooks) List(ctx context.Context) ([]*apiModels.Subscription, error) {
+	subscriptions, err := w.store.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*apiModels.Subscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		results = append(results, toAPISubscription(subscription))
+	}
+	return results, nil
+}
+
+func (w *StoreWebhooks) Update(ctx context.Context, id string, fields SubscriptionFields) error {
+	subscription, err := w.store.GetSubscription(ctx, id)
+	if err != nil {
+		return translateError(err)
+	}
+	if err = checkTarget(ctx, fields.URL, w.lookupIP, w.allowedIP); err != nil {
+		return err
+	}
+	subscription.URL = fields.URL
+	subscription.EventTypes = fields.EventTypes
+	if len(fields.Secret) > 0 {
+		subscription.Secret = fields.Secret
+	}
+	return translateError(w.store.UpdateSubscription(ctx, subscription))
+}
+
+func (w *StoreWebhooks) Delete(ctx context.Context, id string) error {
+	return translateError(w.store.DeleteSubscription(ctx, id))
+}
+
+func (w *StoreWebhooks) Deliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*apiModels.Delivery, error) {
+	if _, err := w.store.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, translateError(err)
+	}
+	deliveries, err := w.store.SearchDeliveries(ctx, subscriptionID, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*apiModels.Delivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		results = append(results, toAPIDelivery(delivery))
+	}
+	return results, nil
+}
+
+func (w *StoreWebhooks) Redeliver(ctx context.Context, subscriptionID, deliveryID string) error {
+	delivery, err := w.store.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return translateError(err)
+	}
+	if delivery.SubscriptionID != subscriptionID {
+		return ErrNotFound
+	}
+	if delivery.Status != models.DeliveryDead {
+		return ErrNotDead
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	return translateError(w.store.UpdateDelivery(ctx, delivery))
+}
+
+func translateError(err error) error {
+	if err == store.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func toAPISubscription(subscription *models.Subscription) *apiModels.Subscription {
+	eventTypes := subscription.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	return &apiModels.Subscription{
+		ID:         subscription.ID,
+		URL:        subscription.URL,
+		EventTypes: eventTypes,
+		CreatedAt:  subscription.CreatedAt,
+		UpdatedAt:  subscription.UpdatedAt,
+	}
+}
+
+func toAPIDelivery(delivery *models.Delivery) *apiModels.Delivery {
+	return &apiModels.Delivery{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt,
+		UpdatedAt:      delivery.UpdatedAt,
+	}
+}
@@ -0,0 +1,202 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	apiModels "github.com/RavisMsk/xmcompanies/internal/api/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
+)
+
+// lookupPublicIP resolves every host to a public address.
+func lookupPublicIP(context.Context, string) ([]net.IP, error) {
+	return []net.IP{net.ParseIP("93.184.216.34")}, nil
+}
+
+// allowAnyIP lets deliveries reach test servers on loopback.
+func allowAnyIP(net.IP) bool {
+	return true
+}
+
+func TestPublish(t *testing.T) {
+	store := memory.NewWebhookStore()
+	hooks := NewStoreWebhooks(store)
+	hooks.lookupIP = lookupPublicIP
+	ctx := context.Background()
+
+	all, err := hooks.Create(ctx, SubscriptionFields{URL: "http://all"})
+	assert.NoError(t, err)
+	assert.Equal(t, 64, len(all.Secret))
+	deletes, err := hooks.Create(ctx, SubscriptionFields{
+		URL:        "http://deletes",
+		EventTypes: []string{models.EventCompanyDeleted},
+		Secret:     "a long enough secret",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "a long enough secret", deletes.Secret)
+
+	publisher := NewPublisher(store)
+	event := &apiModels.Event{ID: "event", Type: models.EventCompanyCreated, CompanyID: "company"}
+	assert.NoError(t, publisher.Publish(ctx, event))
+	// Published again after a dispatcher failure.
+	assert.NoError(t, publisher.Publish(ctx, event))
+
+	deliveries, err := hooks.Deliveries(ctx, all.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, "event", deliveries[0].EventID)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	deliveries, err = hooks.Deliveries(ctx, deletes.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	listed, err := hooks.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(listed))
+	assert.Empty(t, listed[0].Secret)
+	assert.Equal(t, []string{}, listed[0].EventTypes)
+
+	_, err = hooks.Deliveries(ctx, "missing", 0, 0)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestWorker(t *testing.T) {
+	var (
+		requests []*http.Request
+		bodies   [][]byte
+		status   = http.StatusInternalServerError
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	store := memory.NewWebhookStore()
+	hooks := NewStoreWebhooks(store)
+	hooks.allowedIP = allowAnyIP
+	ctx := context.Background()
+	subscription, err := hooks.Create(ctx, SubscriptionFields{URL: server.URL, Secret: "a long enough secret"})
+	assert.NoError(t, err)
+	event := &apiModels.Event{ID: "event", Type: models.EventCompanyUpdated, CompanyID: "company"}
+	assert.NoError(t, NewPublisher(store).Publish(ctx, event))
+
+	worker := NewWorker(store, WorkerOptions{MaxAttempts: 2, Backoff: time.Hour, MaxBackoff: time.Hour}, zap.NewNop())
+	worker.allowedIP = allowAnyIP
+	assert.Equal(t, 1, worker.deliverDue())
+	// Not due again until the backoff passes.
+	assert.Equal(t, 0, worker.deliverDue())
+
+	assert.Equal(t, 1, len(requests))
+	request := requests[0]
+	assert.Equal(t, http.MethodPost, request.Method)
+	assert.Equal(t, models.EventCompanyUpdated, request.Header.Get(HeaderEvent))
+	timestamp, err := strconv.ParseInt(request.Header.Get(HeaderTimestamp), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, Sign("a long enough secret", timestamp, bodies[0]), request.Header.Get(HeaderSignature))
+	assert.Contains(t, string(bodies[0]), `"company_id":"company"`)
+
+	deliveries, err := hooks.Deliveries(ctx, subscription.ID, 0, 0)
+	assert.NoError(t, err)
+	delivery := deliveries[0]
+	assert.Equal(t, request.Header.Get(HeaderDelivery), delivery.ID)
+	assert.Equal(t, models.DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, delivery.LastStatusCode)
+	assert.Equal(t, "unexpected status 500", delivery.LastError)
+	assert.True(t, delivery.NextAttemptAt.After(time.Now().Add(29*time.Minute)))
+
+	// The second and last attempt fails too.
+	stored, err := store.GetDelivery(ctx, delivery.ID)
+	assert.NoError(t, err)
+	stored.NextAttemptAt = time.Now()
+	assert.NoError(t, store.UpdateDelivery(ctx, stored))
+	assert.Equal(t, 1, worker.deliverDue())
+	deliveries, err = hooks.Deliveries(ctx, subscription.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DeliveryDead, deliveries[0].Status)
+	assert.Equal(t, 0, worker.deliverDue())
+
+	status = http.StatusNoContent
+	assert.Equal(t, ErrNotFound, hooks.Redeliver(ctx, "other", delivery.ID))
+	assert.NoError(t, hooks.Redeliver(ctx, subscription.ID, delivery.ID))
+	assert.Equal(t, 1, worker.deliverDue())
+	deliveries, err = hooks.Deliveries(ctx, subscription.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Empty(t, deliveries[0].LastError)
+	assert.Equal(t, ErrNotDead, hooks.Redeliver(ctx, subscription.ID, delivery.ID))
+	assert.Equal(t, 3, len(requests))
+}
+
+func TestForbiddenTargets(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "0.0.0.0", "fd00::1"} {
+		assert.False(t, PublicIP(net.ParseIP(ip)), ip)
+	}
+	assert.True(t, PublicIP(net.ParseIP("93.184.216.34")))
+
+	store := memory.NewWebhookStore()
+	hooks := NewStoreWebhooks(store)
+	hooks.lookupIP = func(context.Context, string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.1")}, nil
+	}
+	ctx := context.Background()
+	_, err := hooks.Create(ctx, SubscriptionFields{URL: "http://internal.example"})
+	assert.Equal(t, ErrForbiddenTarget, err)
+
+	hooks.lookupIP = lookupPublicIP
+	subscription, err := hooks.Create(ctx, SubscriptionFields{URL: "http://public.example"})
+	assert.NoError(t, err)
+	hooks.lookupIP = lookupIP
+	err = hooks.Update(ctx, subscription.ID, SubscriptionFields{URL: "http://127.0.0.1:8080"})
+	assert.Equal(t, ErrForbiddenTarget, err)
+
+	// Resolving to loopback after the subscription was saved.
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	stored, err := store.GetSubscription(ctx, subscription.ID)
+	assert.NoError(t, err)
+	stored.URL = server.URL
+	assert.NoError(t, store.UpdateSubscription(ctx, stored))
+	event := &apiModels.Event{ID: "event", Type: models.EventCompanyUpdated, CompanyID: "company"}
+	assert.NoError(t, NewPublisher(store).Publish(ctx, event))
+
+	worker := NewWorker(store, WorkerOptions{MaxAttempts: 2, Backoff: time.Hour, MaxBackoff: time.Hour}, zap.NewNop())
+	assert.Equal(t, 1, worker.deliverDue())
+	assert.Equal(t, 0, requests)
+	deliveries, err := hooks.Deliveries(ctx, subscription.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Contains(t, deliveries[0].LastError, ErrForbiddenTarget.Error())
+}
+
+func TestWorkerWithoutEvents(t *testing.T) {
+	store := memory.NewWebhookStore()
+	core, logs := observer.New(zap.WarnLevel)
+	worker := NewWorker(store, WorkerOptions{MaxAttempts: 1}, zap.New(core))
+	worker.warnIfSubscribed()
+	assert.Equal(t, 0, logs.Len())
+
+	hooks := NewStoreWebhooks(store)
+	hooks.lookupIP = lookupPublicIP
+	_, err := hooks.Create(context.Background(), SubscriptionFields{URL: "http://public.example"})
+	assert.NoError(t, err)
+	worker.warnIfSubscribed()
+	assert.Equal(t, 1, logs.Len())
+}
@@ -0,0 +1,233 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/backoff"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/poller"
+)
+
+const (
+	deliverInterval    = time.Second
+	deliverBatch       = 100
+	deliverConcurrency = 8
+	deliverTimeout     = 10 * time.Second
+	storeTimeout       = 10 * time.Second
+	// Responses are read only so connections can be reused.
+	maxResponseBody = 64 << 10
+)
+
+// Delivery request headers, receivers check the signature of
+// the timestamp and body and use the delivery ID to drop repeats.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign is the HeaderSignature value, a hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type WorkerOptions struct {
+	// MaxAttempts failed attempts make a delivery dead.
+	MaxAttempts int
+	// Backoff after the first failed attempt, see backoff.Jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// EventsEnabled tells whether company events are recorded,
+	// without them subscriptions never get new deliveries.
+	EventsEnabled bool
+}
+
+// Worker sends due deliveries, a 2xx response is a success and
+// anything else is retried later. Deliveries are sent at least once
+// and not in order, a single worker should run per database.
+type Worker struct {
+	store  store.WebhookStore
+	opts   WorkerOptions
+	client *http.Client
+	log    *zap.Logger
+	poller *poller.Poller
+	// allowedIP is checked for every address deliveries connect to.
+	allowedIP func(net.IP) bool
+}
+
+func NewWorker(store store.WebhookStore, opts WorkerOptions, log *zap.Logger) *Worker {
+	w := &Worker{
+		store:     store,
+		opts:      opts,
+		log:       log,
+		allowedIP: PublicIP,
+	}
+	dialer := &net.Dialer{
+		Timeout:   deliverTimeout,
+		KeepAlive: 30 * time.Second,
+		Control: dialControl(func(ip net.IP) bool {
+			return w.allowedIP(ip)
+		}),
+	}
+	w.client = &http.Client{
+		Timeout: deliverTimeout,
+		Transport: &http.Transport{
+			// No proxy, the address dialed is the one checked.
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: deliverTimeout,
+		},
+		// Redirects would turn the POST into a GET.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	w.poller = poller.New(deliverInterval, func() bool {
+		// A full batch means there is more due.
+		return w.deliverDue() == deliverBatch
+	})
+	return w
+}
+
+func (w *Worker) Run() {
+	if !w.opts.EventsEnabled {
+		w.warnIfSubscribed()
+	}
+	w.poller.Start()
+}
+
+func (w *Worker) warnIfSubscribed() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	subscriptions, err := w.store.ListSubscriptions(ctx)
+	if err != nil {
+		w.log.Error("error listing webhook subscriptions", zap.Error(err))
+		return
+	}
+	if len(subscriptions) > 0 {
+		w.log.Warn(
+			"company events are disabled, webhook subscriptions get no deliveries until outbox_enabled is set",
+			zap.Int("subscriptions", len(subscriptions)),
+		)
+	}
+}
+
+func (w *Worker) Stop() {
+	w.poller.Stop()
+}
+
+// deliverDue attempts a batch of due deliveries and
+// returns how many there were.
+func (w *Worker) deliverDue() int {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	deliveries, err := w.store.DueDeliveries(ctx, time.Now(), deliverBatch)
+	if err != nil {
+		w.log.Error("error loading due deliveries", zap.Error(err))
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, deliverConcurrency)
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery *models.Delivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			w.deliver(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+func (w *Worker) deliver(delivery *models.Delivery) {
+	log := w.log.With(
+		zap.String("id", delivery.ID),
+		zap.String("subscriptionID", delivery.SubscriptionID),
+	)
+	// The store calls share the time left by the request.
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout+deliverTimeout)
+	defer cancel()
+
+	subscription, err := w.store.GetSubscription(ctx, delivery.SubscriptionID)
+	if err == store.ErrNotFound {
+		// Deleted since, its deliveries went with it.
+		return
+	} else if err != nil {
+		log.Error("error loading delivery subscription", zap.Error(err))
+		return
+	}
+
+	statusCode, err := w.post(subscription, delivery)
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySucceeded
+	case delivery.Attempts >= w.opts.MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = err.Error()
+		log.Warn("webhook delivery dead", zap.Int("attempts", delivery.Attempts), zap.Error(err))
+	default:
+		delivery.NextAttemptAt = time.Now().Add(backoff.Jittered(w.opts.Backoff, w.opts.MaxBackoff, delivery.Attempts))
+		delivery.LastError = err.Error()
+		log.Info("webhook delivery failed", zap.Int("attempts", delivery.Attempts), zap.Error(err))
+	}
+
+	err = w.store.UpdateDelivery(ctx, delivery)
+	if err != nil && err != store.ErrNotFound {
+		log.Error("error saving delivery attempt", zap.Error(err))
+	}
+}
+
+func (w *Worker) post(subscription *models.Subscription, delivery *models.Delivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderEvent, delivery.EventType)
+	request.Header.Set(HeaderDelivery, delivery.ID)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, delivery.Payload))
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseBody))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
@@ -9,6 +9,8 @@ type Company struct {
 	Country   string     `bson:"country"`
 	Website   string     `bson:"website"`
 	Phone     string     `bson:"phone"`
+	Version   uint64     `bson:"version"`
 	CreatedAt time.Time  `bson:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at,omit_empty"`
+	DeletedAt *time.Time `bson:"deleted_at"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	EventCompanyCreated  = "company.created"
+	EventCompanyUpdated  = "company.updated"
+	EventCompanyDeleted  = "company.deleted"
+	EventCompanyRestored = "company.restored"
+)
+
+// Event is a company change kept in the outbox until delivered.
+type Event struct {
+	ID        string `bson:"id"`
+	Type      string `bson:"type"`
+	CompanyID string `bson:"company_id"`
+	// Company is the company after the change,
+	// or before it for deletes.
+	Company     *CompanySnapshot `bson:"company"`
+	RequestID   string           `bson:"request_id"`
+	CreatedAt   time.Time        `bson:"created_at"`
+	DeliveredAt *time.Time       `bson:"delivered_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	HistoryActionCreate  = "create"
+	HistoryActionUpdate  = "update"
+	HistoryActionDelete  = "delete"
+	HistoryActionRestore = "restore"
+)
+
+type CompanySnapshot struct {
+	Name    string `bson:"name"`
+	Code    string `bson:"code"`
+	Country string `bson:"country"`
+	Website string `bson:"website"`
+	Phone   string `bson:"phone"`
+	Version uint64 `bson:"version"`
+	// CreatedAt is zero in entries recorded before it was kept.
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type HistoryEntry struct {
+	CompanyID     string           `bson:"company_id"`
+	Action        string           `bson:"action"`
+	Before        *CompanySnapshot `bson:"before"`
+	After         *CompanySnapshot `bson:"after"`
+	RequestID     string           `bson:"request_id"`
+	ClientIP      string           `bson:"client_ip"`
+	ClientCountry string           `bson:"client_country"`
+	CreatedAt     time.Time        `bson:"created_at"`
+}
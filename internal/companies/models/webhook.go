@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead is given up on after its last attempt failed.
+	DeliveryDead = "dead"
+)
+
+// Subscription receives company events of EventTypes,
+// or all of them when EventTypes is empty.
+type Subscription struct {
+	ID         string     `bson:"id"`
+	URL        string     `bson:"url"`
+	EventTypes []string   `bson:"event_types"`
+	Secret     string     `bson:"secret"`
+	CreatedAt  time.Time  `bson:"created_at"`
+	UpdatedAt  *time.Time `bson:"updated_at"`
+}
+
+// Delivery is a company event sent to a subscription.
+type Delivery struct {
+	ID             string `bson:"id"`
+	SubscriptionID string `bson:"subscription_id"`
+	EventID        string `bson:"event_id"`
+	EventType      string `bson:"event_type"`
+	// Payload is the request body, every attempt sends the same.
+	Payload        []byte     `bson:"payload"`
+	Status         string     `bson:"status"`
+	Attempts       int        `bson:"attempts"`
+	NextAttemptAt  time.Time  `bson:"next_attempt_at"`
+	LastStatusCode int        `bson:"last_status_code"`
+	LastError      string     `bson:"last_error"`
+	CreatedAt      time.Time  `bson:"created_at"`
+	UpdatedAt      *time.Time `bson:"updated_at"`
+}
@@ -0,0 +1,114 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+var historyBucket = []byte("history")
+
+type HistoryStore struct {
+	db *bbolt.DB
+}
+
+func NewHistoryStore(db *bbolt.DB) (*HistoryStore, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryStore{db}, nil
+}
+
+func (s *HistoryStore) InsertHistory(ctx context.Context, entry *models.HistoryEntry) error {
+	// BSON dates keep milliseconds only, truncate upfront so the
+	// entry matches what HistoryAt compares against later.
+	entry.CreatedAt = time.Now().Truncate(time.Millisecond)
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(historyBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		// Keys sort by company and then by insertion sequence.
+		seqBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(seqBytes, seq)
+		return bucket.Put(append(indexKey(entry.CompanyID, ""), seqBytes...), data)
+	})
+}
+
+func (s *HistoryStore) SearchHistory(
+	ctx context.Context,
+	companyID string,
+	skip, limit uint64,
+) ([]*models.HistoryEntry, error) {
+	var results []*models.HistoryEntry
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		var matched uint64
+		prefix := indexKey(companyID, "")
+		cursor := tx.Bucket(historyBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			matched++
+			if matched <= skip {
+				continue
+			}
+			var entry models.HistoryEntry
+			if err := bson.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			results = append(results, &entry)
+			if limit > 0 && uint64(len(results)) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *HistoryStore) HistoryAt(
+	ctx context.Context,
+	companyID string,
+	at time.Time,
+) (*models.HistoryEntry, error) {
+	var found *models.HistoryEntry
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		prefix := indexKey(companyID, "")
+		cursor := tx.Bucket(historyBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			var entry models.HistoryEntry
+			if err := bson.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if entry.CreatedAt.After(at) {
+				break
+			}
+			found = &entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, store.ErrNotFound
+	}
+	return found, nil
+}
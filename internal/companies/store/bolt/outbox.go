@@ -0,0 +1,124 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+var (
+	// Events are keyed by insertion sequence, pending
+	// ones also by id to their sequence key.
+	outboxBucket  = []byte("outbox")
+	pendingBucket = []byte("outbox_pending")
+)
+
+type OutboxStore struct {
+	db *bbolt.DB
+}
+
+func NewOutboxStore(db *bbolt.DB) (*OutboxStore, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{outboxBucket, pendingBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxStore{db}, nil
+}
+
+func (s *OutboxStore) InsertEvent(ctx context.Context, event *models.Event) error {
+	event.CreatedAt = time.Now().Truncate(time.Millisecond)
+	event.DeliveredAt = nil
+	data, err := bson.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		seqBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(seqBytes, seq)
+		if err = bucket.Put(seqBytes, data); err != nil {
+			return err
+		}
+		return tx.Bucket(pendingBucket).Put([]byte(event.ID), seqBytes)
+	})
+}
+
+func (s *OutboxStore) PendingEvents(ctx context.Context, limit uint64) ([]*models.Event, error) {
+	var results []*models.Event
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		var seqs [][]byte
+		err := tx.Bucket(pendingBucket).ForEach(func(_, seq []byte) error {
+			seqs = append(seqs, seq)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(seqs, func(i, j int) bool {
+			return binary.BigEndian.Uint64(seqs[i]) < binary.BigEndian.Uint64(seqs[j])
+		})
+		if limit > 0 && limit < uint64(len(seqs)) {
+			seqs = seqs[:limit]
+		}
+
+		bucket := tx.Bucket(outboxBucket)
+		for _, seq := range seqs {
+			var event models.Event
+			if err := bson.Unmarshal(bucket.Get(seq), &event); err != nil {
+				return err
+			}
+			results = append(results, &event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *OutboxStore) MarkDelivered(ctx context.Context, id string) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		seq := pending.Get([]byte(id))
+		if seq == nil {
+			return store.ErrNotFound
+		}
+		// The key is only valid during the transaction.
+		seq = append([]byte{}, seq...)
+
+		bucket := tx.Bucket(outboxBucket)
+		var event models.Event
+		if err := bson.Unmarshal(bucket.Get(seq), &event); err != nil {
+			return err
+		}
+		now := time.Now().Truncate(time.Millisecond)
+		event.DeliveredAt = &now
+		data, err := bson.Marshal(&event)
+		if err != nil {
+			return err
+		}
+		if err = bucket.Put(seq, data); err != nil {
+			return err
+		}
+		return pending.Delete([]byte(id))
+	})
+}
@@ -0,0 +1,619 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+var (
+	companiesBucket = []byte("companies")
+	codeIndex       = []byte("idx_code")
+	countryIndex    = []byte("idx_country")
+	nameIndex       = []byte("idx_name")
+	createdIndex    = []byte("idx_created")
+	textIndex       = []byte("idx_text")
+)
+
+// Index keys are "<value>\x00<id>", so all ids for a value
+// can be fetched with a single prefix scan.
+const indexSeparator = 0
+
+type Store struct {
+	db *bbolt.DB
+}
+
+func NewStore(db *bbolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		// Databases created before an index existed get
+		// it built from the stored companies.
+		backfill := tx.Bucket(companiesBucket) != nil &&
+			(tx.Bucket(createdIndex) == nil || tx.Bucket(textIndex) == nil)
+		for _, name := range [][]byte{companiesBucket, codeIndex, countryIndex, nameIndex, createdIndex, textIndex} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if !backfill {
+			return nil
+		}
+		return tx.Bucket(companiesBucket).ForEach(func(_, data []byte) error {
+			company, err := decodeCompany(data)
+			if err != nil {
+				return err
+			}
+			return forEachIndex(tx, company, func(bucket *bbolt.Bucket, key []byte) error {
+				return bucket.Put(key, []byte{})
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db}, nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*models.Company, error) {
+	var company *models.Company
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		var err error
+		company, err = getLiveCompany(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+func (s *Store) GetAny(ctx context.Context, id string) (*models.Company, error) {
+	var company *models.Company
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		var err error
+		company, err = getCompany(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+func (s *Store) GetMany(ctx context.Context, ids []string) ([]*models.Company, error) {
+	var results []*models.Company
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		results = results[:0]
+		for _, id := range ids {
+			company, err := getLiveCompany(tx, id)
+			if err == store.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			results = append(results, company)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Store) Insert(ctx context.Context, company *models.Company) error {
+	// BSON dates keep milliseconds only, truncate upfront so the
+	// created index key matches the stored document.
+	company.CreatedAt = time.Now().Truncate(time.Millisecond)
+	company.UpdatedAt = nil
+	company.DeletedAt = nil
+	company.Version = 1
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		if err := checkUnique(tx, company); err != nil {
+			return err
+		}
+		return putCompany(tx, company)
+	})
+}
+
+func (s *Store) Update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	fields store.CompanyOptFields,
+) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		company, err := getVersionedCompany(tx, id, version)
+		if err != nil {
+			return err
+		}
+		if err = unindexCompany(tx, company); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		company.UpdatedAt = &now
+		company.Version++
+		fields.Apply(company)
+		if err = checkUnique(tx, company); err != nil {
+			return err
+		}
+		return putCompany(tx, company)
+	})
+}
+
+func (s *Store) UpdateMany(
+	ctx context.Context,
+	query store.SearchFilters,
+	fields store.CompanyOptFields,
+) (uint64, error) {
+	var updated uint64
+	err := update(ctx, s.db, func(tx *bbolt.Tx) error {
+		matched, err := matchLive(tx, query)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, company := range matched {
+			if err = unindexCompany(tx, company); err != nil {
+				return err
+			}
+			company.UpdatedAt = &now
+			company.Version++
+			fields.Apply(company)
+			// Companies updated earlier are already indexed,
+			// collisions within the batch are caught too.
+			if err = checkUnique(tx, company); errors.Is(err, store.ErrDuplicate) {
+				return store.ErrDuplicate
+			} else if err != nil {
+				return err
+			}
+			if err = putCompany(tx, company); err != nil {
+				return err
+			}
+		}
+		updated = uint64(len(matched))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (s *Store) DeleteMany(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	var deleted uint64
+	err := update(ctx, s.db, func(tx *bbolt.Tx) error {
+		matched, err := matchLive(tx, query)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, company := range matched {
+			company.DeletedAt = &now
+			company.UpdatedAt = &now
+			company.Version++
+			if err = putCompany(tx, company); err != nil {
+				return err
+			}
+		}
+		deleted = uint64(len(matched))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// matchLive collects the companies to change up front,
+// buckets must not be modified while scanning them.
+func matchLive(tx *bbolt.Tx, query store.SearchFilters) ([]*models.Company, error) {
+	var matched []*models.Company
+	err := scanCandidates(tx, query, collect(&matched, 0, 0, func(company *models.Company) bool {
+		return company.DeletedAt == nil && query.Matches(company)
+	}))
+	return matched, err
+}
+
+func (s *Store) Delete(ctx context.Context, id string, version uint64) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		company, err := getVersionedCompany(tx, id, version)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		company.DeletedAt = &now
+		company.UpdatedAt = &now
+		company.Version++
+		return putCompany(tx, company)
+	})
+}
+
+func (s *Store) SearchDeleted(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	var results []*models.Company
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		fn := collect(&results, skip, limit, func(company *models.Company) bool {
+			return company.DeletedAt != nil
+		})
+		return scanCandidates(tx, store.SearchFilters{}, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Store) Restore(ctx context.Context, id string) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		company, err := getCompany(tx, id)
+		if err != nil {
+			return err
+		}
+		if company.DeletedAt == nil {
+			return store.ErrNotFound
+		}
+		now := time.Now()
+		company.DeletedAt = nil
+		company.UpdatedAt = &now
+		company.Version++
+		return putCompany(tx, company)
+	})
+}
+
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	var purged uint64
+	err := update(ctx, s.db, func(tx *bbolt.Tx) error {
+		var expired []*models.Company
+		fn := collect(&expired, 0, 0, func(company *models.Company) bool {
+			return company.DeletedAt != nil && company.DeletedAt.Before(deletedBefore)
+		})
+		if err := scanCandidates(tx, store.SearchFilters{}, fn); err != nil {
+			return err
+		}
+		for _, company := range expired {
+			if err := unindexCompany(tx, company); err != nil {
+				return err
+			}
+			if err := tx.Bucket(companiesBucket).Delete([]byte(company.ID)); err != nil {
+				return err
+			}
+		}
+		purged = uint64(len(expired))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (s *Store) Search(
+	ctx context.Context,
+	query store.SearchFilters,
+	order []store.SortField,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	keyset := store.KeysetSort(order)
+	after, err := store.DecodeCursor(cursor, keyset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []*models.Company
+	err = view(ctx, s.db, func(tx *bbolt.Tx) error {
+		match := func(company *models.Company) bool {
+			return company.DeletedAt == nil && query.Matches(company) && after.After(company)
+		}
+		if index, _ := selectIndex(query); index == nil && store.IsDefaultSort(keyset) {
+			return scanCreated(tx, after, collect(&results, 0, store.FetchLimit(limit), match))
+		}
+		// Field indexes aren't ordered by the keyset, collect all
+		// matches and sort them instead.
+		if err := scanCandidates(tx, query, collect(&results, 0, 0, match)); err != nil {
+			return err
+		}
+		sort.Slice(results, func(i, j int) bool {
+			return store.Compare(results[i], results[j], keyset) < 0
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	results, next := store.Page(results, keyset, limit)
+	return results, next, nil
+}
+
+func (s *Store) Each(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	fn func(*models.Company) error,
+) error {
+	return store.EachPage(ctx, s, query, sort, fn)
+}
+
+func (s *Store) Count(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	var count uint64
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		return scanCandidates(tx, query, func(company *models.Company) bool {
+			if company.DeletedAt == nil && query.Matches(company) {
+				count++
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) TextSearch(
+	ctx context.Context,
+	query store.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*store.ScoredCompany, string, error) {
+	after, err := store.DecodeTextCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if query.Text == nil {
+		return nil, "", nil
+	}
+	terms := store.TextTerms(*query.Text)
+
+	var candidates []*models.Company
+	err = view(ctx, s.db, func(tx *bbolt.Tx) error {
+		seen := map[string]bool{}
+		for _, term := range terms {
+			prefix := indexKey(term, "")
+			cursor := tx.Bucket(textIndex).Cursor()
+			for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+				id := string(key[len(prefix):])
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				company, err := getCompany(tx, id)
+				if err != nil {
+					return err
+				}
+				if company.DeletedAt == nil && query.Matches(company) {
+					candidates = append(candidates, company)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	results, next := store.PageText(store.RankText(candidates, terms, after), limit)
+	return results, next, nil
+}
+
+// collect builds a scanCandidates callback appending matching
+// companies to results while honoring skip and limit.
+func collect(
+	results *[]*models.Company,
+	skip, limit uint64,
+	match func(*models.Company) bool,
+) func(*models.Company) bool {
+	var matched uint64
+	return func(company *models.Company) bool {
+		if !match(company) {
+			return true
+		}
+		matched++
+		if matched <= skip {
+			return true
+		}
+		*results = append(*results, company)
+		return limit == 0 || uint64(len(*results)) < limit
+	}
+}
+
+// scanCandidates walks companies that may match the query, using the
+// most selective available index, and stops once fn returns false.
+func scanCandidates(
+	tx *bbolt.Tx,
+	query store.SearchFilters,
+	fn func(*models.Company) bool,
+) error {
+	index, value := selectIndex(query)
+	if index == nil {
+		cursor := tx.Bucket(companiesBucket).Cursor()
+		for _, data := cursor.First(); data != nil; _, data = cursor.Next() {
+			company, err := decodeCompany(data)
+			if err != nil {
+				return err
+			}
+			if !fn(company) {
+				break
+			}
+		}
+		return nil
+	}
+
+	prefix := indexKey(*value, "")
+	cursor := tx.Bucket(index).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		company, err := getCompany(tx, string(key[len(prefix):]))
+		if err != nil {
+			return err
+		}
+		if !fn(company) {
+			break
+		}
+	}
+	return nil
+}
+
+// scanCreated walks companies in (created_at, id) order
+// starting after the cursor.
+func scanCreated(tx *bbolt.Tx, after *store.Cursor, fn func(*models.Company) bool) error {
+	cursor := tx.Bucket(createdIndex).Cursor()
+	key, _ := cursor.First()
+	if after != nil {
+		key, _ = cursor.Seek(createdKey(after.Company.CreatedAt, after.Company.ID))
+	}
+	for ; key != nil; key, _ = cursor.Next() {
+		company, err := getCompany(tx, string(key[createdKeyPrefix:]))
+		if err != nil {
+			return err
+		}
+		if !fn(company) {
+			break
+		}
+	}
+	return nil
+}
+
+// selectIndex picks an index for exact match filters,
+// other operators are checked on the scanned companies.
+func selectIndex(query store.SearchFilters) ([]byte, *string) {
+	switch {
+	case query.Code.Eq != nil:
+		return codeIndex, query.Code.Eq
+	case query.Name.Eq != nil:
+		return nameIndex, query.Name.Eq
+	case query.Country.Eq != nil:
+		return countryIndex, query.Country.Eq
+	}
+	return nil, nil
+}
+
+// checkUnique looks up companies with the same code through the
+// code index and fails if any of them is in the same country.
+func checkUnique(tx *bbolt.Tx, company *models.Company) error {
+	prefix := indexKey(company.Code, "")
+	cursor := tx.Bucket(codeIndex).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		id := string(key[len(prefix):])
+		if id == company.ID {
+			continue
+		}
+		existing, err := getCompany(tx, id)
+		if err != nil {
+			return err
+		}
+		if existing.Country == company.Country {
+			return &store.DuplicateError{ExistingID: id}
+		}
+	}
+	return nil
+}
+
+func getCompany(tx *bbolt.Tx, id string) (*models.Company, error) {
+	data := tx.Bucket(companiesBucket).Get([]byte(id))
+	if data == nil {
+		return nil, store.ErrNotFound
+	}
+	return decodeCompany(data)
+}
+
+func getLiveCompany(tx *bbolt.Tx, id string) (*models.Company, error) {
+	company, err := getCompany(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if company.DeletedAt != nil {
+		return nil, store.ErrNotFound
+	}
+	return company, nil
+}
+
+func getVersionedCompany(tx *bbolt.Tx, id string, version uint64) (*models.Company, error) {
+	company, err := getLiveCompany(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != store.AnyVersion && company.Version != version {
+		return nil, store.ErrConflict
+	}
+	return company, nil
+}
+
+func decodeCompany(data []byte) (*models.Company, error) {
+	var company models.Company
+	if err := bson.Unmarshal(data, &company); err != nil {
+		return nil, err
+	}
+	return &company, nil
+}
+
+func putCompany(tx *bbolt.Tx, company *models.Company) error {
+	data, err := bson.Marshal(company)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(companiesBucket).Put([]byte(company.ID), data); err != nil {
+		return err
+	}
+	return forEachIndex(tx, company, func(bucket *bbolt.Bucket, key []byte) error {
+		return bucket.Put(key, []byte{})
+	})
+}
+
+func unindexCompany(tx *bbolt.Tx, company *models.Company) error {
+	return forEachIndex(tx, company, func(bucket *bbolt.Bucket, key []byte) error {
+		return bucket.Delete(key)
+	})
+}
+
+func forEachIndex(
+	tx *bbolt.Tx,
+	company *models.Company,
+	fn func(*bbolt.Bucket, []byte) error,
+) error {
+	type indexEntry struct {
+		bucket []byte
+		key    []byte
+	}
+	entries := []indexEntry{
+		{codeIndex, indexKey(company.Code, company.ID)},
+		{countryIndex, indexKey(company.Country, company.ID)},
+		{nameIndex, indexKey(company.Name, company.ID)},
+		{createdIndex, createdKey(company.CreatedAt, company.ID)},
+	}
+	for _, term := range store.CompanyTerms(company) {
+		entries = append(entries, indexEntry{textIndex, indexKey(term, company.ID)})
+	}
+	for _, entry := range entries {
+		if err := fn(tx.Bucket(entry.bucket), entry.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexKey(value, id string) []byte {
+	key := make([]byte, 0, len(value)+len(id)+1)
+	key = append(key, value...)
+	key = append(key, indexSeparator)
+	return append(key, id...)
+}
+
+// Created index keys are big endian creation unix nanos followed
+// by id, so byte order is (created_at, id) order.
+const createdKeyPrefix = 8
+
+func createdKey(createdAt time.Time, id string) []byte {
+	key := make([]byte, createdKeyPrefix, createdKeyPrefix+len(id))
+	binary.BigEndian.PutUint64(key, uint64(createdAt.UnixNano()))
+	return append(key, id...)
+}
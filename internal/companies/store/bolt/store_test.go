@@ -0,0 +1,180 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/storetest"
+)
+
+func createTestStore(t *testing.T) *Store {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "companies.db"), 0600, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewStore(db)
+	assert.NoError(t, err)
+
+	companies := storetest.Companies()
+	for idx := range companies {
+		assert.NoError(t, s.Insert(context.Background(), &companies[idx]))
+	}
+	return s
+}
+
+func TestGet(t *testing.T) {
+	s := createTestStore(t)
+
+	company, err := s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Second", company.Name)
+	assert.False(t, company.CreatedAt.IsZero())
+	assert.Nil(t, company.UpdatedAt)
+
+	_, err = s.Get(context.Background(), "4")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestUpdateReindexes(t *testing.T) {
+	s := createTestStore(t)
+
+	country := "Greece"
+	assert.NoError(t, s.Update(context.Background(), "1", store.AnyVersion, store.CompanyOptFields{Country: &country}))
+
+	results, _, err := s.Search(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+
+	cyprus := "Cyprus"
+	results, _, err = s.Search(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &cyprus}}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "3", results[0].ID)
+	assert.Equal(t, store.ErrNotFound, s.Update(context.Background(), "4", store.AnyVersion, store.CompanyOptFields{}))
+}
+
+func TestDeleteUnindexes(t *testing.T) {
+	s := createTestStore(t)
+
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	assert.Equal(t, store.ErrNotFound, s.Delete(context.Background(), "1", store.AnyVersion))
+
+	code := "FC"
+	results, _, err := s.Search(context.Background(), store.SearchFilters{Code: store.StringFilter{Eq: &code}}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestSearch(t *testing.T) {
+	storetest.Search(t, createTestStore(t))
+}
+
+func TestSearchCursorStable(t *testing.T) {
+	storetest.SearchCursorStable(t, createTestStore(t))
+}
+
+func TestSearchOperators(t *testing.T) {
+	storetest.SearchOperators(t, createTestStore(t))
+}
+
+func TestTextSearch(t *testing.T) {
+	storetest.TextSearch(t, createTestStore(t))
+}
+
+func TestSearchSorted(t *testing.T) {
+	storetest.SearchSorted(t, createTestStore(t))
+}
+
+func TestCount(t *testing.T) {
+	storetest.Count(t, createTestStore(t))
+}
+
+func TestGetMany(t *testing.T) {
+	storetest.GetMany(t, createTestStore(t))
+}
+
+func TestEach(t *testing.T) {
+	storetest.Each(t, createTestStore(t))
+}
+
+func TestUpdateMany(t *testing.T) {
+	storetest.UpdateMany(t, createTestStore(t))
+}
+
+func TestDeleteMany(t *testing.T) {
+	storetest.DeleteMany(t, createTestStore(t))
+}
+
+func TestVersionConflict(t *testing.T) {
+	storetest.VersionConflict(t, createTestStore(t))
+}
+
+func TestTrash(t *testing.T) {
+	storetest.Trash(t, createTestStore(t))
+}
+
+func TestHistory(t *testing.T) {
+	h, err := NewHistoryStore(createTestStore(t).db)
+	assert.NoError(t, err)
+	storetest.History(t, h)
+}
+
+func TestUniqueCodeInCountry(t *testing.T) {
+	storetest.UniqueCodeInCountry(t, createTestStore(t))
+}
+
+func TestOutbox(t *testing.T) {
+	o, err := NewOutboxStore(createTestStore(t).db)
+	assert.NoError(t, err)
+	storetest.Outbox(t, o)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	s := createTestStore(t)
+	o, err := NewOutboxStore(s.db)
+	assert.NoError(t, err)
+
+	failed := errors.New("failed")
+	err = s.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.Insert(ctx, &models.Company{ID: "4", Name: "Fourth", Code: "4C", Country: "Cyprus"}); err != nil {
+			return err
+		}
+		if err := o.InsertEvent(ctx, &models.Event{ID: "e1", CompanyID: "4"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	_, err = s.Get(context.Background(), "4")
+	assert.Equal(t, store.ErrNotFound, err)
+	events, err := o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	err = s.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.Insert(ctx, &models.Company{ID: "4", Name: "Fourth", Code: "4C", Country: "Cyprus"}); err != nil {
+			return err
+		}
+		return o.InsertEvent(ctx, &models.Event{ID: "e1", CompanyID: "4"})
+	})
+	assert.NoError(t, err)
+	_, err = s.Get(context.Background(), "4")
+	assert.NoError(t, err)
+	events, err = o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+}
+
+func TestWebhooks(t *testing.T) {
+	w, err := NewWebhookStore(createTestStore(t).db)
+	assert.NoError(t, err)
+	storetest.Webhooks(t, w)
+}
@@ -0,0 +1,44 @@
+package bolt
+
+import (
+	"context"
+
+	bbolt "go.etcd.io/bbolt"
+)
+
+type txKey struct{}
+
+func txFrom(ctx context.Context) *bbolt.Tx {
+	tx, _ := ctx.Value(txKey{}).(*bbolt.Tx)
+	return tx
+}
+
+// update runs fn in the transaction ctx carries or in a new one. A
+// failed fn leaves its partial writes to the carried transaction,
+// which has to be rolled back then.
+func update(ctx context.Context, db *bbolt.DB, fn func(tx *bbolt.Tx) error) error {
+	if tx := txFrom(ctx); tx != nil {
+		return fn(tx)
+	}
+	return db.Update(fn)
+}
+
+// view is update for reads, a carried transaction
+// is used so its own writes are seen.
+func view(ctx context.Context, db *bbolt.DB, fn func(tx *bbolt.Tx) error) error {
+	if tx := txFrom(ctx); tx != nil {
+		return fn(tx)
+	}
+	return db.View(fn)
+}
+
+// WithTransaction covers the stores sharing the database,
+// history and outbox included.
+func (s *Store) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txFrom(ctx) != nil {
+		return fn(ctx)
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
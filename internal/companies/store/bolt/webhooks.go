@@ -0,0 +1,321 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+var (
+	webhooksBucket = []byte("webhooks")
+	// Deliveries are keyed by subscription and then by insertion
+	// sequence, by id to that key, and pending ones in the due
+	// bucket by id to that key as well.
+	deliveriesBucket  = []byte("webhook_deliveries")
+	deliveryIDsBucket = []byte("webhook_delivery_ids")
+	dueBucket         = []byte("webhook_due")
+)
+
+type WebhookStore struct {
+	db *bbolt.DB
+}
+
+func NewWebhookStore(db *bbolt.DB) (*WebhookStore, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{webhooksBucket, deliveriesBucket, deliveryIDsBucket, dueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookStore{db}, nil
+}
+
+func (s *WebhookStore) InsertSubscription(ctx context.Context, subscription *models.Subscription) error {
+	subscription.CreatedAt = time.Now().Truncate(time.Millisecond)
+	subscription.UpdatedAt = nil
+	data, err := bson.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		return tx.Bucket(webhooksBucket).Put([]byte(subscription.ID), data)
+	})
+}
+
+func (s *WebhookStore) GetSubscription(ctx context.Context, id string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		data := tx.Bucket(webhooksBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrNotFound
+		}
+		return bson.Unmarshal(data, &subscription)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *WebhookStore) ListSubscriptions(ctx context.Context) ([]*models.Subscription, error) {
+	var results []*models.Subscription
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		return tx.Bucket(webhooksBucket).ForEach(func(_, data []byte) error {
+			var subscription models.Subscription
+			if err := bson.Unmarshal(data, &subscription); err != nil {
+				return err
+			}
+			results = append(results, &subscription)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt.Before(results[j].CreatedAt)
+	})
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(webhooksBucket)
+		data := bucket.Get([]byte(subscription.ID))
+		if data == nil {
+			return store.ErrNotFound
+		}
+		var stored models.Subscription
+		if err := bson.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		now := time.Now().Truncate(time.Millisecond)
+		stored.URL = subscription.URL
+		stored.EventTypes = subscription.EventTypes
+		stored.Secret = subscription.Secret
+		stored.UpdatedAt = &now
+		data, err := bson.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(subscription.ID), data)
+	})
+}
+
+func (s *WebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(webhooksBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return store.ErrNotFound
+		}
+		if err := bucket.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		// Collect first, deleting moves the cursor.
+		var keys, ids [][]byte
+		prefix := indexKey(id, "")
+		deliveries := tx.Bucket(deliveriesBucket)
+		cursor := deliveries.Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			var delivery models.Delivery
+			if err := bson.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte{}, key...))
+			ids = append(ids, []byte(delivery.ID))
+		}
+		for idx := range keys {
+			if err := deliveries.Delete(keys[idx]); err != nil {
+				return err
+			}
+			if err := tx.Bucket(deliveryIDsBucket).Delete(ids[idx]); err != nil {
+				return err
+			}
+			if err := tx.Bucket(dueBucket).Delete(ids[idx]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *WebhookStore) InsertDelivery(ctx context.Context, delivery *models.Delivery) error {
+	delivery.CreatedAt = time.Now().Truncate(time.Millisecond)
+	delivery.UpdatedAt = nil
+	data, err := bson.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		ids := tx.Bucket(deliveryIDsBucket)
+		if ids.Get([]byte(delivery.ID)) != nil {
+			return store.ErrDuplicate
+		}
+		bucket := tx.Bucket(deliveriesBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		seqBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(seqBytes, seq)
+		key := append(indexKey(delivery.SubscriptionID, ""), seqBytes...)
+		if err = bucket.Put(key, data); err != nil {
+			return err
+		}
+		if err = ids.Put([]byte(delivery.ID), key); err != nil {
+			return err
+		}
+		if delivery.Status != models.DeliveryPending {
+			return nil
+		}
+		return tx.Bucket(dueBucket).Put([]byte(delivery.ID), key)
+	})
+}
+
+func (s *WebhookStore) GetDelivery(ctx context.Context, id string) (*models.Delivery, error) {
+	var delivery *models.Delivery
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		key := tx.Bucket(deliveryIDsBucket).Get([]byte(id))
+		if key == nil {
+			return store.ErrNotFound
+		}
+		var err error
+		delivery, err = deliveryAt(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (s *WebhookStore) DueDeliveries(
+	ctx context.Context,
+	now time.Time,
+	limit uint64,
+) ([]*models.Delivery, error) {
+	type dueDelivery struct {
+		delivery *models.Delivery
+		seq      uint64
+	}
+	var due []dueDelivery
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		return tx.Bucket(dueBucket).ForEach(func(_, key []byte) error {
+			delivery, err := deliveryAt(tx, key)
+			if err != nil {
+				return err
+			}
+			if delivery.NextAttemptAt.After(now) {
+				return nil
+			}
+			due = append(due, dueDelivery{delivery, binary.BigEndian.Uint64(key[len(key)-8:])})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].delivery.NextAttemptAt.Equal(due[j].delivery.NextAttemptAt) {
+			return due[i].delivery.NextAttemptAt.Before(due[j].delivery.NextAttemptAt)
+		}
+		return due[i].seq < due[j].seq
+	})
+	if limit > 0 && limit < uint64(len(due)) {
+		due = due[:limit]
+	}
+	results := make([]*models.Delivery, 0, len(due))
+	for _, item := range due {
+		results = append(results, item.delivery)
+	}
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	return update(ctx, s.db, func(tx *bbolt.Tx) error {
+		key := tx.Bucket(deliveryIDsBucket).Get([]byte(delivery.ID))
+		if key == nil {
+			return store.ErrNotFound
+		}
+		// The key is only valid during the transaction.
+		key = append([]byte{}, key...)
+
+		stored, err := deliveryAt(tx, key)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Truncate(time.Millisecond)
+		stored.Status = delivery.Status
+		stored.Attempts = delivery.Attempts
+		stored.NextAttemptAt = delivery.NextAttemptAt
+		stored.LastStatusCode = delivery.LastStatusCode
+		stored.LastError = delivery.LastError
+		stored.UpdatedAt = &now
+		data, err := bson.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket(deliveriesBucket).Put(key, data); err != nil {
+			return err
+		}
+		if stored.Status == models.DeliveryPending {
+			return tx.Bucket(dueBucket).Put([]byte(stored.ID), key)
+		}
+		return tx.Bucket(dueBucket).Delete([]byte(stored.ID))
+	})
+}
+
+func (s *WebhookStore) SearchDeliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*models.Delivery, error) {
+	var results []*models.Delivery
+	err := view(ctx, s.db, func(tx *bbolt.Tx) error {
+		var matched uint64
+		prefix := indexKey(subscriptionID, "")
+		cursor := tx.Bucket(deliveriesBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			matched++
+			if matched <= skip {
+				continue
+			}
+			var delivery models.Delivery
+			if err := bson.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			results = append(results, &delivery)
+			if limit > 0 && uint64(len(results)) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func deliveryAt(tx *bbolt.Tx, key []byte) (*models.Delivery, error) {
+	var delivery models.Delivery
+	if err := bson.Unmarshal(tx.Bucket(deliveriesBucket).Get(key), &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
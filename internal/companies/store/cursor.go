@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a keyset position, Search continues with companies
+// strictly after Company in Keyset order.
+type Cursor struct {
+	Keyset  []SortField
+	Company models.Company
+}
+
+type cursorToken struct {
+	Sort      string `json:"s"`
+	ID        string `json:"i"`
+	CreatedAt int64  `json:"c,omitempty"`
+	Name      string `json:"n,omitempty"`
+	Code      string `json:"cd,omitempty"`
+	Country   string `json:"ct,omitempty"`
+	Website   string `json:"w,omitempty"`
+	Phone     string `json:"p,omitempty"`
+}
+
+// Encode returns an opaque token keeping only the values the keyset
+// orders by, clients must pass it back as is.
+func (c Cursor) Encode() string {
+	token := cursorToken{
+		Sort: sortString(c.Keyset),
+		ID:   c.Company.ID,
+	}
+	for _, field := range c.Keyset {
+		switch field.Field {
+		case SortFieldCreatedAt:
+			token.CreatedAt = c.Company.CreatedAt.UnixNano()
+		case SortFieldName:
+			token.Name = c.Company.Name
+		case SortFieldCode:
+			token.Code = c.Company.Code
+		case SortFieldCountry:
+			token.Country = c.Company.Country
+		case SortFieldWebsite:
+			token.Website = c.Company.Website
+		case SortFieldPhone:
+			token.Phone = c.Company.Phone
+		}
+	}
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a token from Encode, empty token means the first
+// page and gives nil cursor. Tokens issued for another order are invalid.
+func DecodeCursor(token string, keyset []SortField) (*Cursor, error) {
+	if len(token) < 1 {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded cursorToken
+	if err = json.Unmarshal(data, &decoded); err != nil || len(decoded.ID) < 1 {
+		return nil, ErrInvalidCursor
+	}
+	if decoded.Sort != sortString(keyset) {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{
+		Keyset: keyset,
+		Company: models.Company{
+			ID:        decoded.ID,
+			Name:      decoded.Name,
+			Code:      decoded.Code,
+			Country:   decoded.Country,
+			Website:   decoded.Website,
+			Phone:     decoded.Phone,
+			CreatedAt: time.Unix(0, decoded.CreatedAt),
+		},
+	}, nil
+}
+
+// After tells if company goes after the cursor, nil cursor is
+// before everything.
+func (c *Cursor) After(company *models.Company) bool {
+	if c == nil {
+		return true
+	}
+	return Compare(company, &c.Company, c.Keyset) > 0
+}
+
+// Page trims results fetched with limit+1 back to limit and returns
+// the token for the next page, or empty token if this is the last one.
+func Page(results []*models.Company, keyset []SortField, limit uint64) ([]*models.Company, string) {
+	if limit == 0 || uint64(len(results)) <= limit {
+		return results, ""
+	}
+	results = results[:limit]
+	return results, Cursor{keyset, *results[len(results)-1]}.Encode()
+}
+
+// FetchLimit is the limit backends query with, one extra company
+// tells whether there is a next page.
+func FetchLimit(limit uint64) uint64 {
+	if limit == 0 {
+		return 0
+	}
+	return limit + 1
+}
+
+// eachPageSize is how many companies EachPage loads at a time.
+const eachPageSize = 500
+
+// EachPage implements Store.Each over Search, loading a page at a time
+// so that slow fn doesn't hold locks or connections of the store.
+func EachPage(
+	ctx context.Context,
+	s Store,
+	query SearchFilters,
+	sort []SortField,
+	fn func(*models.Company) error,
+) error {
+	var cursor string
+	for {
+		results, next, err := s.Search(ctx, query, sort, cursor, eachPageSize)
+		if err != nil {
+			return err
+		}
+		for _, company := range results {
+			if err = fn(company); err != nil {
+				return err
+			}
+		}
+		if len(next) < 1 {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+)
+
+// StringFilter matches a string field, every set operator must
+// match and zero filter matches anything.
+type StringFilter struct {
+	Eq     *string
+	In     []string
+	Prefix *string
+	// Contains matches a substring ignoring case.
+	Contains *string
+}
+
+func (f StringFilter) IsZero() bool {
+	return f.Eq == nil && f.In == nil && f.Prefix == nil && f.Contains == nil
+}
+
+func (f StringFilter) Matches(value string) bool {
+	if f.Eq != nil && value != *f.Eq {
+		return false
+	}
+	if f.In != nil && !containsString(f.In, value) {
+		return false
+	}
+	if f.Prefix != nil && !strings.HasPrefix(value, *f.Prefix) {
+		return false
+	}
+	if f.Contains != nil && !strings.Contains(strings.ToLower(value), strings.ToLower(*f.Contains)) {
+		return false
+	}
+	return true
+}
+
+// TimeRange matches times strictly between After and Before, a missing
+// time only matches the zero range.
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
+}
+
+func (r TimeRange) IsZero() bool {
+	return r.After == nil && r.Before == nil
+}
+
+func (r TimeRange) Matches(value *time.Time) bool {
+	if r.IsZero() {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	if r.After != nil && !value.After(*r.After) {
+		return false
+	}
+	if r.Before != nil && !value.Before(*r.Before) {
+		return false
+	}
+	return true
+}
+
+type SearchFilters struct {
+	Name      StringFilter
+	Code      StringFilter
+	Country   StringFilter
+	Website   StringFilter
+	Phone     StringFilter
+	CreatedAt TimeRange
+	UpdatedAt TimeRange
+	// Text matches companies having any of the text tokens
+	// in their name, code or website.
+	Text *string
+}
+
+// Matches is the reference implementation of the filters for
+// backends filtering companies in process.
+func (f SearchFilters) Matches(company *models.Company) bool {
+	return f.Name.Matches(company.Name) &&
+		f.Code.Matches(company.Code) &&
+		f.Country.Matches(company.Country) &&
+		f.Website.Matches(company.Website) &&
+		f.Phone.Matches(company.Phone) &&
+		f.CreatedAt.Matches(&company.CreatedAt) &&
+		f.UpdatedAt.Matches(company.UpdatedAt) &&
+		(f.Text == nil || TextScore(company, TextTerms(*f.Text)) > 0)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
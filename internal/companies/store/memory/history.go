@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type HistoryStore struct {
+	mu      sync.RWMutex
+	entries map[string][]*models.HistoryEntry
+}
+
+func NewHistoryStore() *HistoryStore {
+	return &HistoryStore{
+		entries: map[string][]*models.HistoryEntry{},
+	}
+}
+
+func (s *HistoryStore) InsertHistory(ctx context.Context, entry *models.HistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.CreatedAt = time.Now()
+	stored := *entry
+	s.entries[entry.CompanyID] = append(s.entries[entry.CompanyID], &stored)
+	return nil
+}
+
+func (s *HistoryStore) SearchHistory(
+	ctx context.Context,
+	companyID string,
+	skip, limit uint64,
+) ([]*models.HistoryEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := s.entries[companyID]
+	if skip >= uint64(len(entries)) {
+		return nil, nil
+	}
+	entries = entries[skip:]
+	if limit > 0 && limit < uint64(len(entries)) {
+		entries = entries[:limit]
+	}
+
+	results := make([]*models.HistoryEntry, len(entries))
+	for idx, entry := range entries {
+		result := *entry
+		results[idx] = &result
+	}
+	return results, nil
+}
+
+func (s *HistoryStore) HistoryAt(
+	ctx context.Context,
+	companyID string,
+	at time.Time,
+) (*models.HistoryEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := s.entries[companyID]
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		if !entries[idx].CreatedAt.After(at) {
+			entry := *entries[idx]
+			return &entry, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
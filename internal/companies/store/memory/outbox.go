@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type OutboxStore struct {
+	mu     sync.RWMutex
+	events []*models.Event
+}
+
+func NewOutboxStore() *OutboxStore {
+	return &OutboxStore{}
+}
+
+func (s *OutboxStore) InsertEvent(ctx context.Context, event *models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.CreatedAt = time.Now()
+	event.DeliveredAt = nil
+	stored := *event
+	s.events = append(s.events, &stored)
+	return nil
+}
+
+func (s *OutboxStore) PendingEvents(ctx context.Context, limit uint64) ([]*models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*models.Event
+	for _, event := range s.events {
+		if event.DeliveredAt != nil {
+			continue
+		}
+		result := *event
+		results = append(results, &result)
+		if limit > 0 && uint64(len(results)) >= limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (s *OutboxStore) MarkDelivered(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range s.events {
+		if event.ID == id && event.DeliveredAt == nil {
+			now := time.Now()
+			event.DeliveredAt = &now
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
@@ -0,0 +1,405 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type Store struct {
+	mu        sync.RWMutex
+	companies map[string]*models.Company
+	order     []string
+	// terms is the inverted text index, company ids by term.
+	terms map[string]map[string]struct{}
+}
+
+func NewStore() *Store {
+	return &Store{
+		companies: map[string]*models.Company{},
+		terms:     map[string]map[string]struct{}{},
+	}
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*models.Company, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	company, ok := s.live(id)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyCompany(company), nil
+}
+
+func (s *Store) GetAny(ctx context.Context, id string) (*models.Company, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	company, ok := s.companies[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyCompany(company), nil
+}
+
+func (s *Store) GetMany(ctx context.Context, ids []string) ([]*models.Company, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*models.Company
+	for _, id := range ids {
+		if company, ok := s.live(id); ok {
+			results = append(results, copyCompany(company))
+		}
+	}
+	return results, nil
+}
+
+func (s *Store) Insert(ctx context.Context, company *models.Company) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(company.ID, company.Code, company.Country); err != nil {
+		return err
+	}
+
+	company.CreatedAt = time.Now()
+	company.UpdatedAt = nil
+	company.DeletedAt = nil
+	company.Version = 1
+	if existing, exists := s.companies[company.ID]; exists {
+		s.unindexTerms(existing)
+	} else {
+		s.order = append(s.order, company.ID)
+	}
+	s.companies[company.ID] = copyCompany(company)
+	s.indexTerms(company)
+	return nil
+}
+
+func (s *Store) Update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	fields store.CompanyOptFields,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	company, ok := s.live(id)
+	if !ok {
+		return store.ErrNotFound
+	}
+	if version != store.AnyVersion && company.Version != version {
+		return store.ErrConflict
+	}
+	if fields.Code != nil || fields.Country != nil {
+		code, country := company.Code, company.Country
+		if fields.Code != nil {
+			code = *fields.Code
+		}
+		if fields.Country != nil {
+			country = *fields.Country
+		}
+		if err := s.checkUnique(id, code, country); err != nil {
+			return err
+		}
+	}
+
+	s.update(company, fields, time.Now())
+	return nil
+}
+
+func (s *Store) update(company *models.Company, fields store.CompanyOptFields, now time.Time) {
+	s.unindexTerms(company)
+	defer s.indexTerms(company)
+
+	company.UpdatedAt = &now
+	company.Version++
+	fields.Apply(company)
+}
+
+func (s *Store) Delete(ctx context.Context, id string, version uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	company, ok := s.live(id)
+	if !ok {
+		return store.ErrNotFound
+	}
+	if version != store.AnyVersion && company.Version != version {
+		return store.ErrConflict
+	}
+	now := time.Now()
+	company.DeletedAt = &now
+	company.UpdatedAt = &now
+	company.Version++
+	return nil
+}
+
+func (s *Store) UpdateMany(
+	ctx context.Context,
+	query store.SearchFilters,
+	fields store.CompanyOptFields,
+) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchLive(query)
+	if fields.Code != nil || fields.Country != nil {
+		// Updated companies must not collide with the
+		// others nor with each other.
+		keys := map[[2]string]bool{}
+		for _, company := range matched {
+			updated := *company
+			fields.Apply(&updated)
+			key := [2]string{updated.Code, updated.Country}
+			if keys[key] {
+				return 0, store.ErrDuplicate
+			}
+			keys[key] = true
+		}
+		for _, company := range s.companies {
+			if _, ok := matched[company.ID]; !ok && keys[[2]string{company.Code, company.Country}] {
+				return 0, store.ErrDuplicate
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, company := range matched {
+		s.update(company, fields, now)
+	}
+	return uint64(len(matched)), nil
+}
+
+func (s *Store) DeleteMany(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchLive(query)
+	now := time.Now()
+	for _, company := range matched {
+		company.DeletedAt = &now
+		company.UpdatedAt = &now
+		company.Version++
+	}
+	return uint64(len(matched)), nil
+}
+
+func (s *Store) matchLive(query store.SearchFilters) map[string]*models.Company {
+	matched := map[string]*models.Company{}
+	for id, company := range s.companies {
+		if company.DeletedAt == nil && query.Matches(company) {
+			matched[id] = company
+		}
+	}
+	return matched
+}
+
+func (s *Store) Search(
+	ctx context.Context,
+	query store.SearchFilters,
+	order []store.SortField,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	keyset := store.KeysetSort(order)
+	after, err := store.DecodeCursor(cursor, keyset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := s.scan(func(company *models.Company) bool {
+		return company.DeletedAt == nil && query.Matches(company) && after.After(company)
+	}, 0, 0)
+	sort.Slice(results, func(i, j int) bool {
+		return store.Compare(results[i], results[j], keyset) < 0
+	})
+	results, next := store.Page(results, keyset, limit)
+	return results, next, nil
+}
+
+func (s *Store) Each(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	fn func(*models.Company) error,
+) error {
+	return store.EachPage(ctx, s, query, sort, fn)
+}
+
+func (s *Store) Count(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count uint64
+	for _, company := range s.companies {
+		if company.DeletedAt == nil && query.Matches(company) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) TextSearch(
+	ctx context.Context,
+	query store.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*store.ScoredCompany, string, error) {
+	after, err := store.DecodeTextCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if query.Text == nil {
+		return nil, "", nil
+	}
+	terms := store.TextTerms(*query.Text)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		candidates []*models.Company
+		seen       = map[string]bool{}
+	)
+	for _, term := range terms {
+		for id := range s.terms[term] {
+			company := s.companies[id]
+			if seen[id] || company.DeletedAt != nil || !query.Matches(company) {
+				continue
+			}
+			seen[id] = true
+			candidates = append(candidates, copyCompany(company))
+		}
+	}
+	results, next := store.PageText(store.RankText(candidates, terms, after), limit)
+	return results, next, nil
+}
+
+func (s *Store) SearchDeleted(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.scan(func(company *models.Company) bool {
+		return company.DeletedAt != nil
+	}, skip, limit), nil
+}
+
+func (s *Store) Restore(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	company, ok := s.companies[id]
+	if !ok || company.DeletedAt == nil {
+		return store.ErrNotFound
+	}
+	now := time.Now()
+	company.DeletedAt = nil
+	company.UpdatedAt = &now
+	company.Version++
+	return nil
+}
+
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		purged uint64
+		order  = s.order[:0]
+	)
+	for _, id := range s.order {
+		company := s.companies[id]
+		if company.DeletedAt != nil && company.DeletedAt.Before(deletedBefore) {
+			s.unindexTerms(company)
+			delete(s.companies, id)
+			purged++
+			continue
+		}
+		order = append(order, id)
+	}
+	s.order = order
+	return purged, nil
+}
+
+func (s *Store) checkUnique(id, code, country string) error {
+	for _, company := range s.companies {
+		if company.ID != id && company.Code == code && company.Country == country {
+			return &store.DuplicateError{ExistingID: company.ID}
+		}
+	}
+	return nil
+}
+
+func (s *Store) indexTerms(company *models.Company) {
+	for _, term := range store.CompanyTerms(company) {
+		ids, ok := s.terms[term]
+		if !ok {
+			ids = map[string]struct{}{}
+			s.terms[term] = ids
+		}
+		ids[company.ID] = struct{}{}
+	}
+}
+
+func (s *Store) unindexTerms(company *models.Company) {
+	for _, term := range store.CompanyTerms(company) {
+		delete(s.terms[term], company.ID)
+		if len(s.terms[term]) < 1 {
+			delete(s.terms, term)
+		}
+	}
+}
+
+func (s *Store) live(id string) (*models.Company, bool) {
+	company, ok := s.companies[id]
+	if !ok || company.DeletedAt != nil {
+		return nil, false
+	}
+	return company, true
+}
+
+func (s *Store) scan(match func(*models.Company) bool, skip, limit uint64) []*models.Company {
+	var (
+		results []*models.Company
+		matched uint64
+	)
+	for _, id := range s.order {
+		company := s.companies[id]
+		if !match(company) {
+			continue
+		}
+		matched++
+		if matched <= skip {
+			continue
+		}
+		results = append(results, copyCompany(company))
+		if limit > 0 && uint64(len(results)) >= limit {
+			break
+		}
+	}
+	return results
+}
+
+func copyCompany(company *models.Company) *models.Company {
+	c := *company
+	if company.UpdatedAt != nil {
+		updatedAt := *company.UpdatedAt
+		c.UpdatedAt = &updatedAt
+	}
+	if company.DeletedAt != nil {
+		deletedAt := *company.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
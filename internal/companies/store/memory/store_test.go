@@ -0,0 +1,157 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/storetest"
+)
+
+func insertTestCompanies(t *testing.T, s *Store) {
+	companies := storetest.Companies()
+	for idx := range companies {
+		assert.NoError(t, s.Insert(context.Background(), &companies[idx]))
+	}
+}
+
+func TestInsertGet(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+
+	company, err := s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Second", company.Name)
+	assert.False(t, company.CreatedAt.IsZero())
+	assert.Nil(t, company.UpdatedAt)
+
+	_, err = s.Get(context.Background(), "4")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestUpdate(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+
+	name := "Updated"
+	assert.NoError(t, s.Update(context.Background(), "1", store.AnyVersion, store.CompanyOptFields{Name: &name}))
+
+	company, err := s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated", company.Name)
+	assert.Equal(t, "FC", company.Code)
+	assert.NotNil(t, company.UpdatedAt)
+
+	website, cleared := "http://first.example/", ""
+	assert.NoError(t, s.Update(context.Background(), "1", store.AnyVersion, store.CompanyOptFields{Website: &website}))
+	assert.NoError(t, s.Update(context.Background(), "1", store.AnyVersion, store.CompanyOptFields{Website: &cleared}))
+	company, err = s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Empty(t, company.Website)
+	assert.Equal(t, "Updated", company.Name)
+
+	err = s.Update(context.Background(), "4", store.AnyVersion, store.CompanyOptFields{Name: &name})
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestDelete(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	_, err := s.Get(context.Background(), "1")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, s.Delete(context.Background(), "1", store.AnyVersion))
+}
+
+func TestSearch(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.Search(t, s)
+}
+
+func TestSearchCursorStable(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.SearchCursorStable(t, s)
+}
+
+func TestSearchOperators(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.SearchOperators(t, s)
+}
+
+func TestTextSearch(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.TextSearch(t, s)
+}
+
+func TestSearchSorted(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.SearchSorted(t, s)
+}
+
+func TestCount(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.Count(t, s)
+}
+
+func TestGetMany(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.GetMany(t, s)
+}
+
+func TestEach(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.Each(t, s)
+}
+
+func TestUpdateMany(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.UpdateMany(t, s)
+}
+
+func TestDeleteMany(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.DeleteMany(t, s)
+}
+
+func TestVersionConflict(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.VersionConflict(t, s)
+}
+
+func TestTrash(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.Trash(t, s)
+}
+
+func TestHistory(t *testing.T) {
+	storetest.History(t, NewHistoryStore())
+}
+
+func TestUniqueCodeInCountry(t *testing.T) {
+	s := NewStore()
+	insertTestCompanies(t, s)
+	storetest.UniqueCodeInCountry(t, s)
+}
+
+func TestOutbox(t *testing.T) {
+	storetest.Outbox(t, NewOutboxStore())
+}
+
+func TestWebhooks(t *testing.T) {
+	storetest.Webhooks(t, NewWebhookStore())
+}
@@ -0,0 +1,200 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type WebhookStore struct {
+	mu            sync.RWMutex
+	subscriptions []*models.Subscription
+	deliveries    []*models.Delivery
+}
+
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{}
+}
+
+func (s *WebhookStore) InsertSubscription(ctx context.Context, subscription *models.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription.CreatedAt = time.Now()
+	subscription.UpdatedAt = nil
+	s.subscriptions = append(s.subscriptions, copySubscription(subscription))
+	return nil
+}
+
+func (s *WebhookStore) GetSubscription(ctx context.Context, id string) (*models.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, subscription := range s.subscriptions {
+		if subscription.ID == id {
+			return copySubscription(subscription), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *WebhookStore) ListSubscriptions(ctx context.Context) ([]*models.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*models.Subscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		results = append(results, copySubscription(subscription))
+	}
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.subscriptions {
+		if stored.ID == subscription.ID {
+			now := time.Now()
+			stored.URL = subscription.URL
+			stored.EventTypes = append([]string{}, subscription.EventTypes...)
+			stored.Secret = subscription.Secret
+			stored.UpdatedAt = &now
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *WebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx, subscription := range s.subscriptions {
+		if subscription.ID != id {
+			continue
+		}
+		s.subscriptions = append(s.subscriptions[:idx], s.subscriptions[idx+1:]...)
+		kept := s.deliveries[:0]
+		for _, delivery := range s.deliveries {
+			if delivery.SubscriptionID != id {
+				kept = append(kept, delivery)
+			}
+		}
+		s.deliveries = kept
+		return nil
+	}
+	return store.ErrNotFound
+}
+
+func (s *WebhookStore) InsertDelivery(ctx context.Context, delivery *models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.deliveries {
+		if stored.ID == delivery.ID {
+			return store.ErrDuplicate
+		}
+	}
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = nil
+	stored := *delivery
+	s.deliveries = append(s.deliveries, &stored)
+	return nil
+}
+
+func (s *WebhookStore) GetDelivery(ctx context.Context, id string) (*models.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.ID == id {
+			result := *delivery
+			return &result, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *WebhookStore) DueDeliveries(
+	ctx context.Context,
+	now time.Time,
+	limit uint64,
+) ([]*models.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*models.Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		result := *delivery
+		results = append(results, &result)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].NextAttemptAt.Before(results[j].NextAttemptAt)
+	})
+	if limit > 0 && limit < uint64(len(results)) {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.deliveries {
+		if stored.ID == delivery.ID {
+			now := time.Now()
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.NextAttemptAt = delivery.NextAttemptAt
+			stored.LastStatusCode = delivery.LastStatusCode
+			stored.LastError = delivery.LastError
+			stored.UpdatedAt = &now
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *WebhookStore) SearchDeliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*models.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		matched uint64
+		results []*models.Delivery
+	)
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID != subscriptionID {
+			continue
+		}
+		matched++
+		if matched <= skip {
+			continue
+		}
+		result := *delivery
+		results = append(results, &result)
+		if limit > 0 && uint64(len(results)) >= limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+func copySubscription(subscription *models.Subscription) *models.Subscription {
+	result := *subscription
+	result.EventTypes = append([]string{}, subscription.EventTypes...)
+	return &result
+}
@@ -0,0 +1,20 @@
+package mongo
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// IsRetryable tells transient driver errors, like lost connections or
+// no primary during an election, that may pass when tried again.
+func IsRetryable(err error) bool {
+	var selection topology.ServerSelectionError
+	if mongo.IsNetworkError(err) || errors.As(err, &selection) {
+		return true
+	}
+	var labeled interface{ HasErrorLabel(string) bool }
+	return errors.As(err, &labeled) &&
+		(labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError"))
+}
@@ -0,0 +1,81 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type HistoryStore struct {
+	col *mongo.Collection
+}
+
+func NewHistoryStore(col *mongo.Collection) *HistoryStore {
+	return &HistoryStore{col}
+}
+
+func (s *HistoryStore) InsertHistory(ctx context.Context, entry *models.HistoryEntry) error {
+	// BSON dates keep milliseconds only, truncate upfront so the
+	// entry matches what HistoryAt compares against later.
+	entry.CreatedAt = time.Now().Truncate(time.Millisecond)
+	_, err := s.col.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *HistoryStore) SearchHistory(
+	ctx context.Context,
+	companyID string,
+	skip, limit uint64,
+) ([]*models.HistoryEntry, error) {
+	cursor, err := s.col.Find(
+		ctx,
+		bson.M{"company_id": companyID},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.HistoryEntry
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *HistoryStore) HistoryAt(
+	ctx context.Context,
+	companyID string,
+	at time.Time,
+) (*models.HistoryEntry, error) {
+	query := bson.M{
+		"company_id": companyID,
+		"created_at": bson.M{"$lte": at},
+	}
+	result := s.col.FindOne(
+		ctx,
+		query,
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}),
+	)
+	err := result.Err()
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entry models.HistoryEntry
+	if err = result.Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
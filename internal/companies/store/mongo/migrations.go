@@ -0,0 +1,284 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/mongomigrate"
+)
+
+const (
+	CompaniesCollection = "companies"
+	HistoryCollection   = "companies_history"
+	OutboxCollection    = "companies_outbox"
+
+	WebhooksCollection          = "webhooks"
+	WebhookDeliveriesCollection = "webhook_deliveries"
+)
+
+// Migrations lists schema changes for the companies collections,
+// versions must never be reused once released.
+func Migrations() []mongomigrate.Migration {
+	return []mongomigrate.Migration{
+		{
+			Version:     1,
+			Description: "companies indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(CompaniesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "id", Value: 1}},
+						Options: options.Index().SetName("id_unique").SetUnique(true),
+					},
+					{
+						Keys:    bson.D{{Key: "country", Value: 1}, {Key: "code", Value: 1}},
+						Options: options.Index().SetName("country_code_unique").SetUnique(true),
+					},
+					{
+						Keys:    bson.D{{Key: "name", Value: 1}},
+						Options: options.Index().SetName("name"),
+					},
+					{
+						Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+						Options: options.Index().SetName("deleted_at"),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(CompaniesCollection),
+					"id_unique", "country_code_unique", "name", "deleted_at")
+			},
+		},
+		{
+			Version:     2,
+			Description: "companies history indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(HistoryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: bson.D{
+						{Key: "company_id", Value: 1},
+						{Key: "created_at", Value: 1},
+						{Key: "_id", Value: 1},
+					},
+					Options: options.Index().SetName("company_id_created_at"),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(HistoryCollection), "company_id_created_at")
+			},
+		},
+		{
+			Version:     3,
+			Description: "backfill companies version and deleted_at",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				col := db.Collection(CompaniesCollection)
+				_, err := col.UpdateMany(ctx,
+					bson.M{"version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"version": int64(1)}},
+				)
+				if err != nil {
+					return err
+				}
+				_, err = col.UpdateMany(ctx,
+					bson.M{"deleted_at": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"deleted_at": nil}},
+				)
+				return err
+			},
+			// Backfilled values are valid for the old schema too.
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return nil
+			},
+		},
+		{
+			Version:     4,
+			Description: "companies schema validator",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				return setValidator(ctx, db, CompaniesCollection, companiesSchema())
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return setValidator(ctx, db, CompaniesCollection, bson.M{})
+			},
+		},
+		{
+			Version:     5,
+			Description: "companies listing order index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(CompaniesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+					Options: options.Index().SetName("created_at_id"),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(CompaniesCollection), "created_at_id")
+			},
+		},
+		{
+			Version:     6,
+			Description: "companies name collation index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(CompaniesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "name", Value: 1}, {Key: "id", Value: 1}},
+					Options: options.Index().SetName("name_id_en").SetCollation(nameCollation),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(CompaniesCollection), "name_id_en")
+			},
+		},
+		{
+			Version:     7,
+			Description: "companies text index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				keys, weights := bson.D{}, bson.D{}
+				for _, field := range store.TextWeights {
+					keys = append(keys, bson.E{Key: field.Field, Value: "text"})
+					weights = append(weights, bson.E{Key: field.Field, Value: field.Weight})
+				}
+				_, err := db.Collection(CompaniesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: keys,
+					// No stemming or stop words, same as store.Tokenize.
+					Options: options.Index().
+						SetName("text").
+						SetWeights(weights).
+						SetDefaultLanguage("none"),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(CompaniesCollection), "text")
+			},
+		},
+		{
+			Version:     8,
+			Description: "companies outbox collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// Collections can't be created implicitly within
+				// transactions before MongoDB 4.4.
+				names, err := db.ListCollectionNames(ctx, bson.M{"name": OutboxCollection})
+				if err != nil {
+					return err
+				}
+				if len(names) < 1 {
+					if err = db.CreateCollection(ctx, OutboxCollection); err != nil {
+						return err
+					}
+				}
+				_, err = db.Collection(OutboxCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "id", Value: 1}},
+						Options: options.Index().SetName("id_unique").SetUnique(true),
+					},
+					{
+						Keys: bson.D{
+							{Key: "delivered_at", Value: 1},
+							{Key: "created_at", Value: 1},
+							{Key: "_id", Value: 1},
+						},
+						Options: options.Index().SetName("pending"),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return db.Collection(OutboxCollection).Drop(ctx)
+			},
+		},
+		{
+			Version:     9,
+			Description: "webhooks indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(WebhooksCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "id", Value: 1}},
+					Options: options.Index().SetName("id_unique").SetUnique(true),
+				})
+				if err != nil {
+					return err
+				}
+				_, err = db.Collection(WebhookDeliveriesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "id", Value: 1}},
+						Options: options.Index().SetName("id_unique").SetUnique(true),
+					},
+					{
+						Keys: bson.D{
+							{Key: "subscription_id", Value: 1},
+							{Key: "created_at", Value: 1},
+							{Key: "_id", Value: 1},
+						},
+						Options: options.Index().SetName("subscription"),
+					},
+					{
+						Keys: bson.D{
+							{Key: "status", Value: 1},
+							{Key: "next_attempt_at", Value: 1},
+							{Key: "_id", Value: 1},
+						},
+						Options: options.Index().SetName("due"),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				if err := db.Collection(WebhookDeliveriesCollection).Drop(ctx); err != nil {
+					return err
+				}
+				return db.Collection(WebhooksCollection).Drop(ctx)
+			},
+		},
+	}
+}
+
+func companiesSchema() bson.M {
+	str := bson.M{"bsonType": "string"}
+	nullableDate := bson.M{"bsonType": bson.A{"date", "null"}}
+	return bson.M{
+		"$jsonSchema": bson.M{
+			"bsonType": "object",
+			"required": bson.A{"id", "name", "code", "country", "version", "created_at"},
+			"properties": bson.M{
+				"id":         str,
+				"name":       str,
+				"code":       str,
+				"country":    str,
+				"website":    str,
+				"phone":      str,
+				"version":    bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+				"created_at": bson.M{"bsonType": "date"},
+				"updated_at": nullableDate,
+				"deleted_at": nullableDate,
+			},
+		},
+	}
+}
+
+// setValidator attaches the validator to the collection,
+// creating the collection first if it doesn't exist yet.
+func setValidator(ctx context.Context, db *mongo.Database, name string, validator bson.M) error {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if len(names) < 1 {
+		return db.CreateCollection(ctx, name, options.CreateCollection().SetValidator(validator))
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: validator},
+	}).Err()
+}
+
+func dropIndexes(ctx context.Context, col *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		if _, err := col.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type OutboxStore struct {
+	col *mongo.Collection
+}
+
+func NewOutboxStore(col *mongo.Collection) *OutboxStore {
+	return &OutboxStore{col}
+}
+
+// CheckOutbox tells whether db can take the transactions the outbox
+// writes in: those need a replica set, and before MongoDB 4.4 they
+// can't create the outbox collection implicitly.
+func CheckOutbox(ctx context.Context, db *mongo.Database) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+	if hello.SetName == "" {
+		return errors.New("mongo is not a replica set, transactions are unavailable")
+	}
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": OutboxCollection})
+	if err != nil {
+		return err
+	}
+	if len(names) < 1 {
+		return errors.New("collection " + OutboxCollection + " is missing, apply migrations")
+	}
+	return nil
+}
+
+func (s *OutboxStore) InsertEvent(ctx context.Context, event *models.Event) error {
+	event.CreatedAt = time.Now().Truncate(time.Millisecond)
+	event.DeliveredAt = nil
+	_, err := s.col.InsertOne(ctx, event)
+	return err
+}
+
+func (s *OutboxStore) PendingEvents(ctx context.Context, limit uint64) ([]*models.Event, error) {
+	cursor, err := s.col.Find(
+		ctx,
+		bson.M{"delivered_at": nil},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.Event
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *OutboxStore) MarkDelivered(ctx context.Context, id string) error {
+	result, err := s.col.UpdateOne(
+		ctx,
+		bson.M{"id": id, "delivered_at": nil},
+		bson.M{"$set": bson.M{"delivered_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package mongo
 
 import (
 	"context"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
@@ -22,7 +25,8 @@ func NewStore(col *mongo.Collection) *Store {
 
 func (s *Store) Get(ctx context.Context, id string) (*models.Company, error) {
 	query := bson.M{
-		"id": id,
+		"id":         id,
+		"deleted_at": nil,
 	}
 	result := s.col.FindOne(ctx, query)
 	err := result.Err()
@@ -40,21 +44,200 @@ func (s *Store) Get(ctx context.Context, id string) (*models.Company, error) {
 	return &company, nil
 }
 
+func (s *Store) GetAny(ctx context.Context, id string) (*models.Company, error) {
+	var company models.Company
+	err := s.col.FindOne(ctx, bson.M{"id": id}).Decode(&company)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &company, nil
+}
+
+func (s *Store) GetMany(ctx context.Context, ids []string) ([]*models.Company, error) {
+	query := bson.M{
+		"id":         bson.M{"$in": ids},
+		"deleted_at": nil,
+	}
+	return s.find(ctx, query, options.Find())
+}
+
 func (s *Store) Insert(ctx context.Context, company *models.Company) error {
 	company.CreatedAt = time.Now()
 	company.UpdatedAt = nil
+	company.DeletedAt = nil
+	company.Version = 1
 	_, err := s.col.InsertOne(ctx, company)
+	if mongo.IsDuplicateKeyError(err) {
+		return s.duplicateError(ctx, company.ID, company.Code, company.Country)
+	}
 	return err
 }
 
 func (s *Store) Update(
 	ctx context.Context,
 	id string,
+	version uint64,
 	fields store.CompanyOptFields,
 ) error {
+	query := versionedQuery(id, version)
+	result, err := s.col.UpdateOne(ctx, query, bson.M{
+		"$set": updatePatch(fields),
+		"$inc": bson.M{"version": 1},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		current, err := s.Get(withoutSession(ctx), id)
+		if err != nil {
+			return err
+		}
+		code, country := current.Code, current.Country
+		if fields.Code != nil {
+			code = *fields.Code
+		}
+		if fields.Country != nil {
+			country = *fields.Country
+		}
+		return s.duplicateError(ctx, id, code, country)
+	} else if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return s.missedError(ctx, id)
+	}
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string, version uint64) error {
+	now := time.Now()
+	result, err := s.col.UpdateOne(ctx, versionedQuery(id, version), bson.M{
+		"$set": bson.M{
+			"deleted_at": now,
+			"updated_at": now,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return s.missedError(ctx, id)
+	}
+	return nil
+}
+
+func (s *Store) UpdateMany(
+	ctx context.Context,
+	query store.SearchFilters,
+	fields store.CompanyOptFields,
+) (uint64, error) {
+	result, err := s.col.UpdateMany(ctx, searchFilter(query), bson.M{
+		"$set": updatePatch(fields),
+		"$inc": bson.M{"version": 1},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, store.ErrDuplicate
+	} else if err != nil {
+		return 0, err
+	}
+	return uint64(result.MatchedCount), nil
+}
+
+func (s *Store) DeleteMany(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	now := time.Now()
+	result, err := s.col.UpdateMany(ctx, searchFilter(query), bson.M{
+		"$set": bson.M{
+			"deleted_at": now,
+			"updated_at": now,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(result.MatchedCount), nil
+}
+
+func (s *Store) SearchDeleted(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	return s.find(
+		ctx,
+		bson.M{"deleted_at": bson.M{"$ne": nil}},
+		options.Find().SetSkip(int64(skip)).SetLimit(int64(limit)),
+	)
+}
+
+func (s *Store) Restore(ctx context.Context, id string) error {
+	query := bson.M{
+		"id":         id,
+		"deleted_at": bson.M{"$ne": nil},
+	}
+	result, err := s.col.UpdateOne(ctx, query, bson.M{
+		"$set": bson.M{
+			"deleted_at": nil,
+			"updated_at": time.Now(),
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	result, err := s.col.DeleteMany(ctx, bson.M{
+		"deleted_at": bson.M{"$lt": deletedBefore},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(result.DeletedCount), nil
+}
+
+func (s *Store) duplicateError(ctx context.Context, id, code, country string) error {
 	query := bson.M{
-		"id": id,
+		"country": country,
+		"code":    code,
+		"id":      bson.M{"$ne": id},
 	}
+	var existing models.Company
+	err := s.col.FindOne(withoutSession(ctx), query).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		// Only the transaction that failed sees its own writes,
+		// the duplicate was inserted earlier in it.
+		return &store.DuplicateError{}
+	} else if err != nil {
+		return err
+	}
+	return &store.DuplicateError{ExistingID: existing.ID}
+}
+
+func (s *Store) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := s.col.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// withoutSession detaches ctx from its transaction, a failed write
+// aborts the transaction and reads within it fail after that.
+func withoutSession(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, nil)
+}
+
+func updatePatch(fields store.CompanyOptFields) bson.M {
 	patch := bson.M{
 		"updated_at": time.Now(),
 	}
@@ -73,60 +256,282 @@ func (s *Store) Update(
 	if fields.Website != nil {
 		patch["website"] = *fields.Website
 	}
+	return patch
+}
 
-	result, err := s.col.UpdateOne(ctx, query, bson.M{
-		"$set": patch,
-	})
-	if err != nil {
-		return err
+func versionedQuery(id string, version uint64) bson.M {
+	query := bson.M{
+		"id":         id,
+		"deleted_at": nil,
 	}
-	if result.ModifiedCount < 1 {
-		return store.ErrNotFound
+	if version != store.AnyVersion {
+		query["version"] = version
 	}
-	return nil
+	return query
 }
 
-func (s *Store) Delete(ctx context.Context, id string) error {
+// missedError tells a missing company from a version mismatch
+// when a conditional write didn't match any documents.
+func (s *Store) missedError(ctx context.Context, id string) error {
 	query := bson.M{
-		"id": id,
+		"id":         id,
+		"deleted_at": nil,
 	}
-	result, err := s.col.DeleteOne(ctx, query)
+	count, err := s.col.CountDocuments(ctx, query, options.Count().SetLimit(1))
 	if err != nil {
 		return err
 	}
-	if result.DeletedCount < 1 {
-		return store.ErrNotFound
+	if count > 0 {
+		return store.ErrConflict
 	}
-	return nil
+	return store.ErrNotFound
 }
 
 func (s *Store) Search(
 	ctx context.Context,
-	query store.CompanyOptFields,
-	skip, limit uint64,
-) ([]*models.Company, error) {
-	filter := bson.M{}
-	if query.Name != nil {
-		filter["name"] = query.Name
+	query store.SearchFilters,
+	sort []store.SortField,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	keyset := store.KeysetSort(sort)
+	after, err := store.DecodeCursor(cursor, keyset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter := searchFilter(query)
+	if after != nil {
+		filter["$or"] = keysetFilter(after)
 	}
-	if query.Code != nil {
-		filter["code"] = query.Code
+
+	opts := sortOptions(keyset).SetLimit(int64(store.FetchLimit(limit)))
+	results, err := s.find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
 	}
-	if query.Country != nil {
-		filter["country"] = query.Country
+	results, next := store.Page(results, keyset, limit)
+	return results, next, nil
+}
+
+// Each iterates the mongo cursor, documents are fetched in
+// batches as fn consumes them.
+func (s *Store) Each(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	fn func(*models.Company) error,
+) error {
+	cursor, err := s.col.Find(ctx, searchFilter(query), sortOptions(store.KeysetSort(sort)))
+	if err != nil {
+		return err
 	}
-	if query.Phone != nil {
-		filter["phone"] = query.Phone
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var company models.Company
+		if err = cursor.Decode(&company); err != nil {
+			return err
+		}
+		if err = fn(&company); err != nil {
+			return err
+		}
 	}
-	if query.Website != nil {
-		filter["website"] = query.Website
+	return cursor.Err()
+}
+
+func sortOptions(keyset []store.SortField) *options.FindOptions {
+	opts := options.Find().SetSort(sortDocument(keyset))
+	for _, field := range keyset {
+		if field.Field == store.SortFieldName {
+			opts.SetCollation(nameCollation)
+			break
+		}
 	}
+	return opts
+}
 
-	cursor, err := s.col.Find(
-		ctx,
-		query,
-		options.Find().SetSkip(int64(skip)).SetLimit(int64(limit)),
+// nameCollation orders names by locale rules instead of code points,
+// the companies name index is built with the same collation.
+var nameCollation = &options.Collation{Locale: "en"}
+
+// keysetFilter matches documents after the cursor, expanding the keyset
+// into [{a: {$gt}}, {a: v, b: {$gt}}, ...] with $lt for descending fields.
+func keysetFilter(after *store.Cursor) bson.A {
+	var (
+		alternatives bson.A
+		equal        = bson.M{}
 	)
+	for _, field := range after.Keyset {
+		value := sortValue(&after.Company, field.Field)
+		comparison := "$gt"
+		if field.Desc {
+			comparison = "$lt"
+		}
+
+		alternative := bson.M{field.Field: bson.M{comparison: value}}
+		for key, value := range equal {
+			alternative[key] = value
+		}
+		alternatives = append(alternatives, alternative)
+		equal[field.Field] = value
+	}
+	return alternatives
+}
+
+func sortDocument(keyset []store.SortField) bson.D {
+	document := make(bson.D, len(keyset))
+	for idx, field := range keyset {
+		direction := 1
+		if field.Desc {
+			direction = -1
+		}
+		document[idx] = bson.E{Key: field.Field, Value: direction}
+	}
+	return document
+}
+
+func sortValue(company *models.Company, field string) interface{} {
+	switch field {
+	case store.SortFieldCreatedAt:
+		return company.CreatedAt
+	case store.SortFieldName:
+		return company.Name
+	case store.SortFieldCode:
+		return company.Code
+	case store.SortFieldCountry:
+		return company.Country
+	case store.SortFieldWebsite:
+		return company.Website
+	case store.SortFieldPhone:
+		return company.Phone
+	}
+	return company.ID
+}
+
+func (s *Store) TextSearch(
+	ctx context.Context,
+	query store.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*store.ScoredCompany, string, error) {
+	after, err := store.DecodeTextCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if query.Text == nil || len(store.TextTerms(*query.Text)) < 1 {
+		return nil, "", nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: searchFilter(query)}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+	}
+	if after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"score": bson.M{"$lt": after.Score}},
+			bson.M{"score": after.Score, "id": bson.M{"$gt": after.ID}},
+		}}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "id", Value: 1}}}})
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: int64(store.FetchLimit(limit))}})
+	}
+
+	documents, err := s.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	var scored []struct {
+		models.Company `bson:",inline"`
+		Score          float64 `bson:"score"`
+	}
+	if err = documents.All(ctx, &scored); err != nil {
+		return nil, "", err
+	}
+
+	results := make([]*store.ScoredCompany, len(scored))
+	for idx := range scored {
+		results[idx] = &store.ScoredCompany{Company: &scored[idx].Company, Score: scored[idx].Score}
+	}
+	results, next := store.PageText(results, limit)
+	return results, next, nil
+}
+
+func (s *Store) Count(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	count, err := s.col.CountDocuments(ctx, searchFilter(query))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(count), nil
+}
+
+func searchFilter(query store.SearchFilters) bson.M {
+	var conditions bson.A
+	for _, field := range []struct {
+		name   string
+		filter store.StringFilter
+	}{
+		{"name", query.Name},
+		{"code", query.Code},
+		{"country", query.Country},
+		{"website", query.Website},
+		{"phone", query.Phone},
+	} {
+		filter := field.filter
+		if filter.Eq != nil {
+			conditions = append(conditions, bson.M{field.name: *filter.Eq})
+		}
+		if filter.In != nil {
+			conditions = append(conditions, bson.M{field.name: bson.M{"$in": filter.In}})
+		}
+		if filter.Prefix != nil {
+			conditions = append(conditions, bson.M{field.name: primitive.Regex{
+				Pattern: "^" + regexp.QuoteMeta(*filter.Prefix),
+			}})
+		}
+		if filter.Contains != nil {
+			conditions = append(conditions, bson.M{field.name: primitive.Regex{
+				Pattern: regexp.QuoteMeta(*filter.Contains),
+				Options: "i",
+			}})
+		}
+	}
+	for _, field := range []struct {
+		name   string
+		filter store.TimeRange
+	}{
+		{"created_at", query.CreatedAt},
+		{"updated_at", query.UpdatedAt},
+	} {
+		if field.filter.After != nil {
+			conditions = append(conditions, bson.M{field.name: bson.M{"$gt": *field.filter.After}})
+		}
+		if field.filter.Before != nil {
+			conditions = append(conditions, bson.M{field.name: bson.M{"$lt": *field.filter.Before}})
+		}
+	}
+
+	filter := bson.M{
+		"deleted_at": nil,
+	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	if query.Text != nil {
+		// Joined tokens keep phrases and negations in the
+		// text out of $search syntax.
+		filter["$text"] = bson.M{"$search": strings.Join(store.TextTerms(*query.Text), " ")}
+	}
+	return filter
+}
+
+func (s *Store) find(
+	ctx context.Context,
+	filter bson.M,
+	opts *options.FindOptions,
+) ([]*models.Company, error) {
+	cursor, err := s.col.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,185 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+type WebhookStore struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+func NewWebhookStore(db *mongo.Database) *WebhookStore {
+	return &WebhookStore{
+		subscriptions: db.Collection(WebhooksCollection),
+		deliveries:    db.Collection(WebhookDeliveriesCollection),
+	}
+}
+
+func (s *WebhookStore) InsertSubscription(ctx context.Context, subscription *models.Subscription) error {
+	subscription.CreatedAt = time.Now().Truncate(time.Millisecond)
+	subscription.UpdatedAt = nil
+	_, err := s.subscriptions.InsertOne(ctx, subscription)
+	return err
+}
+
+func (s *WebhookStore) GetSubscription(ctx context.Context, id string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := s.subscriptions.FindOne(ctx, bson.M{"id": id}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *WebhookStore) ListSubscriptions(ctx context.Context) ([]*models.Subscription, error) {
+	cursor, err := s.subscriptions.Find(
+		ctx,
+		bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.Subscription
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	result, err := s.subscriptions.UpdateOne(
+		ctx,
+		bson.M{"id": subscription.ID},
+		bson.M{"$set": bson.M{
+			"url":         subscription.URL,
+			"event_types": subscription.EventTypes,
+			"secret":      subscription.Secret,
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *WebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	result, err := s.subscriptions.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount < 1 {
+		return store.ErrNotFound
+	}
+	_, err = s.deliveries.DeleteMany(ctx, bson.M{"subscription_id": id})
+	return err
+}
+
+func (s *WebhookStore) InsertDelivery(ctx context.Context, delivery *models.Delivery) error {
+	delivery.CreatedAt = time.Now().Truncate(time.Millisecond)
+	delivery.UpdatedAt = nil
+	_, err := s.deliveries.InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		return store.ErrDuplicate
+	}
+	return err
+}
+
+func (s *WebhookStore) GetDelivery(ctx context.Context, id string) (*models.Delivery, error) {
+	var delivery models.Delivery
+	err := s.deliveries.FindOne(ctx, bson.M{"id": id}).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (s *WebhookStore) DueDeliveries(
+	ctx context.Context,
+	now time.Time,
+	limit uint64,
+) ([]*models.Delivery, error) {
+	cursor, err := s.deliveries.Find(
+		ctx,
+		bson.M{
+			"status":          models.DeliveryPending,
+			"next_attempt_at": bson.M{"$lte": now},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.Delivery
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *WebhookStore) UpdateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	result, err := s.deliveries.UpdateOne(
+		ctx,
+		bson.M{"id": delivery.ID},
+		bson.M{"$set": bson.M{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"updated_at":       time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *WebhookStore) SearchDeliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*models.Delivery, error) {
+	cursor, err := s.deliveries.Find(
+		ctx,
+		bson.M{"subscription_id": subscriptionID},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.Delivery
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
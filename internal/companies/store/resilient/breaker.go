@@ -0,0 +1,62 @@
+package resilient
+
+import (
+	"sync"
+	"time"
+)
+
+// halfOpenRetryAfter is suggested to calls refused
+// while a trial call is deciding on the breaker.
+const halfOpenRetryAfter = time.Second
+
+// breaker opens after threshold failures in a row and refuses calls
+// for openFor. Then a single trial call is let through, closing the
+// breaker when it succeeds and opening it again otherwise.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	openFor   time.Duration
+	failures  int
+	// openedAt is zero while the breaker is closed.
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+func newBreaker(threshold int, openFor time.Duration) *breaker {
+	return &breaker{threshold: threshold, openFor: openFor, now: time.Now}
+}
+
+// allow tells whether a call may go through, every allowed call has
+// to be followed by record. Refused calls get the time to retry after.
+func (b *breaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return 0, true
+	}
+	if wait := b.openedAt.Add(b.openFor).Sub(b.now()); wait > 0 {
+		return wait, false
+	}
+	if b.trial {
+		return halfOpenRetryAfter, false
+	}
+	b.trial = true
+	return 0, true
+}
+
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.trial = false
+		return
+	}
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
@@ -0,0 +1,233 @@
+// Package resilient decorates a store.Store to ride out transient
+// failures. Reads are retried with jittered backoff and a circuit
+// breaker stops calling a store that keeps failing, calls fail with
+// *store.UnavailableError meanwhile. Writes aren't retried here, a
+// write applied before its reply was lost would fail when tried again,
+// the mongo driver retries them safely with retryWrites. Delete by id
+// of any version is the exception, it is retried and not finding the
+// company after a failed attempt means that attempt went through.
+package resilient
+
+import (
+	"context"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/pkg/backoff"
+)
+
+type Options struct {
+	// Retryable tells transient errors from the ones that will fail
+	// the same way, only the former are retried and open the breaker.
+	Retryable func(error) bool
+	// Attempts is the number of tries of reads.
+	Attempts int
+	// Backoff before the second attempt, see backoff.Jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold failed calls in a row open the
+	// breaker, it refuses calls for BreakerOpenFor.
+	BreakerThreshold int
+	BreakerOpenFor   time.Duration
+}
+
+type Store struct {
+	next    store.Store
+	opts    Options
+	breaker *breaker
+}
+
+// transactionalStore is returned for stores with transactions,
+// so the decorator is a store.Transactor only when they are.
+type transactionalStore struct {
+	*Store
+}
+
+func NewStore(next store.Store, opts Options) store.Store {
+	s := &Store{next, opts, newBreaker(opts.BreakerThreshold, opts.BreakerOpenFor)}
+	if _, ok := next.(store.Transactor); ok {
+		return &transactionalStore{s}
+	}
+	return s
+}
+
+// call runs fn unless the breaker is open, retryable errors
+// fn returns count as failures of the store.
+func (s *Store) call(fn func() error) error {
+	retryAfter, ok := s.breaker.allow()
+	if !ok {
+		return &store.UnavailableError{RetryAfter: retryAfter}
+	}
+	err := fn()
+	s.breaker.record(err != nil && s.opts.Retryable(err))
+	return err
+}
+
+// retry is call for reads, fn is tried again on retryable
+// errors as long as attempts are left and ctx allows for the backoff.
+func (s *Store) retry(ctx context.Context, fn func() error) error {
+	return s.call(func() error {
+		for attempt := 1; ; attempt++ {
+			err := fn()
+			if err == nil || attempt >= s.opts.Attempts || !s.opts.Retryable(err) {
+				return err
+			}
+			wait := backoff.Jittered(s.opts.Backoff, s.opts.MaxBackoff, attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				return err
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	})
+}
+
+func (s *Store) Get(ctx context.Context, id string) (company *models.Company, err error) {
+	err = s.retry(ctx, func() error {
+		company, err = s.next.Get(ctx, id)
+		return err
+	})
+	return company, err
+}
+
+func (s *Store) GetAny(ctx context.Context, id string) (company *models.Company, err error) {
+	err = s.retry(ctx, func() error {
+		company, err = s.next.GetAny(ctx, id)
+		return err
+	})
+	return company, err
+}
+
+func (s *Store) GetMany(ctx context.Context, ids []string) (results []*models.Company, err error) {
+	err = s.retry(ctx, func() error {
+		results, err = s.next.GetMany(ctx, ids)
+		return err
+	})
+	return results, err
+}
+
+func (s *Store) Insert(ctx context.Context, company *models.Company) error {
+	return s.call(func() error {
+		return s.next.Insert(ctx, company)
+	})
+}
+
+func (s *Store) Update(ctx context.Context, id string, version uint64, fields store.CompanyOptFields) error {
+	return s.call(func() error {
+		return s.next.Update(ctx, id, version, fields)
+	})
+}
+
+func (s *Store) Delete(ctx context.Context, id string, version uint64) error {
+	if version != store.AnyVersion {
+		return s.call(func() error {
+			return s.next.Delete(ctx, id, version)
+		})
+	}
+	retried := false
+	return s.retry(ctx, func() error {
+		err := s.next.Delete(ctx, id, version)
+		if err == store.ErrNotFound && retried {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
+
+func (s *Store) Search(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	cursor string,
+	limit uint64,
+) (results []*models.Company, next string, err error) {
+	err = s.retry(ctx, func() error {
+		results, next, err = s.next.Search(ctx, query, sort, cursor, limit)
+		return err
+	})
+	return results, next, err
+}
+
+// Each is not retried as fn may have seen part of the companies.
+func (s *Store) Each(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	fn func(*models.Company) error,
+) error {
+	return s.call(func() error {
+		return s.next.Each(ctx, query, sort, fn)
+	})
+}
+
+func (s *Store) Count(ctx context.Context, query store.SearchFilters) (count uint64, err error) {
+	err = s.retry(ctx, func() error {
+		count, err = s.next.Count(ctx, query)
+		return err
+	})
+	return count, err
+}
+
+func (s *Store) TextSearch(
+	ctx context.Context,
+	query store.SearchFilters,
+	cursor string,
+	limit uint64,
+) (results []*store.ScoredCompany, next string, err error) {
+	err = s.retry(ctx, func() error {
+		results, next, err = s.next.TextSearch(ctx, query, cursor, limit)
+		return err
+	})
+	return results, next, err
+}
+
+func (s *Store) UpdateMany(ctx context.Context, query store.SearchFilters, fields store.CompanyOptFields) (updated uint64, err error) {
+	err = s.call(func() error {
+		updated, err = s.next.UpdateMany(ctx, query, fields)
+		return err
+	})
+	return updated, err
+}
+
+func (s *Store) DeleteMany(ctx context.Context, query store.SearchFilters) (deleted uint64, err error) {
+	err = s.call(func() error {
+		deleted, err = s.next.DeleteMany(ctx, query)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) SearchDeleted(ctx context.Context, skip, limit uint64) (results []*models.Company, err error) {
+	err = s.retry(ctx, func() error {
+		results, err = s.next.SearchDeleted(ctx, skip, limit)
+		return err
+	})
+	return results, err
+}
+
+func (s *Store) Restore(ctx context.Context, id string) error {
+	return s.call(func() error {
+		return s.next.Restore(ctx, id)
+	})
+}
+
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) (purged uint64, err error) {
+	err = s.call(func() error {
+		purged, err = s.next.Purge(ctx, deletedBefore)
+		return err
+	})
+	return purged, err
+}
+
+// WithTransaction is left to the store, it retries transactions on
+// transient errors itself. Calls made by fn go through the breaker.
+func (s *transactionalStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.next.(store.Transactor).WithTransaction(ctx, fn)
+}
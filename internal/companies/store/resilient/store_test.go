@@ -0,0 +1,166 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/memory"
+)
+
+var errTransient = errors.New("transient")
+
+// failingStore fails the next failures calls of Get and Insert, and
+// of Delete after deleting, as if the reply was lost.
+type failingStore struct {
+	store.Store
+	failures int
+	calls    int
+}
+
+func (s *failingStore) fail() error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return errTransient
+	}
+	return nil
+}
+
+func (s *failingStore) Get(ctx context.Context, id string) (*models.Company, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, id)
+}
+
+func (s *failingStore) Insert(ctx context.Context, company *models.Company) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Store.Insert(ctx, company)
+}
+
+func (s *failingStore) Delete(ctx context.Context, id string, version uint64) error {
+	if err := s.Store.Delete(ctx, id, version); err != nil {
+		s.calls++
+		return err
+	}
+	return s.fail()
+}
+
+func createTestStore(t *testing.T) (*Store, *failingStore) {
+	next := &failingStore{Store: memory.NewStore()}
+	assert.NoError(t, next.Store.Insert(context.Background(), &models.Company{ID: "1", Name: "First", Code: "FC", Country: "Cyprus"}))
+	s := NewStore(next, Options{
+		Retryable:        func(err error) bool { return err == errTransient },
+		Attempts:         3,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       2 * time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerOpenFor:   time.Minute,
+	})
+	return s.(*Store), next
+}
+
+func TestRetry(t *testing.T) {
+	s, next := createTestStore(t)
+
+	next.failures = 2
+	company, err := s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "First", company.Name)
+	assert.Equal(t, 3, next.calls)
+
+	next.calls, next.failures = 0, 3
+	_, err = s.Get(context.Background(), "1")
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 3, next.calls)
+
+	next.calls = 0
+	_, err = s.Get(context.Background(), "2")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, 1, next.calls)
+
+	next.calls, next.failures = 0, 1
+	err = s.Insert(context.Background(), &models.Company{ID: "2", Name: "Second", Code: "SC", Country: "Cyprus"})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, next.calls)
+}
+
+func TestRetryDelete(t *testing.T) {
+	s, next := createTestStore(t)
+
+	next.failures = 1
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	assert.Equal(t, 2, next.calls)
+	_, err := next.Store.Get(context.Background(), "1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	next.calls = 0
+	err = s.Delete(context.Background(), "1", store.AnyVersion)
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, 1, next.calls)
+
+	assert.NoError(t, next.Store.Insert(context.Background(), &models.Company{ID: "2", Name: "Second", Code: "SC", Country: "Cyprus", Version: 1}))
+	next.calls, next.failures = 0, 1
+	err = s.Delete(context.Background(), "2", 1)
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, next.calls)
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	s, next := createTestStore(t)
+	s.opts.Backoff, s.opts.MaxBackoff = time.Minute, time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	next.failures = 1
+	_, err := s.Get(ctx, "1")
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, next.calls)
+}
+
+func TestBreaker(t *testing.T) {
+	s, next := createTestStore(t)
+	now := time.Now()
+	s.breaker.now = func() time.Time { return now }
+	s.opts.Attempts = 1
+
+	next.failures = 2
+	for i := 0; i < 2; i++ {
+		_, err := s.Get(context.Background(), "1")
+		assert.Equal(t, errTransient, err)
+	}
+
+	_, err := s.Get(context.Background(), "1")
+	var unavailable *store.UnavailableError
+	assert.True(t, errors.As(err, &unavailable))
+	assert.Equal(t, time.Minute, unavailable.RetryAfter)
+	assert.True(t, errors.Is(err, store.ErrUnavailable))
+	assert.Equal(t, 2, next.calls)
+
+	// A failed trial call opens the breaker again.
+	now = now.Add(time.Minute)
+	next.failures = 1
+	_, err = s.Get(context.Background(), "1")
+	assert.Equal(t, errTransient, err)
+	_, err = s.Get(context.Background(), "1")
+	assert.True(t, errors.Is(err, store.ErrUnavailable))
+
+	now = now.Add(time.Minute)
+	_, err = s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	_, err = s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+}
+
+func TestTransactor(t *testing.T) {
+	_, ok := NewStore(memory.NewStore(), Options{}).(store.Transactor)
+	assert.False(t, ok)
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+)
+
+const (
+	SortFieldID        = "id"
+	SortFieldName      = "name"
+	SortFieldCode      = "code"
+	SortFieldCountry   = "country"
+	SortFieldWebsite   = "website"
+	SortFieldPhone     = "phone"
+	SortFieldCreatedAt = "created_at"
+)
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// KeysetSort completes the requested order into a total one, sorting by
+// creation time when nothing was requested and breaking ties by id.
+func KeysetSort(sort []SortField) []SortField {
+	keyset := make([]SortField, 0, len(sort)+1)
+	for _, field := range sort {
+		keyset = append(keyset, field)
+		if field.Field == SortFieldID {
+			return keyset
+		}
+	}
+	if len(keyset) < 1 {
+		keyset = append(keyset, SortField{Field: SortFieldCreatedAt})
+	}
+	return append(keyset, SortField{Field: SortFieldID})
+}
+
+// IsDefaultSort tells if the keyset order is the default
+// (created_at, id) one.
+func IsDefaultSort(keyset []SortField) bool {
+	return len(keyset) == 2 &&
+		keyset[0] == SortField{Field: SortFieldCreatedAt} &&
+		keyset[1] == SortField{Field: SortFieldID}
+}
+
+func sortString(keyset []SortField) string {
+	parts := make([]string, len(keyset))
+	for idx, field := range keyset {
+		parts[idx] = field.Field
+		if field.Desc {
+			parts[idx] = "-" + field.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Compare orders companies by the keyset, it returns a negative
+// number when a goes before b and a positive one when after.
+func Compare(a, b *models.Company, keyset []SortField) int {
+	for _, field := range keyset {
+		result := compareField(a, b, field.Field)
+		if field.Desc {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func compareField(a, b *models.Company, field string) int {
+	switch field {
+	case SortFieldCreatedAt:
+		switch {
+		case a.CreatedAt.Before(b.CreatedAt):
+			return -1
+		case a.CreatedAt.After(b.CreatedAt):
+			return 1
+		}
+		return 0
+	case SortFieldName:
+		return strings.Compare(a.Name, b.Name)
+	case SortFieldCode:
+		return strings.Compare(a.Code, b.Code)
+	case SortFieldCountry:
+		return strings.Compare(a.Country, b.Country)
+	case SortFieldWebsite:
+		return strings.Compare(a.Website, b.Website)
+	case SortFieldPhone:
+		return strings.Compare(a.Phone, b.Phone)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
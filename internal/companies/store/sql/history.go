@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+const historyColumns = "company_id, action, before, after, request_id, client_ip, client_country, created_at"
+
+type HistoryStore struct {
+	db *dbsql.DB
+}
+
+func NewHistoryStore(db *dbsql.DB) *HistoryStore {
+	return &HistoryStore{db}
+}
+
+func (s *HistoryStore) InsertHistory(ctx context.Context, entry *models.HistoryEntry) error {
+	entry.CreatedAt = time.Now()
+	before, err := encodeSnapshot(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := encodeSnapshot(entry.After)
+	if err != nil {
+		return err
+	}
+	_, err = connFor(ctx, s.db).ExecContext(
+		ctx,
+		"INSERT INTO companies_history ("+historyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.CompanyID,
+		entry.Action,
+		before,
+		after,
+		entry.RequestID,
+		entry.ClientIP,
+		entry.ClientCountry,
+		entry.CreatedAt.UnixNano(),
+	)
+	return err
+}
+
+func (s *HistoryStore) SearchHistory(
+	ctx context.Context,
+	companyID string,
+	skip, limit uint64,
+) ([]*models.HistoryEntry, error) {
+	rows, err := connFor(ctx, s.db).QueryContext(
+		ctx,
+		"SELECT "+historyColumns+" FROM companies_history WHERE company_id = ? ORDER BY seq LIMIT ? OFFSET ?",
+		companyID,
+		sqlLimit(limit),
+		int64(skip),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.HistoryEntry
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, entry)
+	}
+	return results, rows.Err()
+}
+
+func (s *HistoryStore) HistoryAt(
+	ctx context.Context,
+	companyID string,
+	at time.Time,
+) (*models.HistoryEntry, error) {
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT "+historyColumns+` FROM companies_history
+		WHERE company_id = ? AND created_at <= ? ORDER BY seq DESC LIMIT 1`,
+		companyID,
+		at.UnixNano(),
+	)
+	entry, err := scanHistoryEntry(row)
+	if err == dbsql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func scanHistoryEntry(row scanner) (*models.HistoryEntry, error) {
+	var (
+		entry         models.HistoryEntry
+		before, after dbsql.NullString
+		createdAt     int64
+	)
+	err := row.Scan(
+		&entry.CompanyID,
+		&entry.Action,
+		&before,
+		&after,
+		&entry.RequestID,
+		&entry.ClientIP,
+		&entry.ClientCountry,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Before, err = decodeSnapshot(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = decodeSnapshot(after); err != nil {
+		return nil, err
+	}
+	entry.CreatedAt = time.Unix(0, createdAt)
+	return &entry, nil
+}
+
+func encodeSnapshot(snapshot *models.CompanySnapshot) (dbsql.NullString, error) {
+	if snapshot == nil {
+		return dbsql.NullString{}, nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return dbsql.NullString{}, err
+	}
+	return dbsql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeSnapshot(data dbsql.NullString) (*models.CompanySnapshot, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var snapshot models.CompanySnapshot
+	if err := json.Unmarshal([]byte(data.String), &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
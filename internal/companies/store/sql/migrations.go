@@ -0,0 +1,157 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// migrations are applied in order, each one exactly once. The index
+// of a migration plus one is its schema version, so existing entries
+// must never be edited or reordered, only appended to.
+var migrations = []string{
+	`CREATE TABLE companies (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		code       TEXT NOT NULL,
+		country    TEXT NOT NULL,
+		website    TEXT NOT NULL,
+		phone      TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER
+	)`,
+	`CREATE INDEX companies_code_idx ON companies (code);
+	CREATE INDEX companies_country_idx ON companies (country);
+	CREATE INDEX companies_name_idx ON companies (name)`,
+	`ALTER TABLE companies ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE companies ADD COLUMN deleted_at INTEGER;
+	CREATE INDEX companies_deleted_at_idx ON companies (deleted_at)`,
+	`CREATE TABLE companies_history (
+		seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id     TEXT NOT NULL,
+		action         TEXT NOT NULL,
+		before         TEXT,
+		after          TEXT,
+		request_id     TEXT NOT NULL,
+		client_ip      TEXT NOT NULL,
+		client_country TEXT NOT NULL,
+		created_at     INTEGER NOT NULL
+	);
+	CREATE INDEX companies_history_company_idx ON companies_history (company_id, seq)`,
+	`CREATE UNIQUE INDEX companies_country_code_idx ON companies (country, code)`,
+	`CREATE INDEX companies_created_at_idx ON companies (created_at, id)`,
+	`CREATE VIRTUAL TABLE companies_fts USING fts5(
+		id UNINDEXED,
+		name,
+		code,
+		website,
+		tokenize = "unicode61 remove_diacritics 0"
+	);
+	INSERT INTO companies_fts (id, name, code, website)
+		SELECT id, name, code, website FROM companies;
+	CREATE TRIGGER companies_fts_insert AFTER INSERT ON companies BEGIN
+		INSERT INTO companies_fts (id, name, code, website)
+			VALUES (new.id, new.name, new.code, new.website);
+	END;
+	CREATE TRIGGER companies_fts_update AFTER UPDATE OF name, code, website ON companies BEGIN
+		UPDATE companies_fts SET name = new.name, code = new.code, website = new.website
+			WHERE id = new.id;
+	END;
+	CREATE TRIGGER companies_fts_delete AFTER DELETE ON companies BEGIN
+		DELETE FROM companies_fts WHERE id = old.id;
+	END`,
+	`CREATE TABLE companies_outbox (
+		seq          INTEGER PRIMARY KEY AUTOINCREMENT,
+		id           TEXT NOT NULL UNIQUE,
+		type         TEXT NOT NULL,
+		company_id   TEXT NOT NULL,
+		company      TEXT,
+		request_id   TEXT NOT NULL,
+		created_at   INTEGER NOT NULL,
+		delivered_at INTEGER
+	);
+	CREATE INDEX companies_outbox_pending_idx ON companies_outbox (delivered_at, seq)`,
+	`CREATE TABLE webhooks (
+		seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+		id          TEXT NOT NULL UNIQUE,
+		url         TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		secret      TEXT NOT NULL,
+		created_at  INTEGER NOT NULL,
+		updated_at  INTEGER
+	);
+	CREATE TABLE webhook_deliveries (
+		seq              INTEGER PRIMARY KEY AUTOINCREMENT,
+		id               TEXT NOT NULL UNIQUE,
+		subscription_id  TEXT NOT NULL,
+		event_id         TEXT NOT NULL,
+		event_type       TEXT NOT NULL,
+		payload          BLOB NOT NULL,
+		status           TEXT NOT NULL,
+		attempts         INTEGER NOT NULL,
+		next_attempt_at  INTEGER NOT NULL,
+		last_status_code INTEGER NOT NULL,
+		last_error       TEXT NOT NULL,
+		created_at       INTEGER NOT NULL,
+		updated_at       INTEGER
+	);
+	CREATE INDEX webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, seq);
+	CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at, seq)`,
+}
+
+// Migrate brings the database schema up to date, it is safe
+// to run on every startup.
+func Migrate(ctx context.Context, db *dbsql.DB) error {
+	_, err := db.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at INTEGER NOT NULL
+		)`,
+	)
+	if err != nil {
+		return errors.Wrap(err, "error creating schema_migrations table")
+	}
+
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "error reading schema version")
+	}
+
+	for idx := current; idx < len(migrations); idx++ {
+		if err = applyMigration(ctx, db, idx+1, migrations[idx]); err != nil {
+			return errors.Wrapf(err, "error applying migration %d", idx+1)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *dbsql.DB, version int, migration string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)",
+		version,
+		nowNanos(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(ctx context.Context, db *dbsql.DB) (int, error) {
+	var version int
+	row := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	err := row.Scan(&version)
+	return version, err
+}
@@ -0,0 +1,112 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+const eventColumns = "id, type, company_id, company, request_id, created_at, delivered_at"
+
+type OutboxStore struct {
+	db *dbsql.DB
+}
+
+func NewOutboxStore(db *dbsql.DB) *OutboxStore {
+	return &OutboxStore{db}
+}
+
+func (s *OutboxStore) InsertEvent(ctx context.Context, event *models.Event) error {
+	event.CreatedAt = time.Now()
+	event.DeliveredAt = nil
+	company, err := encodeSnapshot(event.Company)
+	if err != nil {
+		return err
+	}
+	_, err = connFor(ctx, s.db).ExecContext(
+		ctx,
+		"INSERT INTO companies_outbox ("+eventColumns+") VALUES (?, ?, ?, ?, ?, ?, NULL)",
+		event.ID,
+		event.Type,
+		event.CompanyID,
+		company,
+		event.RequestID,
+		event.CreatedAt.UnixNano(),
+	)
+	return err
+}
+
+func (s *OutboxStore) PendingEvents(ctx context.Context, limit uint64) ([]*models.Event, error) {
+	rows, err := connFor(ctx, s.db).QueryContext(
+		ctx,
+		"SELECT "+eventColumns+" FROM companies_outbox WHERE delivered_at IS NULL ORDER BY seq LIMIT ?",
+		sqlLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, event)
+	}
+	return results, rows.Err()
+}
+
+func (s *OutboxStore) MarkDelivered(ctx context.Context, id string) error {
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE companies_outbox SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL",
+		nowNanos(),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func scanEvent(row scanner) (*models.Event, error) {
+	var (
+		event       models.Event
+		company     dbsql.NullString
+		createdAt   int64
+		deliveredAt dbsql.NullInt64
+	)
+	err := row.Scan(
+		&event.ID,
+		&event.Type,
+		&event.CompanyID,
+		&company,
+		&event.RequestID,
+		&createdAt,
+		&deliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if event.Company, err = decodeSnapshot(company); err != nil {
+		return nil, err
+	}
+	event.CreatedAt = time.Unix(0, createdAt)
+	if deliveredAt.Valid {
+		delivered := time.Unix(0, deliveredAt.Int64)
+		event.DeliveredAt = &delivered
+	}
+	return &event, nil
+}
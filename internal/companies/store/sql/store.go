@@ -0,0 +1,621 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+const DriverName = "sqlite"
+
+const companyColumns = "id, name, code, country, website, phone, created_at, updated_at, version, deleted_at"
+
+type Store struct {
+	db *dbsql.DB
+}
+
+func NewStore(db *dbsql.DB) *Store {
+	return &Store{db}
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*models.Company, error) {
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT "+companyColumns+" FROM companies WHERE id = ? AND deleted_at IS NULL",
+		id,
+	)
+	company, err := scanCompany(row)
+	if err == dbsql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+func (s *Store) GetAny(ctx context.Context, id string) (*models.Company, error) {
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT "+companyColumns+" FROM companies WHERE id = ?",
+		id,
+	)
+	company, err := scanCompany(row)
+	if err == dbsql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+func (s *Store) GetMany(ctx context.Context, ids []string) ([]*models.Company, error) {
+	if len(ids) < 1 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for idx, id := range ids {
+		args[idx] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	return s.query(ctx, "id IN ("+placeholders+") AND deleted_at IS NULL", args, "id", 0, 0)
+}
+
+func (s *Store) Insert(ctx context.Context, company *models.Company) error {
+	company.CreatedAt = time.Now()
+	company.UpdatedAt = nil
+	company.DeletedAt = nil
+	company.Version = 1
+	_, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"INSERT INTO companies ("+companyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, NULL, ?, NULL)",
+		company.ID,
+		company.Name,
+		company.Code,
+		company.Country,
+		company.Website,
+		company.Phone,
+		company.CreatedAt.UnixNano(),
+		company.Version,
+	)
+	if isUniqueViolation(err) {
+		return s.duplicateError(ctx, company.ID, company.Code, company.Country)
+	}
+	return err
+}
+
+func (s *Store) Update(
+	ctx context.Context,
+	id string,
+	version uint64,
+	fields store.CompanyOptFields,
+) error {
+	sets := []string{"updated_at = ?", "version = version + 1"}
+	args := []interface{}{nowNanos()}
+	for _, column := range optColumns(fields) {
+		sets = append(sets, column.name+" = ?")
+		args = append(args, *column.value)
+	}
+	where, whereArgs := versionedWhere(id, version)
+
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE companies SET "+strings.Join(sets, ", ")+" WHERE "+where,
+		append(args, whereArgs...)...,
+	)
+	if isUniqueViolation(err) {
+		current, err := s.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		code, country := current.Code, current.Country
+		if fields.Code != nil {
+			code = *fields.Code
+		}
+		if fields.Country != nil {
+			country = *fields.Country
+		}
+		return s.duplicateError(ctx, id, code, country)
+	} else if err != nil {
+		return err
+	}
+	return s.requireAffected(ctx, result, id)
+}
+
+func (s *Store) Delete(ctx context.Context, id string, version uint64) error {
+	where, args := versionedWhere(id, version)
+	now := nowNanos()
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE companies SET deleted_at = ?, updated_at = ?, version = version + 1 WHERE "+where,
+		append([]interface{}{now, now}, args...)...,
+	)
+	if err != nil {
+		return err
+	}
+	return s.requireAffected(ctx, result, id)
+}
+
+func (s *Store) UpdateMany(
+	ctx context.Context,
+	query store.SearchFilters,
+	fields store.CompanyOptFields,
+) (uint64, error) {
+	sets := []string{"updated_at = ?", "version = version + 1"}
+	args := []interface{}{nowNanos()}
+	for _, column := range optColumns(fields) {
+		sets = append(sets, column.name+" = ?")
+		args = append(args, *column.value)
+	}
+	conditions, whereArgs := searchConditions(query)
+
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE companies SET "+strings.Join(sets, ", ")+" WHERE "+strings.Join(conditions, " AND "),
+		append(args, whereArgs...)...,
+	)
+	if isUniqueViolation(err) {
+		return 0, store.ErrDuplicate
+	} else if err != nil {
+		return 0, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(updated), nil
+}
+
+func (s *Store) DeleteMany(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	conditions, args := searchConditions(query)
+	now := nowNanos()
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE companies SET deleted_at = ?, updated_at = ?, version = version + 1 WHERE "+
+			strings.Join(conditions, " AND "),
+		append([]interface{}{now, now}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(deleted), nil
+}
+
+func (s *Store) SearchDeleted(ctx context.Context, skip, limit uint64) ([]*models.Company, error) {
+	return s.query(ctx, "deleted_at IS NOT NULL", nil, "rowid", skip, limit)
+}
+
+func (s *Store) Restore(ctx context.Context, id string) error {
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		`UPDATE companies SET deleted_at = NULL, updated_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL`,
+		nowNanos(),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) (uint64, error) {
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"DELETE FROM companies WHERE deleted_at < ?",
+		deletedBefore.UnixNano(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(purged), nil
+}
+
+func (s *Store) Search(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	cursor string,
+	limit uint64,
+) ([]*models.Company, string, error) {
+	keyset := store.KeysetSort(sort)
+	after, err := store.DecodeCursor(cursor, keyset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conditions, args := searchConditions(query)
+	if after != nil {
+		condition, afterArgs := keysetCondition(after)
+		conditions = append(conditions, condition)
+		args = append(args, afterArgs...)
+	}
+
+	results, err := s.query(
+		ctx,
+		strings.Join(conditions, " AND "),
+		args,
+		orderBy(keyset),
+		0,
+		store.FetchLimit(limit),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	results, next := store.Page(results, keyset, limit)
+	return results, next, nil
+}
+
+// keysetCondition matches rows after the cursor, expanding the keyset
+// into "(a > ?) OR (a = ? AND b > ?) OR ..." with flipped comparison
+// for descending fields.
+func keysetCondition(after *store.Cursor) (string, []interface{}) {
+	var (
+		alternatives []string
+		args         []interface{}
+		equal        []string
+		equalArgs    []interface{}
+	)
+	for _, field := range after.Keyset {
+		value := sortValue(&after.Company, field.Field)
+		comparison := " > ?"
+		if field.Desc {
+			comparison = " < ?"
+		}
+
+		column := sortColumn(field.Field)
+		terms := append(append([]string{}, equal...), column+comparison)
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		args = append(append(args, equalArgs...), value)
+
+		equal = append(equal, column+" = ?")
+		equalArgs = append(equalArgs, value)
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+func orderBy(keyset []store.SortField) string {
+	terms := make([]string, len(keyset))
+	for idx, field := range keyset {
+		terms[idx] = sortColumn(field.Field)
+		if field.Desc {
+			terms[idx] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// sortColumn maps sort fields to columns, keeping anything
+// unexpected out of the statement.
+func sortColumn(field string) string {
+	switch field {
+	case store.SortFieldCreatedAt,
+		store.SortFieldName,
+		store.SortFieldCode,
+		store.SortFieldCountry,
+		store.SortFieldWebsite,
+		store.SortFieldPhone:
+		return field
+	}
+	return "id"
+}
+
+func sortValue(company *models.Company, field string) interface{} {
+	switch field {
+	case store.SortFieldCreatedAt:
+		return company.CreatedAt.UnixNano()
+	case store.SortFieldName:
+		return company.Name
+	case store.SortFieldCode:
+		return company.Code
+	case store.SortFieldCountry:
+		return company.Country
+	case store.SortFieldWebsite:
+		return company.Website
+	case store.SortFieldPhone:
+		return company.Phone
+	}
+	return company.ID
+}
+
+func (s *Store) TextSearch(
+	ctx context.Context,
+	query store.SearchFilters,
+	cursor string,
+	limit uint64,
+) ([]*store.ScoredCompany, string, error) {
+	after, err := store.DecodeTextCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if query.Text == nil {
+		return nil, "", nil
+	}
+
+	conditions, args := searchConditions(query)
+	candidates, err := s.query(ctx, strings.Join(conditions, " AND "), args, "id", 0, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	terms := store.TextTerms(*query.Text)
+	results, next := store.PageText(store.RankText(candidates, terms, after), limit)
+	return results, next, nil
+}
+
+func (s *Store) Each(
+	ctx context.Context,
+	query store.SearchFilters,
+	sort []store.SortField,
+	fn func(*models.Company) error,
+) error {
+	return store.EachPage(ctx, s, query, sort, fn)
+}
+
+func (s *Store) Count(ctx context.Context, query store.SearchFilters) (uint64, error) {
+	conditions, args := searchConditions(query)
+	var count uint64
+	err := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM companies WHERE "+strings.Join(conditions, " AND "),
+		args...,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func searchConditions(query store.SearchFilters) ([]string, []interface{}) {
+	var (
+		conditions = []string{"deleted_at IS NULL"}
+		args       []interface{}
+	)
+	for _, column := range []struct {
+		name   string
+		filter store.StringFilter
+	}{
+		{"name", query.Name},
+		{"code", query.Code},
+		{"country", query.Country},
+		{"website", query.Website},
+		{"phone", query.Phone},
+	} {
+		filter := column.filter
+		if filter.Eq != nil {
+			conditions = append(conditions, column.name+" = ?")
+			args = append(args, *filter.Eq)
+		}
+		if filter.In != nil {
+			if len(filter.In) < 1 {
+				conditions = append(conditions, "0")
+			} else {
+				placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.In)), ", ")
+				conditions = append(conditions, column.name+" IN ("+placeholders+")")
+				for _, value := range filter.In {
+					args = append(args, value)
+				}
+			}
+		}
+		// LIKE ignores case in SQLite, instr keeps prefix match exact.
+		if filter.Prefix != nil {
+			conditions = append(conditions, "instr("+column.name+", ?) = 1")
+			args = append(args, *filter.Prefix)
+		}
+		if filter.Contains != nil {
+			conditions = append(conditions, "instr(lower("+column.name+"), lower(?)) > 0")
+			args = append(args, *filter.Contains)
+		}
+	}
+	for _, column := range []struct {
+		name   string
+		filter store.TimeRange
+	}{
+		{"created_at", query.CreatedAt},
+		{"updated_at", query.UpdatedAt},
+	} {
+		if column.filter.After != nil {
+			conditions = append(conditions, column.name+" > ?")
+			args = append(args, column.filter.After.UnixNano())
+		}
+		if column.filter.Before != nil {
+			conditions = append(conditions, column.name+" < ?")
+			args = append(args, column.filter.Before.UnixNano())
+		}
+	}
+	if query.Text != nil {
+		condition, textArgs := textCondition(store.TextTerms(*query.Text))
+		conditions = append(conditions, condition)
+		args = append(args, textArgs...)
+	}
+	return conditions, args
+}
+
+// textCondition narrows rows down to ones having any of the terms
+// through the full text index, ranking is left to store.RankText.
+func textCondition(terms []string) (string, []interface{}) {
+	if len(terms) < 1 {
+		return "0", nil
+	}
+	// Tokens are letters and digits only, quoting
+	// keeps them from being read as FTS operators.
+	quoted := make([]string, len(terms))
+	for idx, term := range terms {
+		quoted[idx] = `"` + term + `"`
+	}
+	return "id IN (SELECT id FROM companies_fts WHERE companies_fts MATCH ?)",
+		[]interface{}{strings.Join(quoted, " OR ")}
+}
+
+func (s *Store) query(
+	ctx context.Context,
+	where string,
+	args []interface{},
+	orderBy string,
+	skip, limit uint64,
+) ([]*models.Company, error) {
+	statement := "SELECT " + companyColumns + " FROM companies WHERE " + where
+	statement += " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	args = append(args, sqlLimit(limit), int64(skip))
+
+	rows, err := connFor(ctx, s.db).QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.Company
+	for rows.Next() {
+		company, err := scanCompany(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, company)
+	}
+	return results, rows.Err()
+}
+
+type optColumn struct {
+	name  string
+	value *string
+}
+
+func optColumns(fields store.CompanyOptFields) []optColumn {
+	var columns []optColumn
+	for _, column := range []optColumn{
+		{"name", fields.Name},
+		{"code", fields.Code},
+		{"country", fields.Country},
+		{"website", fields.Website},
+		{"phone", fields.Phone},
+	} {
+		if column.value != nil {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCompany(row scanner) (*models.Company, error) {
+	var (
+		company   models.Company
+		createdAt int64
+		updatedAt dbsql.NullInt64
+		deletedAt dbsql.NullInt64
+	)
+	err := row.Scan(
+		&company.ID,
+		&company.Name,
+		&company.Code,
+		&company.Country,
+		&company.Website,
+		&company.Phone,
+		&createdAt,
+		&updatedAt,
+		&company.Version,
+		&deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	company.CreatedAt = time.Unix(0, createdAt)
+	if updatedAt.Valid {
+		t := time.Unix(0, updatedAt.Int64)
+		company.UpdatedAt = &t
+	}
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64)
+		company.DeletedAt = &t
+	}
+	return &company, nil
+}
+
+func isUniqueViolation(err error) bool {
+	sqliteErr, ok := err.(*sqlite.Error)
+	return ok && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (s *Store) duplicateError(ctx context.Context, id, code, country string) error {
+	var existingID string
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT id FROM companies WHERE country = ? AND code = ? AND id != ?",
+		country,
+		code,
+		id,
+	)
+	if err := row.Scan(&existingID); err != nil {
+		return err
+	}
+	return &store.DuplicateError{ExistingID: existingID}
+}
+
+func versionedWhere(id string, version uint64) (string, []interface{}) {
+	if version == store.AnyVersion {
+		return "id = ? AND deleted_at IS NULL", []interface{}{id}
+	}
+	return "id = ? AND deleted_at IS NULL AND version = ?", []interface{}{id, version}
+}
+
+// requireAffected tells a missing company from a version mismatch
+// when a conditional statement didn't touch any rows.
+func (s *Store) requireAffected(ctx context.Context, result dbsql.Result, id string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var exists bool
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM companies WHERE id = ? AND deleted_at IS NULL)",
+		id,
+	)
+	if err = row.Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return store.ErrConflict
+	}
+	return store.ErrNotFound
+}
+
+// sqlLimit maps zero limit to no limit, same as in mongo. SQLite
+// requires LIMIT for OFFSET to be used, and treats negative LIMIT as none.
+func sqlLimit(limit uint64) int64 {
+	if limit > 0 {
+		return int64(limit)
+	}
+	return -1
+}
+
+func nowNanos() int64 {
+	return time.Now().UnixNano()
+}
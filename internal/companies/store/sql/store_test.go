@@ -0,0 +1,200 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store/storetest"
+)
+
+func createTestStore(t *testing.T) *Store {
+	db, err := dbsql.Open(DriverName, filepath.Join(t.TempDir(), "companies.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	assert.NoError(t, Migrate(context.Background(), db))
+	s := NewStore(db)
+
+	companies := storetest.Companies()
+	for idx := range companies {
+		assert.NoError(t, s.Insert(context.Background(), &companies[idx]))
+	}
+	return s
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	s := createTestStore(t)
+	assert.NoError(t, Migrate(context.Background(), s.db))
+
+	version, err := schemaVersion(context.Background(), s.db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestGetUpdateDelete(t *testing.T) {
+	s := createTestStore(t)
+
+	company, err := s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Second", company.Name)
+	assert.Nil(t, company.UpdatedAt)
+
+	name := "Updated"
+	assert.NoError(t, s.Update(context.Background(), "2", store.AnyVersion, store.CompanyOptFields{Name: &name}))
+	company, err = s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated", company.Name)
+	assert.Equal(t, "SC", company.Code)
+	assert.NotNil(t, company.UpdatedAt)
+
+	website, cleared := "http://second.example/", ""
+	assert.NoError(t, s.Update(context.Background(), "2", store.AnyVersion, store.CompanyOptFields{Website: &website}))
+	assert.NoError(t, s.Update(context.Background(), "2", store.AnyVersion, store.CompanyOptFields{Website: &cleared}))
+	company, err = s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Empty(t, company.Website)
+	assert.Equal(t, "Updated", company.Name)
+
+	assert.NoError(t, s.Delete(context.Background(), "2", store.AnyVersion))
+	_, err = s.Get(context.Background(), "2")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, s.Delete(context.Background(), "2", store.AnyVersion))
+	assert.Equal(t, store.ErrNotFound, s.Update(context.Background(), "2", store.AnyVersion, store.CompanyOptFields{}))
+}
+
+func TestSearch(t *testing.T) {
+	storetest.Search(t, createTestStore(t))
+}
+
+func TestSearchCursorStable(t *testing.T) {
+	storetest.SearchCursorStable(t, createTestStore(t))
+}
+
+func TestSearchOperators(t *testing.T) {
+	storetest.SearchOperators(t, createTestStore(t))
+}
+
+func TestTextSearch(t *testing.T) {
+	storetest.TextSearch(t, createTestStore(t))
+}
+
+func TestSearchSorted(t *testing.T) {
+	storetest.SearchSorted(t, createTestStore(t))
+}
+
+func TestCount(t *testing.T) {
+	storetest.Count(t, createTestStore(t))
+}
+
+func TestGetMany(t *testing.T) {
+	storetest.GetMany(t, createTestStore(t))
+}
+
+func TestEach(t *testing.T) {
+	storetest.Each(t, createTestStore(t))
+}
+
+func TestUpdateMany(t *testing.T) {
+	storetest.UpdateMany(t, createTestStore(t))
+}
+
+func TestDeleteMany(t *testing.T) {
+	storetest.DeleteMany(t, createTestStore(t))
+}
+
+func TestVersionConflict(t *testing.T) {
+	storetest.VersionConflict(t, createTestStore(t))
+}
+
+func TestTrash(t *testing.T) {
+	storetest.Trash(t, createTestStore(t))
+}
+
+func TestHistory(t *testing.T) {
+	storetest.History(t, NewHistoryStore(createTestStore(t).db))
+}
+
+func TestUniqueCodeInCountry(t *testing.T) {
+	storetest.UniqueCodeInCountry(t, createTestStore(t))
+}
+
+func TestOutbox(t *testing.T) {
+	s := createTestStore(t)
+	o := NewOutboxStore(s.db)
+
+	for _, id := range []string{"e1", "e2", "e3"} {
+		event := models.Event{
+			ID:        id,
+			Type:      models.EventCompanyUpdated,
+			CompanyID: "1",
+			Company:   &models.CompanySnapshot{Name: "First", Version: 2},
+		}
+		assert.NoError(t, o.InsertEvent(context.Background(), &event))
+	}
+
+	events, err := o.PendingEvents(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "e1", events[0].ID)
+	assert.Equal(t, "e2", events[1].ID)
+	assert.Equal(t, "First", events[0].Company.Name)
+	assert.False(t, events[0].CreatedAt.IsZero())
+	assert.Nil(t, events[0].DeliveredAt)
+
+	assert.NoError(t, o.MarkDelivered(context.Background(), "e1"))
+	assert.Equal(t, store.ErrNotFound, o.MarkDelivered(context.Background(), "e1"))
+	assert.Equal(t, store.ErrNotFound, o.MarkDelivered(context.Background(), "missing"))
+
+	events, err = o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "e2", events[0].ID)
+	assert.Equal(t, "e3", events[1].ID)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	s := createTestStore(t)
+	o := NewOutboxStore(s.db)
+
+	failed := errors.New("failed")
+	err := s.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.Insert(ctx, &models.Company{ID: "4", Name: "Fourth", Code: "4C", Country: "Cyprus"}); err != nil {
+			return err
+		}
+		if err := o.InsertEvent(ctx, &models.Event{ID: "e1", CompanyID: "4"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	_, err = s.Get(context.Background(), "4")
+	assert.Equal(t, store.ErrNotFound, err)
+	events, err := o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	err = s.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.Insert(ctx, &models.Company{ID: "4", Name: "Fourth", Code: "4C", Country: "Cyprus"}); err != nil {
+			return err
+		}
+		return o.InsertEvent(ctx, &models.Event{ID: "e1", CompanyID: "4"})
+	})
+	assert.NoError(t, err)
+	_, err = s.Get(context.Background(), "4")
+	assert.NoError(t, err)
+	events, err = o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+}
+
+func TestWebhooks(t *testing.T) {
+	storetest.Webhooks(t, NewWebhookStore(createTestStore(t).db))
+}
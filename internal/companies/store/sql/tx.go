@@ -0,0 +1,43 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+)
+
+type txKey struct{}
+
+// conn is the part of *sql.DB and *sql.Tx the stores use.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (dbsql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*dbsql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *dbsql.Row
+}
+
+// connFor returns the transaction ctx carries, or db outside of
+// transactions. Stores must not use db directly, the single
+// connection is taken while a transaction is open.
+func connFor(ctx context.Context, db *dbsql.DB) conn {
+	if tx, ok := ctx.Value(txKey{}).(*dbsql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// WithTransaction covers the stores sharing the database,
+// history and outbox included.
+func (s *Store) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*dbsql.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
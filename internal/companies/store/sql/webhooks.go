@@ -0,0 +1,290 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+const (
+	subscriptionColumns = "id, url, event_types, secret, created_at, updated_at"
+	deliveryColumns     = "id, subscription_id, event_id, event_type, payload, status, attempts, " +
+		"next_attempt_at, last_status_code, last_error, created_at, updated_at"
+)
+
+type WebhookStore struct {
+	db *dbsql.DB
+}
+
+func NewWebhookStore(db *dbsql.DB) *WebhookStore {
+	return &WebhookStore{db}
+}
+
+func (s *WebhookStore) InsertSubscription(ctx context.Context, subscription *models.Subscription) error {
+	subscription.CreatedAt = time.Now()
+	subscription.UpdatedAt = nil
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+	_, err = connFor(ctx, s.db).ExecContext(
+		ctx,
+		"INSERT INTO webhooks ("+subscriptionColumns+") VALUES (?, ?, ?, ?, ?, NULL)",
+		subscription.ID,
+		subscription.URL,
+		string(eventTypes),
+		subscription.Secret,
+		subscription.CreatedAt.UnixNano(),
+	)
+	return err
+}
+
+func (s *WebhookStore) GetSubscription(ctx context.Context, id string) (*models.Subscription, error) {
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT "+subscriptionColumns+" FROM webhooks WHERE id = ?",
+		id,
+	)
+	subscription, err := scanSubscription(row)
+	if err == dbsql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *WebhookStore) ListSubscriptions(ctx context.Context) ([]*models.Subscription, error) {
+	rows, err := connFor(ctx, s.db).QueryContext(
+		ctx,
+		"SELECT "+subscriptionColumns+" FROM webhooks ORDER BY seq",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, subscription)
+	}
+	return results, rows.Err()
+}
+
+func (s *WebhookStore) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE webhooks SET url = ?, event_types = ?, secret = ?, updated_at = ? WHERE id = ?",
+		subscription.URL,
+		string(eventTypes),
+		subscription.Secret,
+		nowNanos(),
+		subscription.ID,
+	)
+	return requireAffected(result, err)
+}
+
+func (s *WebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	result, err := connFor(ctx, s.db).ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id)
+	if err = requireAffected(result, err); err != nil {
+		return err
+	}
+	_, err = connFor(ctx, s.db).ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id)
+	return err
+}
+
+func (s *WebhookStore) InsertDelivery(ctx context.Context, delivery *models.Delivery) error {
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = nil
+	_, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"INSERT INTO webhook_deliveries ("+deliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)",
+		delivery.ID,
+		delivery.SubscriptionID,
+		delivery.EventID,
+		delivery.EventType,
+		delivery.Payload,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt.UnixNano(),
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.CreatedAt.UnixNano(),
+	)
+	if isUniqueViolation(err) {
+		return store.ErrDuplicate
+	}
+	return err
+}
+
+func (s *WebhookStore) GetDelivery(ctx context.Context, id string) (*models.Delivery, error) {
+	row := connFor(ctx, s.db).QueryRowContext(
+		ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ?",
+		id,
+	)
+	delivery, err := scanDelivery(row)
+	if err == dbsql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (s *WebhookStore) DueDeliveries(
+	ctx context.Context,
+	now time.Time,
+	limit uint64,
+) ([]*models.Delivery, error) {
+	return s.queryDeliveries(
+		ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries"+
+			" WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, seq LIMIT ?",
+		models.DeliveryPending,
+		now.UnixNano(),
+		sqlLimit(limit),
+	)
+}
+
+func (s *WebhookStore) UpdateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	result, err := connFor(ctx, s.db).ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?,"+
+			" last_status_code = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt.UnixNano(),
+		delivery.LastStatusCode,
+		delivery.LastError,
+		nowNanos(),
+		delivery.ID,
+	)
+	return requireAffected(result, err)
+}
+
+func (s *WebhookStore) SearchDeliveries(
+	ctx context.Context,
+	subscriptionID string,
+	skip, limit uint64,
+) ([]*models.Delivery, error) {
+	return s.queryDeliveries(
+		ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries"+
+			" WHERE subscription_id = ? ORDER BY seq LIMIT ? OFFSET ?",
+		subscriptionID,
+		sqlLimit(limit),
+		int64(skip),
+	)
+}
+
+func (s *WebhookStore) queryDeliveries(
+	ctx context.Context,
+	query string,
+	args ...interface{},
+) ([]*models.Delivery, error) {
+	rows, err := connFor(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.Delivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, delivery)
+	}
+	return results, rows.Err()
+}
+
+// requireAffected turns an update of no rows into ErrNotFound.
+func requireAffected(result dbsql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func scanSubscription(row scanner) (*models.Subscription, error) {
+	var (
+		subscription models.Subscription
+		eventTypes   string
+		createdAt    int64
+		updatedAt    dbsql.NullInt64
+	)
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.URL,
+		&eventTypes,
+		&subscription.Secret,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(eventTypes), &subscription.EventTypes); err != nil {
+		return nil, err
+	}
+	subscription.CreatedAt = time.Unix(0, createdAt)
+	if updatedAt.Valid {
+		t := time.Unix(0, updatedAt.Int64)
+		subscription.UpdatedAt = &t
+	}
+	return &subscription, nil
+}
+
+func scanDelivery(row scanner) (*models.Delivery, error) {
+	var (
+		delivery      models.Delivery
+		nextAttemptAt int64
+		createdAt     int64
+		updatedAt     dbsql.NullInt64
+	)
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.SubscriptionID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&nextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.NextAttemptAt = time.Unix(0, nextAttemptAt)
+	delivery.CreatedAt = time.Unix(0, createdAt)
+	if updatedAt.Valid {
+		t := time.Unix(0, updatedAt.Int64)
+		delivery.UpdatedAt = &t
+	}
+	return &delivery, nil
+}
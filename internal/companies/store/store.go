@@ -1,16 +1,57 @@
+// Package store defines the persistence of companies and the data
+// kept alongside them. Every method taking a limit treats zero as no
+// limit, like mongo does.
 package store
 
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/RavisMsk/xmcompanies/internal/companies/models"
 )
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("version conflict")
+	ErrDuplicate = errors.New("duplicate company")
 )
 
+// DuplicateError is returned when a company with the same code already
+// exists in the country, it matches ErrDuplicate with errors.Is.
+type DuplicateError struct {
+	ExistingID string
+}
+
+func (e *DuplicateError) Error() string {
+	return "company with same code and country exists: " + e.ExistingID
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+var ErrUnavailable = errors.New("store unavailable")
+
+// UnavailableError is returned without calling a failing store, it
+// should be retried after RetryAfter and matches ErrUnavailable with
+// errors.Is.
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return "store unavailable, retry after " + e.RetryAfter.String()
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// AnyVersion makes Update and Delete unconditional, any other value
+// must match the stored company version or ErrConflict is returned.
+const AnyVersion uint64 = 0
+
 type CompanyFields struct {
 	Name    string
 	Code    string
@@ -19,6 +60,8 @@ type CompanyFields struct {
 	Phone   string
 }
 
+// CompanyOptFields leaves the nil fields as they are, a pointer
+// to an empty string unsets an optional field.
 type CompanyOptFields struct {
 	Name    *string
 	Code    *string
@@ -27,19 +70,143 @@ type CompanyOptFields struct {
 	Phone   *string
 }
 
+// Apply sets the fields that are not nil on the company.
+func (f CompanyOptFields) Apply(company *models.Company) {
+	if f.Name != nil {
+		company.Name = *f.Name
+	}
+	if f.Code != nil {
+		company.Code = *f.Code
+	}
+	if f.Country != nil {
+		company.Country = *f.Country
+	}
+	if f.Phone != nil {
+		company.Phone = *f.Phone
+	}
+	if f.Website != nil {
+		company.Website = *f.Website
+	}
+}
+
 type Store interface {
 	Get(ctx context.Context, id string) (*models.Company, error)
+	// GetAny is Get including companies in trash.
+	GetAny(ctx context.Context, id string) (*models.Company, error)
+	// GetMany returns the companies with the given ids in no particular
+	// order, ones missing or in trash are left out.
+	GetMany(ctx context.Context, ids []string) ([]*models.Company, error)
+	// Insert and Update fail with *DuplicateError when the code is
+	// already taken in the country, by trashed companies too.
 	Insert(ctx context.Context, company *models.Company) error
 	Update(
 		ctx context.Context,
 		id string,
+		version uint64,
 		fields CompanyOptFields,
 	) error
-	Delete(ctx context.Context, id string) error
+	// Delete moves a company to trash, it is excluded from Get and
+	// Search until restored and is removed for good by Purge.
+	Delete(ctx context.Context, id string, version uint64) error
+	// Search lists companies ordered by sort, (created_at, id) when
+	// empty, starting after the cursor token. The returned token is
+	// empty on the last page.
 	Search(
 		ctx context.Context,
-		query CompanyOptFields,
+		query SearchFilters,
+		sort []SortField,
+		cursor string,
+		limit uint64,
+	) ([]*models.Company, string, error)
+	// Each calls fn for every company Search would list for the query
+	// in the sort order, without loading them all at once. It stops at
+	// the first error fn returns and returns it.
+	Each(
+		ctx context.Context,
+		query SearchFilters,
+		sort []SortField,
+		fn func(*models.Company) error,
+	) error
+	// Count returns the number of companies Search would list
+	// for the query across all pages.
+	Count(ctx context.Context, query SearchFilters) (uint64, error)
+	// TextSearch lists companies matching query.Text by relevance,
+	// best first, in the same cursor pagination as Search.
+	TextSearch(
+		ctx context.Context,
+		query SearchFilters,
+		cursor string,
+		limit uint64,
+	) ([]*ScoredCompany, string, error)
+	// UpdateMany updates every company Search would list for the query
+	// and returns how many were updated. It fails with ErrDuplicate when
+	// the update would give two companies the same code and country, the
+	// mongo store may have updated part of the companies by then.
+	UpdateMany(ctx context.Context, query SearchFilters, fields CompanyOptFields) (uint64, error)
+	// DeleteMany moves every company Search would list for
+	// the query to trash and returns how many were moved.
+	DeleteMany(ctx context.Context, query SearchFilters) (uint64, error)
+	SearchDeleted(ctx context.Context, skip, limit uint64) ([]*models.Company, error)
+	Restore(ctx context.Context, id string) error
+	Purge(ctx context.Context, deletedBefore time.Time) (uint64, error)
+}
+
+// Transactor is implemented by stores able to apply several writes at
+// once. Writes made with the ctx passed to fn, history and events
+// included, are committed when fn returns nil and rolled back
+// otherwise, fn may be called again when the transaction is retried.
+// A transaction ctx already carries is joined.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// HistoryStore keeps company change entries, listed in the order
+// they were inserted.
+type HistoryStore interface {
+	InsertHistory(ctx context.Context, entry *models.HistoryEntry) error
+	SearchHistory(
+		ctx context.Context,
+		companyID string,
 		skip,
 		limit uint64,
-	) ([]*models.Company, error)
+	) ([]*models.HistoryEntry, error)
+	// HistoryAt returns the last entry recorded for the company at or
+	// before the given moment, or ErrNotFound if there is none.
+	HistoryAt(ctx context.Context, companyID string, at time.Time) (*models.HistoryEntry, error)
+}
+
+// OutboxStore keeps company events until they are delivered, events
+// are inserted within the transaction ctx carries.
+type OutboxStore interface {
+	InsertEvent(ctx context.Context, event *models.Event) error
+	// PendingEvents lists undelivered events, oldest first.
+	PendingEvents(ctx context.Context, limit uint64) ([]*models.Event, error)
+	// MarkDelivered keeps the event out of PendingEvents,
+	// ErrNotFound means there is no such pending event.
+	MarkDelivered(ctx context.Context, id string) error
+}
+
+// WebhookStore keeps webhook subscriptions and the deliveries of
+// company events to them.
+type WebhookStore interface {
+	InsertSubscription(ctx context.Context, subscription *models.Subscription) error
+	GetSubscription(ctx context.Context, id string) (*models.Subscription, error)
+	// ListSubscriptions returns every subscription, oldest first.
+	ListSubscriptions(ctx context.Context) ([]*models.Subscription, error)
+	// UpdateSubscription replaces the URL, event types and secret.
+	UpdateSubscription(ctx context.Context, subscription *models.Subscription) error
+	// DeleteSubscription removes the subscription and its deliveries.
+	DeleteSubscription(ctx context.Context, id string) error
+
+	// InsertDelivery returns ErrDuplicate when a delivery
+	// with the same ID was inserted before.
+	InsertDelivery(ctx context.Context, delivery *models.Delivery) error
+	GetDelivery(ctx context.Context, id string) (*models.Delivery, error)
+	// DueDeliveries lists pending deliveries with the next attempt
+	// at or before now, the earliest due first.
+	DueDeliveries(ctx context.Context, now time.Time, limit uint64) ([]*models.Delivery, error)
+	// UpdateDelivery saves the status and attempts of the delivery.
+	UpdateDelivery(ctx context.Context, delivery *models.Delivery) error
+	// SearchDeliveries lists deliveries of the subscription, oldest first.
+	SearchDeliveries(ctx context.Context, subscriptionID string, skip, limit uint64) ([]*models.Delivery, error)
 }
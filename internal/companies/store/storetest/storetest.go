@@ -0,0 +1,572 @@
+// Package storetest holds the behaviour every store backend shares,
+// the tests of each backend run it against their own stores.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+	"github.com/RavisMsk/xmcompanies/internal/companies/store"
+)
+
+// Companies are what the checks of Store expect it to hold.
+func Companies() []models.Company {
+	return []models.Company{
+		{ID: "1", Name: "First", Code: "FC", Country: "Cyprus"},
+		{ID: "2", Name: "Second", Code: "SC", Country: "Greece"},
+		{ID: "3", Name: "Third", Code: "TC", Country: "Cyprus"},
+	}
+}
+
+// GetMany checks Store.GetMany leaves out missing and trashed
+// companies on a store holding Companies.
+func GetMany(t *testing.T, s store.Store) {
+	assert.NoError(t, s.Delete(context.Background(), "3", store.AnyVersion))
+
+	companies, err := s.GetMany(context.Background(), []string{"2", "3", "1", "missing"})
+	assert.NoError(t, err)
+	var ids []string
+	for _, company := range companies {
+		ids = append(ids, company.ID)
+	}
+	assert.ElementsMatch(t, []string{"1", "2"}, ids)
+
+	companies, err = s.GetMany(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, companies)
+}
+
+// Each checks Store.Each order and early stop on a store holding Companies.
+func Each(t *testing.T, s store.Store) {
+	cyprus := "Cyprus"
+	var ids []string
+	err := s.Each(
+		context.Background(),
+		store.SearchFilters{Country: store.StringFilter{Eq: &cyprus}},
+		[]store.SortField{{Field: store.SortFieldName, Desc: true}},
+		func(company *models.Company) error {
+			ids = append(ids, company.ID)
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "1"}, ids)
+
+	stop := errors.New("stop")
+	ids = nil
+	err = s.Each(context.Background(), store.SearchFilters{}, nil, func(company *models.Company) error {
+		ids = append(ids, company.ID)
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{"1"}, ids)
+}
+
+// UpdateMany checks Store.UpdateMany on a store holding Companies.
+func UpdateMany(t *testing.T, s store.Store) {
+	cyprus, greece := "Cyprus", "Greece"
+	updated, err := s.UpdateMany(
+		context.Background(),
+		store.SearchFilters{Country: store.StringFilter{Eq: &cyprus}},
+		store.CompanyOptFields{Country: &greece},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), updated)
+
+	company, err := s.Get(context.Background(), "3")
+	assert.NoError(t, err)
+	assert.Equal(t, greece, company.Country)
+	assert.Equal(t, uint64(2), company.Version)
+	assert.NotNil(t, company.UpdatedAt)
+
+	code := "XC"
+	_, err = s.UpdateMany(
+		context.Background(),
+		store.SearchFilters{Country: store.StringFilter{Eq: &greece}},
+		store.CompanyOptFields{Code: &code},
+	)
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	company, err = s.Get(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "SC", company.Code)
+}
+
+// DeleteMany checks Store.DeleteMany on a store holding Companies.
+func DeleteMany(t *testing.T, s store.Store) {
+	prefix := "S"
+	deleted, err := s.DeleteMany(
+		context.Background(),
+		store.SearchFilters{Name: store.StringFilter{Prefix: &prefix}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), deleted)
+
+	_, err = s.Get(context.Background(), "2")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	trash, err := s.SearchDeleted(context.Background(), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(trash))
+	assert.Equal(t, trash[0].DeletedAt, trash[0].UpdatedAt)
+
+	count, err := s.Count(context.Background(), store.SearchFilters{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+// Outbox checks an empty OutboxStore.
+func Outbox(t *testing.T, o store.OutboxStore) {
+	for _, id := range []string{"e1", "e2", "e3"} {
+		event := models.Event{
+			ID:        id,
+			Type:      models.EventCompanyUpdated,
+			CompanyID: "1",
+			Company:   &models.CompanySnapshot{Name: "First", Version: 2},
+		}
+		assert.NoError(t, o.InsertEvent(context.Background(), &event))
+	}
+
+	events, err := o.PendingEvents(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "e1", events[0].ID)
+	assert.Equal(t, "e2", events[1].ID)
+	assert.Equal(t, "First", events[0].Company.Name)
+	assert.False(t, events[0].CreatedAt.IsZero())
+	assert.Nil(t, events[0].DeliveredAt)
+
+	assert.NoError(t, o.MarkDelivered(context.Background(), "e1"))
+	assert.Equal(t, store.ErrNotFound, o.MarkDelivered(context.Background(), "e1"))
+	assert.Equal(t, store.ErrNotFound, o.MarkDelivered(context.Background(), "missing"))
+
+	events, err = o.PendingEvents(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "e2", events[0].ID)
+	assert.Equal(t, "e3", events[1].ID)
+}
+
+// Webhooks checks an empty WebhookStore.
+func Webhooks(t *testing.T, w store.WebhookStore) {
+	ctx := context.Background()
+
+	subscriptions := []models.Subscription{
+		{ID: "s1", URL: "http://first", EventTypes: []string{models.EventCompanyCreated}, Secret: "one"},
+		{ID: "s2", URL: "http://second", Secret: "two"},
+	}
+	for idx := range subscriptions {
+		assert.NoError(t, w.InsertSubscription(ctx, &subscriptions[idx]))
+		time.Sleep(time.Millisecond)
+	}
+
+	subscription, err := w.GetSubscription(ctx, "s1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://first", subscription.URL)
+	assert.Equal(t, []string{models.EventCompanyCreated}, subscription.EventTypes)
+	assert.Nil(t, subscription.UpdatedAt)
+
+	subscription.URL = "http://changed"
+	subscription.EventTypes = nil
+	assert.NoError(t, w.UpdateSubscription(ctx, subscription))
+	_, err = w.GetSubscription(ctx, "missing")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, w.UpdateSubscription(ctx, &models.Subscription{ID: "missing"}))
+
+	listed, err := w.ListSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(listed))
+	assert.Equal(t, "s1", listed[0].ID)
+	assert.Equal(t, "http://changed", listed[0].URL)
+	assert.Empty(t, listed[0].EventTypes)
+	assert.NotNil(t, listed[0].UpdatedAt)
+	assert.Equal(t, "s2", listed[1].ID)
+
+	now := time.Now().Truncate(time.Millisecond)
+	deliveries := []models.Delivery{
+		{ID: "d1", SubscriptionID: "s1", EventID: "e1", Payload: []byte("{}"), NextAttemptAt: now},
+		{ID: "d2", SubscriptionID: "s2", EventID: "e1", Payload: []byte("{}"), NextAttemptAt: now.Add(-time.Second)},
+		{ID: "d3", SubscriptionID: "s1", EventID: "e2", Payload: []byte("{}"), NextAttemptAt: now.Add(time.Hour)},
+	}
+	for idx := range deliveries {
+		deliveries[idx].Status = models.DeliveryPending
+		assert.NoError(t, w.InsertDelivery(ctx, &deliveries[idx]))
+	}
+	assert.Equal(t, store.ErrDuplicate, w.InsertDelivery(ctx, &models.Delivery{ID: "d1", SubscriptionID: "s1", Payload: []byte("{}")}))
+
+	due, err := w.DueDeliveries(ctx, now, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(due))
+	assert.Equal(t, "d2", due[0].ID)
+	assert.Equal(t, "d1", due[1].ID)
+	assert.Equal(t, []byte("{}"), due[1].Payload)
+
+	delivery, err := w.GetDelivery(ctx, "d2")
+	assert.NoError(t, err)
+	delivery.Status = models.DeliverySucceeded
+	delivery.Attempts = 1
+	delivery.LastStatusCode = 200
+	assert.NoError(t, w.UpdateDelivery(ctx, delivery))
+	assert.Equal(t, store.ErrNotFound, w.UpdateDelivery(ctx, &models.Delivery{ID: "missing"}))
+
+	due, err = w.DueDeliveries(ctx, now, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, "d1", due[0].ID)
+
+	delivery, err = w.GetDelivery(ctx, "d2")
+	assert.NoError(t, err)
+	assert.Equal(t, models.DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, 200, delivery.LastStatusCode)
+	assert.NotNil(t, delivery.UpdatedAt)
+
+	logged, err := w.SearchDeliveries(ctx, "s1", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(logged))
+	assert.Equal(t, "d1", logged[0].ID)
+	assert.Equal(t, "d3", logged[1].ID)
+	logged, err = w.SearchDeliveries(ctx, "s1", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(logged))
+	assert.Equal(t, "d3", logged[0].ID)
+
+	assert.NoError(t, w.DeleteSubscription(ctx, "s1"))
+	assert.Equal(t, store.ErrNotFound, w.DeleteSubscription(ctx, "s1"))
+	_, err = w.GetDelivery(ctx, "d1")
+	assert.Equal(t, store.ErrNotFound, err)
+	due, err = w.DueDeliveries(ctx, now.Add(2*time.Hour), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+	listed, err = w.ListSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(listed))
+}
+
+// SearchOperators checks the string filter operators of Store.Search
+// on a store holding Companies.
+func SearchOperators(t *testing.T, s store.Store) {
+	search := func(query store.SearchFilters) []string {
+		results, _, err := s.Search(context.Background(), query, nil, "", 0)
+		assert.NoError(t, err)
+		ids := []string{}
+		for _, company := range results {
+			ids = append(ids, company.ID)
+		}
+		return ids
+	}
+
+	prefix, contains := "Th", "ECO"
+	assert.Equal(t, []string{"3"}, search(store.SearchFilters{Name: store.StringFilter{Prefix: &prefix}}))
+	assert.Equal(t, []string{"2"}, search(store.SearchFilters{Name: store.StringFilter{Contains: &contains}}))
+
+	lowerPrefix := "th"
+	assert.Equal(t, []string{}, search(store.SearchFilters{Name: store.StringFilter{Prefix: &lowerPrefix}}))
+
+	assert.Equal(t, []string{"1", "2", "3"}, search(store.SearchFilters{
+		Country: store.StringFilter{In: []string{"Cyprus", "Greece"}},
+	}))
+	assert.Equal(t, []string{"2"}, search(store.SearchFilters{
+		Country: store.StringFilter{In: []string{"Greece", "Malta"}},
+	}))
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	assert.Equal(t, []string{"1", "2", "3"}, search(store.SearchFilters{
+		CreatedAt: store.TimeRange{After: &past, Before: &future},
+	}))
+	assert.Equal(t, []string{}, search(store.SearchFilters{
+		CreatedAt: store.TimeRange{Before: &past},
+	}))
+
+	name := "Updated"
+	assert.NoError(t, s.Update(context.Background(), "3", store.AnyVersion, store.CompanyOptFields{Name: &name}))
+	assert.Equal(t, []string{"3"}, search(store.SearchFilters{
+		UpdatedAt: store.TimeRange{Before: &future},
+	}))
+}
+
+// SearchCursorStable checks Store.Search cursors keep their place across
+// writes on a store holding Companies.
+func SearchCursorStable(t *testing.T, s store.Store) {
+	results, next, err := s.Search(context.Background(), store.SearchFilters{}, nil, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "1", results[0].ID)
+	assert.Equal(t, "2", results[1].ID)
+
+	// Changes before the cursor must not shift the next page.
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	assert.NoError(t, s.Insert(context.Background(), &models.Company{
+		ID:      "4",
+		Name:    "Fourth",
+		Code:    "FRC",
+		Country: "Cyprus",
+	}))
+
+	results, next, err = s.Search(context.Background(), store.SearchFilters{}, nil, next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "3", results[0].ID)
+	assert.Equal(t, "4", results[1].ID)
+	assert.Empty(t, next)
+}
+
+// SearchSorted checks Store.Search order and paging by sort fields
+// on a store holding Companies.
+func SearchSorted(t *testing.T, s store.Store) {
+	byName := []store.SortField{{Field: store.SortFieldName, Desc: true}}
+	results, next, err := s.Search(context.Background(), store.SearchFilters{}, byName, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "3", results[0].ID)
+	assert.Equal(t, "2", results[1].ID)
+
+	results, next, err = s.Search(context.Background(), store.SearchFilters{}, byName, next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "1", results[0].ID)
+	assert.Empty(t, next)
+
+	byCountry := []store.SortField{{Field: store.SortFieldCountry}, {Field: store.SortFieldName, Desc: true}}
+	results, next, err = s.Search(context.Background(), store.SearchFilters{}, byCountry, "", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "3", results[0].ID)
+
+	// Cursors are bound to the order they were issued for.
+	_, _, err = s.Search(context.Background(), store.SearchFilters{}, byName, next, 1)
+	assert.Equal(t, store.ErrInvalidCursor, err)
+
+	results, _, err = s.Search(context.Background(), store.SearchFilters{}, byCountry, next, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "1", results[0].ID)
+	assert.Equal(t, "2", results[1].ID)
+}
+
+// TextSearch checks Store.TextSearch relevance order and paging
+// on a store holding Companies.
+func TextSearch(t *testing.T, s store.Store) {
+	for _, company := range []*models.Company{
+		{ID: "4", Name: "Acme Trading", Code: "ACME", Country: "Malta", Website: "https://acme.example"},
+		{ID: "5", Name: "Trading House", Code: "TH", Country: "Malta", Website: "trading.example"},
+	} {
+		assert.NoError(t, s.Insert(context.Background(), company))
+	}
+
+	text := "ACME, trading!"
+	query := store.SearchFilters{Text: &text}
+	results, next, err := s.TextSearch(context.Background(), query, "", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "4", results[0].Company.ID)
+	assert.InDelta(t, 20.667, results[0].Score, 0.001)
+	assert.NotEmpty(t, next)
+
+	results, next, err = s.TextSearch(context.Background(), query, next, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "5", results[0].Company.ID)
+	assert.Empty(t, next)
+
+	count, err := s.Count(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	name := "Trading Hall"
+	assert.NoError(t, s.Update(context.Background(), "5", store.AnyVersion, store.CompanyOptFields{Name: &name}))
+	assert.NoError(t, s.Delete(context.Background(), "4", store.AnyVersion))
+
+	house := "house"
+	results, _, err = s.TextSearch(context.Background(), store.SearchFilters{Text: &house}, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(results))
+
+	results, _, err = s.TextSearch(context.Background(), query, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "5", results[0].Company.ID)
+
+	ids, _, err := s.Search(context.Background(), query, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ids))
+}
+
+// Count checks Store.Count on a store holding Companies.
+func Count(t *testing.T, s store.Store) {
+	count, err := s.Count(context.Background(), store.SearchFilters{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	country := "Cyprus"
+	count, err = s.Count(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	count, err = s.Count(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+// VersionConflict checks versioned Store writes fail with ErrConflict
+// on a store holding Companies.
+func VersionConflict(t *testing.T, s store.Store) {
+	name := "Updated"
+	assert.Equal(t, store.ErrConflict, s.Update(context.Background(), "1", 2, store.CompanyOptFields{Name: &name}))
+	assert.NoError(t, s.Update(context.Background(), "1", 1, store.CompanyOptFields{Name: &name}))
+
+	company, err := s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), company.Version)
+
+	assert.Equal(t, store.ErrConflict, s.Delete(context.Background(), "1", 1))
+	assert.Equal(t, store.ErrNotFound, s.Delete(context.Background(), "4", 1))
+	assert.NoError(t, s.Delete(context.Background(), "1", 2))
+}
+
+// Trash checks deleted companies are listed, restored and purged
+// on a store holding Companies.
+func Trash(t *testing.T, s store.Store) {
+	assert.NoError(t, s.Delete(context.Background(), "1", store.AnyVersion))
+	results, _, err := s.Search(context.Background(), store.SearchFilters{}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+
+	deleted, err := s.SearchDeleted(context.Background(), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(deleted))
+	assert.Equal(t, "1", deleted[0].ID)
+	assert.NotNil(t, deleted[0].DeletedAt)
+	company, err := s.GetAny(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.NotNil(t, company.DeletedAt)
+	assert.Equal(t, company.DeletedAt, company.UpdatedAt)
+	_, err = s.GetAny(context.Background(), "missing")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	assert.NoError(t, s.Restore(context.Background(), "1"))
+	assert.Equal(t, store.ErrNotFound, s.Restore(context.Background(), "1"))
+	company, err = s.Get(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Nil(t, company.DeletedAt)
+	assert.Equal(t, uint64(3), company.Version)
+
+	assert.NoError(t, s.Delete(context.Background(), "2", store.AnyVersion))
+	purged, err := s.Purge(context.Background(), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), purged)
+	purged, err = s.Purge(context.Background(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), purged)
+
+	deleted, err = s.SearchDeleted(context.Background(), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(deleted))
+	assert.Equal(t, store.ErrNotFound, s.Restore(context.Background(), "2"))
+}
+
+// History checks an empty HistoryStore.
+func History(t *testing.T, h store.HistoryStore) {
+	entries := []models.HistoryEntry{
+		{CompanyID: "1", Action: models.HistoryActionCreate, After: &models.CompanySnapshot{Name: "First", Version: 1}},
+		{CompanyID: "2", Action: models.HistoryActionCreate, After: &models.CompanySnapshot{Name: "Second", Version: 1}},
+		{
+			CompanyID: "1",
+			Action:    models.HistoryActionUpdate,
+			Before:    &models.CompanySnapshot{Name: "First", Version: 1},
+			After:     &models.CompanySnapshot{Name: "Updated", Version: 2},
+			RequestID: "req",
+			ClientIP:  "127.0.0.1",
+		},
+	}
+	for idx := range entries {
+		assert.NoError(t, h.InsertHistory(context.Background(), &entries[idx]))
+		time.Sleep(time.Millisecond)
+	}
+
+	results, err := h.SearchHistory(context.Background(), "1", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, models.HistoryActionCreate, results[0].Action)
+	assert.Nil(t, results[0].Before)
+	assert.Equal(t, models.HistoryActionUpdate, results[1].Action)
+	assert.Equal(t, "First", results[1].Before.Name)
+	assert.Equal(t, "Updated", results[1].After.Name)
+	assert.Equal(t, "req", results[1].RequestID)
+	assert.False(t, results[1].CreatedAt.IsZero())
+
+	results, err = h.SearchHistory(context.Background(), "1", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, models.HistoryActionUpdate, results[0].Action)
+
+	entry, err := h.HistoryAt(context.Background(), "1", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, models.HistoryActionUpdate, entry.Action)
+
+	entry, err = h.HistoryAt(context.Background(), "1", entries[0].CreatedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, models.HistoryActionCreate, entry.Action)
+	assert.Equal(t, "First", entry.After.Name)
+
+	_, err = h.HistoryAt(context.Background(), "1", entries[0].CreatedAt.Add(-time.Nanosecond))
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+// UniqueCodeInCountry checks Store writes fail with *DuplicateError
+// for a code taken in the country on a store holding Companies.
+func UniqueCodeInCountry(t *testing.T, s store.Store) {
+	err := s.Insert(context.Background(), &models.Company{ID: "4", Name: "Fourth", Code: "FC", Country: "Cyprus"})
+	assert.True(t, errors.Is(err, store.ErrDuplicate))
+	var duplicate *store.DuplicateError
+	assert.True(t, errors.As(err, &duplicate))
+	assert.Equal(t, "1", duplicate.ExistingID)
+
+	assert.NoError(t, s.Insert(context.Background(), &models.Company{ID: "5", Name: "Fifth", Code: "FC", Country: "Greece"}))
+
+	code := "FC"
+	err = s.Update(context.Background(), "2", store.AnyVersion, store.CompanyOptFields{Code: &code})
+	assert.True(t, errors.As(err, &duplicate))
+	assert.Equal(t, "5", duplicate.ExistingID)
+	assert.NoError(t, s.Update(context.Background(), "1", store.AnyVersion, store.CompanyOptFields{Code: &code}))
+
+	assert.NoError(t, s.Delete(context.Background(), "3", store.AnyVersion))
+	err = s.Insert(context.Background(), &models.Company{ID: "6", Name: "Sixth", Code: "TC", Country: "Cyprus"})
+	assert.True(t, errors.As(err, &duplicate))
+	assert.Equal(t, "3", duplicate.ExistingID)
+}
+
+// Search checks Store.Search filters and paging on a store holding Companies.
+func Search(t *testing.T, s store.Store) {
+	results, next, err := s.Search(context.Background(), store.SearchFilters{}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(results))
+	assert.Empty(t, next)
+
+	country, name := "Cyprus", "Third"
+	results, _, err = s.Search(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}, Name: store.StringFilter{Eq: &name}}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "3", results[0].ID)
+
+	results, next, err = s.Search(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}}, nil, "", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "1", results[0].ID)
+	assert.NotEmpty(t, next)
+
+	results, next, err = s.Search(context.Background(), store.SearchFilters{Country: store.StringFilter{Eq: &country}}, nil, next, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "3", results[0].ID)
+	assert.Empty(t, next)
+
+	_, _, err = s.Search(context.Background(), store.SearchFilters{}, nil, "bogus", 1)
+	assert.Equal(t, store.ErrInvalidCursor, err)
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/RavisMsk/xmcompanies/internal/companies/models"
+)
+
+// TextWeights tells how much a term found in each of the text searched
+// fields adds to the relevance score.
+var TextWeights = []struct {
+	Field  string
+	Weight float64
+}{
+	{"name", 10},
+	{"code", 5},
+	{"website", 1},
+}
+
+type ScoredCompany struct {
+	Company *models.Company
+	Score   float64
+}
+
+// Tokenize lowercases the text and splits it into letter
+// and digit runs, "www.acme-trading.com" gives www, acme,
+// trading and com.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// TextTerms returns unique query tokens, a company matches
+// when it contains any of them.
+func TextTerms(text string) []string {
+	var (
+		terms []string
+		seen  = map[string]bool{}
+	)
+	for _, token := range Tokenize(text) {
+		if !seen[token] {
+			seen[token] = true
+			terms = append(terms, token)
+		}
+	}
+	return terms
+}
+
+// CompanyTerms returns unique tokens of the text searched fields,
+// that is the terms an inverted index should map to the company.
+func CompanyTerms(company *models.Company) []string {
+	return TextTerms(company.Name + " " + company.Code + " " + company.Website)
+}
+
+// TextScore rates how well the company matches the terms, zero means
+// no match. Every term found in a field adds the field weight, scaled
+// up with the share of field tokens it makes.
+func TextScore(company *models.Company, terms []string) float64 {
+	var score float64
+	for _, field := range TextWeights {
+		tokens := Tokenize(textField(company, field.Field))
+		if len(tokens) < 1 {
+			continue
+		}
+		for _, term := range terms {
+			var occurrences int
+			for _, token := range tokens {
+				if token == term {
+					occurrences++
+				}
+			}
+			if occurrences > 0 {
+				score += field.Weight * (0.5 + 0.5*float64(occurrences)/float64(len(tokens)))
+			}
+		}
+	}
+	return score
+}
+
+func textField(company *models.Company, field string) string {
+	switch field {
+	case "name":
+		return company.Name
+	case "code":
+		return company.Code
+	case "website":
+		return company.Website
+	}
+	return ""
+}
+
+// TextCursor is a keyset position in relevance order,
+// score descending and id ascending.
+type TextCursor struct {
+	Score float64
+	ID    string
+}
+
+type textCursorToken struct {
+	Score *float64 `json:"sc"`
+	ID    string   `json:"i"`
+}
+
+func (c TextCursor) Encode() string {
+	data, _ := json.Marshal(textCursorToken{&c.Score, c.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeTextCursor(token string) (*TextCursor, error) {
+	if len(token) < 1 {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded textCursorToken
+	// Search cursors have no score, they can't be used here.
+	if err = json.Unmarshal(data, &decoded); err != nil || len(decoded.ID) < 1 || decoded.Score == nil {
+		return nil, ErrInvalidCursor
+	}
+	return &TextCursor{*decoded.Score, decoded.ID}, nil
+}
+
+func (c *TextCursor) After(result *ScoredCompany) bool {
+	if c == nil {
+		return true
+	}
+	if result.Score != c.Score {
+		return result.Score < c.Score
+	}
+	return result.Company.ID > c.ID
+}
+
+// RankText scores companies against the terms, drops the ones not
+// matching or not after the cursor and sorts the rest best first.
+func RankText(companies []*models.Company, terms []string, after *TextCursor) []*ScoredCompany {
+	var results []*ScoredCompany
+	for _, company := range companies {
+		result := &ScoredCompany{company, TextScore(company, terms)}
+		if result.Score > 0 && after.After(result) {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Company.ID < results[j].Company.ID
+	})
+	return results
+}
+
+// PageText is Page for relevance ordered results.
+func PageText(results []*ScoredCompany, limit uint64) ([]*ScoredCompany, string) {
+	if limit == 0 || uint64(len(results)) <= limit {
+		return results, ""
+	}
+	results = results[:limit]
+	last := results[len(results)-1]
+	return results, TextCursor{last.Score, last.Company.ID}.Encode()
+}
@@ -0,0 +1,23 @@
+// Package backoff spaces out retries of failed work.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Jittered is the wait after the given failed attempt, counted from
+// one. It starts at base and doubles for every next attempt up to max,
+// half of each wait is random so many retrying callers spread out.
+func Jittered(base, max time.Duration, attempt int) time.Duration {
+	wait := max
+	// Shifting past max would overflow for late attempts.
+	if shift := attempt - 1; shift < 63 && base <= max>>shift {
+		wait = base << shift
+	}
+	if wait <= 0 {
+		wait = max
+	}
+	half := int64(wait / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,28 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJittered(t *testing.T) {
+	for _, c := range []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	} {
+		wait := Jittered(time.Second, 5*time.Second, c.attempt)
+		assert.True(t, wait >= c.expected/2 && wait <= c.expected, "attempt %d waits %s", c.attempt, wait)
+	}
+
+	// Doubling this base 31 times wraps around to about two seconds.
+	wait := Jittered(1<<33+1, time.Minute, 32)
+	assert.True(t, wait >= 30*time.Second, "wrapped around to %s", wait)
+}
@@ -0,0 +1,278 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and
+// JSON Patch (RFC 6902) documents to JSON documents.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrPath         = errors.New("path error")
+	ErrTestFailed   = errors.New("test failed")
+)
+
+// MergePatch applies an RFC 7396 merge patch, null
+// values in the patch remove members.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, merge interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &merge); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergePatch(target, merge))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	// Value is raw to tell a null value from a missing one.
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies an RFC 6902 patch operation by operation, the first
+// failing one fails the whole patch. Errors match ErrInvalidPatch,
+// ErrPath or ErrTestFailed with errors.Is.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	var operations []Operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	for idx, operation := range operations {
+		var err error
+		if root, err = apply(root, operation); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", idx, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func apply(root interface{}, operation Operation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) < 1 {
+			return nil, fmt.Errorf("%w: %s without value", ErrInvalidPatch, operation.Op)
+		}
+		if err = json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return add(root, path, value)
+	case "remove":
+		root, _, err = remove(root, path)
+		return root, err
+	case "replace":
+		if root, _, err = remove(root, path); err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if operation.Op == "copy" {
+			if value, err = get(root, from); err != nil {
+				return nil, err
+			}
+			return add(root, path, deepCopy(value))
+		}
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, fmt.Errorf("%w: cannot move %s into itself", ErrPath, operation.From)
+		}
+		if root, value, err = remove(root, from); err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "test":
+		current, err := get(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrTestFailed, operation.Path)
+		}
+		return root, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, operation.Op)
+}
+
+// parsePointer splits an RFC 6901 pointer into unescaped tokens,
+// the empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if len(pointer) < 1 {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("%w: invalid pointer %q", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for idx, token := range tokens {
+		tokens[idx] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func get(root interface{}, path []string) (interface{}, error) {
+	node := root
+	for _, token := range path {
+		var err error
+		if node, err = child(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func add(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) < 1 {
+		return value, nil
+	}
+	return modify(root, path, func(container interface{}, token string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			if token == "-" {
+				return append(container, value), nil
+			}
+			idx, err := arrayIndex(token, len(container)+1)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[idx+1:], container[idx:])
+			container[idx] = value
+			return container, nil
+		}
+		return nil, fmt.Errorf("%w: %s is not a container", ErrPath, token)
+	})
+}
+
+func remove(root interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) < 1 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrPath)
+	}
+	var removed interface{}
+	root, err := modify(root, path, func(container interface{}, token string) (interface{}, error) {
+		var err error
+		if removed, err = child(container, token); err != nil {
+			return nil, err
+		}
+		switch container := container.(type) {
+		case map[string]interface{}:
+			delete(container, token)
+			return container, nil
+		case []interface{}:
+			idx, _ := arrayIndex(token, len(container))
+			return append(container[:idx], container[idx+1:]...), nil
+		}
+		return container, nil
+	})
+	return root, removed, err
+}
+
+// modify calls fn with the container the path points into and the
+// last token, putting the container fn returns back in its parent.
+func modify(
+	node interface{},
+	path []string,
+	fn func(container interface{}, token string) (interface{}, error),
+) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+	next, err := child(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if next, err = modify(next, path[1:], fn); err != nil {
+		return nil, err
+	}
+	switch node := node.(type) {
+	case map[string]interface{}:
+		node[path[0]] = next
+	case []interface{}:
+		idx, _ := arrayIndex(path[0], len(node))
+		node[idx] = next
+	}
+	return node, nil
+}
+
+func child(node interface{}, token string) (interface{}, error) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		value, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q not found", ErrPath, token)
+		}
+		return value, nil
+	case []interface{}:
+		idx, err := arrayIndex(token, len(node))
+		if err != nil {
+			return nil, err
+		}
+		return node[idx], nil
+	}
+	return nil, fmt.Errorf("%w: %q is not in a container", ErrPath, token)
+}
+
+// arrayIndex parses an array index token, it must be below limit.
+func arrayIndex(token string, limit int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPath, token)
+	}
+	if idx >= limit {
+		return 0, fmt.Errorf("%w: array index %d out of bounds", ErrPath, idx)
+	}
+	return idx, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	data, _ := json.Marshal(value)
+	var copied interface{}
+	_ = json.Unmarshal(data, &copied)
+	return copied
+}
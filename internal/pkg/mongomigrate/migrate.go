@@ -0,0 +1,145 @@
+package mongomigrate
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const collectionName = "migrations"
+
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies migrations in version order and records applied
+// versions in the migrations collection of the same database.
+type Migrator struct {
+	db         *mongo.Database
+	col        *mongo.Collection
+	migrations []Migration
+}
+
+func NewMigrator(db *mongo.Database, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return &Migrator{
+		db:         db,
+		col:        db.Collection(collectionName),
+		migrations: sorted,
+	}
+}
+
+// Up applies all pending migrations and returns the applied versions.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err = migration.Up(ctx, m.db); err != nil {
+			return versions, errors.Wrapf(err, "error applying migration %d", migration.Version)
+		}
+		_, err = m.col.InsertOne(ctx, appliedMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil {
+			return versions, errors.Wrapf(err, "error recording migration %d", migration.Version)
+		}
+		versions = append(versions, migration.Version)
+	}
+	return versions, nil
+}
+
+// Down reverts the latest applied migration, it returns zero
+// version if there was nothing to revert.
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for idx := len(m.migrations) - 1; idx >= 0; idx-- {
+		migration := m.migrations[idx]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err = migration.Down(ctx, m.db); err != nil {
+			return 0, errors.Wrapf(err, "error reverting migration %d", migration.Version)
+		}
+		_, err = m.col.DeleteOne(ctx, bson.M{"version": migration.Version})
+		if err != nil {
+			return 0, errors.Wrapf(err, "error unrecording migration %d", migration.Version)
+		}
+		return migration.Version, nil
+	}
+	return 0, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for idx, migration := range m.migrations {
+		statuses[idx] = Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+		}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			statuses[idx].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	cursor, err := m.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading applied migrations")
+	}
+
+	var records []appliedMigration
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, errors.Wrap(err, "error reading applied migrations")
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
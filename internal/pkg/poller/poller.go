@@ -0,0 +1,58 @@
+// Package poller runs background work polling for something to do.
+package poller
+
+import "time"
+
+// Poller calls poll right away and then every interval until stopped.
+// While poll reports more work waiting it is called again without
+// waiting for the interval.
+type Poller struct {
+	interval time.Duration
+	poll     func() (more bool)
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func New(interval time.Duration, poll func() (more bool)) *Poller {
+	return &Poller{
+		interval: interval,
+		poll:     poll,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *Poller) Start() {
+	p.started = true
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			for p.poll() {
+				select {
+				case <-p.stop:
+					return
+				default:
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for a poll in progress to finish, it
+// may be called on a poller that was never started.
+func (p *Poller) Stop() {
+	close(p.stop)
+	if p.started {
+		<-p.done
+	}
+}
@@ -0,0 +1,29 @@
+package poller
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	var polls int32
+	done := make(chan struct{})
+	p := New(time.Hour, func() bool {
+		// More work for the first three polls, none afterwards.
+		if atomic.AddInt32(&polls, 1) == 4 {
+			close(done)
+		}
+		return atomic.LoadInt32(&polls) < 4
+	})
+	p.Start()
+	<-done
+	p.Stop()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&polls))
+}
+
+func TestStopUnstarted(t *testing.T) {
+	New(time.Hour, func() bool { return false }).Stop()
+}